@@ -4,10 +4,17 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
+	"real-time-forum/internals/attachments"
+	"real-time-forum/internals/avatar"
 	"real-time-forum/internals/database"
+	"real-time-forum/internals/digest"
 	"real-time-forum/internals/handlers"
+	"real-time-forum/internals/mail"
+	"real-time-forum/internals/ratelimit"
+	"real-time-forum/internals/storage"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -21,7 +28,50 @@ func main() {
 	}
 	defer db.Close()
 
+	// Load hot ranking weights and rebuild scores with them
+	if err := db.SetHotRankConfig(database.HotRankConfigFromEnv()); err != nil {
+		log.Fatalf("Failed to initialize hot ranking: %v", err)
+	}
+
+	db.ReportThreshold = database.ReportThresholdFromEnv()
+
+	// Bootstrap administrators from a comma separated list of nicknames
+	if admins := os.Getenv("FORUM_ADMINS"); admins != "" {
+		if err := db.PromoteAdmins(strings.Split(admins, ",")); err != nil {
+			log.Fatalf("Failed to promote admins: %v", err)
+		}
+	}
+
 	handler := handlers.NewHandler(db)
+	handler.Limiter = ratelimit.New(ratelimit.ConfigFromEnv())
+	handler.Storage, err = storage.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize upload storage: %v", err)
+	}
+	handler.Avatars = avatar.New(handler.Storage, avatar.ConfigFromEnv())
+	handler.Attachments = attachments.ConfigFromEnv()
+	go attachments.NewCollector(db, handler.Storage, handler.Attachments).Run()
+
+	// FORUM_TRUST_PROXY is "true" behind a single reverse proxy, or the
+	// number of proxies in a chain
+	switch proxies := os.Getenv("FORUM_TRUST_PROXY"); proxies {
+	case "", "false":
+	case "true":
+		handler.TrustedProxies = 1
+	default:
+		n, err := strconv.Atoi(proxies)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid FORUM_TRUST_PROXY=%q, must be true, false or a number of proxies", proxies)
+		}
+		handler.TrustedProxies = n
+	}
+
+	// Email digests run only when a mail backend is configured
+	if mailer := mail.FromEnv(); mailer != nil {
+		digestConfig := digest.ConfigFromEnv()
+		handler.DigestSecret = digestConfig.Secret
+		go digest.New(db, mailer, digestConfig).Run()
+	}
 
 	// Serve static files
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
@@ -61,6 +111,8 @@ func apiRouter(w http.ResponseWriter, r *http.Request, h *handlers.Handler) {
 		h.GetPosts(w, r)
 	case path == "/posts" && method == http.MethodPost:
 		h.CreatePost(w, r)
+	case path == "/posts/read-all" && method == http.MethodPost:
+		h.MarkAllRead(w, r)
 	case strings.HasPrefix(path, "/posts/") && strings.HasSuffix(path, "/comments"):
 		parts := strings.Split(path, "/")
 		if len(parts) >= 3 {
@@ -71,9 +123,205 @@ func apiRouter(w http.ResponseWriter, r *http.Request, h *handlers.Handler) {
 				h.AddComment(w, r, postID)
 			}
 		}
+	case strings.HasPrefix(path, "/posts/") && strings.HasSuffix(path, "/subscription"):
+		postID := strings.TrimSuffix(strings.TrimPrefix(path, "/posts/"), "/subscription")
+		if method == http.MethodPut {
+			h.SetSubscription(w, r, database.TargetPost, postID)
+		} else if method == http.MethodDelete {
+			h.DeleteSubscription(w, r, database.TargetPost, postID)
+		}
+	case strings.HasPrefix(path, "/posts/") && strings.HasSuffix(path, "/reactions") && method == http.MethodPost:
+		postID := strings.TrimSuffix(strings.TrimPrefix(path, "/posts/"), "/reactions")
+		h.ToggleReaction(w, r, postID)
 	case strings.HasPrefix(path, "/posts/") && method == http.MethodGet:
 		postID := strings.TrimPrefix(path, "/posts/")
 		h.GetPostByID(w, r, postID)
+	case strings.HasPrefix(path, "/posts/") && method == http.MethodDelete:
+		h.DeletePost(w, r, strings.TrimPrefix(path, "/posts/"))
+	case path == "/tags" && method == http.MethodGet:
+		h.SearchTags(w, r)
+	case strings.HasPrefix(path, "/tags/") && method == http.MethodGet:
+		h.GetTagPage(w, r, strings.TrimPrefix(path, "/tags/"))
+	case path == "/admin/categories" && method == http.MethodPost:
+		h.CreateCategory(w, r)
+	case path == "/admin/categories/order" && method == http.MethodPut:
+		h.ReorderCategories(w, r)
+	case strings.HasPrefix(path, "/admin/categories/") && strings.HasSuffix(path, "/merge") && method == http.MethodPost:
+		categoryID := strings.TrimSuffix(strings.TrimPrefix(path, "/admin/categories/"), "/merge")
+		h.MergeCategory(w, r, categoryID)
+	case strings.HasPrefix(path, "/admin/categories/") && strings.HasSuffix(path, "/settings") && method == http.MethodPut:
+		categoryID := strings.TrimSuffix(strings.TrimPrefix(path, "/admin/categories/"), "/settings")
+		h.UpdateCategorySettings(w, r, categoryID)
+	case strings.HasPrefix(path, "/categories/") && strings.HasSuffix(path, "/settings") && method == http.MethodGet:
+		categoryID := strings.TrimSuffix(strings.TrimPrefix(path, "/categories/"), "/settings")
+		h.GetCategorySettings(w, r, categoryID)
+	case strings.HasPrefix(path, "/categories/") && strings.HasSuffix(path, "/rooms") && method == http.MethodGet:
+		h.ListRooms(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/categories/"), "/rooms"))
+	case strings.HasPrefix(path, "/categories/") && strings.HasSuffix(path, "/rooms") && method == http.MethodPost:
+		h.CreateRoom(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/categories/"), "/rooms"))
+	case strings.HasPrefix(path, "/categories/") && strings.HasSuffix(path, "/read") && method == http.MethodPost:
+		categoryID := strings.TrimSuffix(strings.TrimPrefix(path, "/categories/"), "/read")
+		h.MarkCategoryRead(w, r, categoryID)
+	case strings.HasPrefix(path, "/categories/") && strings.HasSuffix(path, "/subscription"):
+		categoryID := strings.TrimSuffix(strings.TrimPrefix(path, "/categories/"), "/subscription")
+		if method == http.MethodPut {
+			h.SetSubscription(w, r, database.TargetCategory, categoryID)
+		} else if method == http.MethodDelete {
+			h.DeleteSubscription(w, r, database.TargetCategory, categoryID)
+		}
+	case strings.HasPrefix(path, "/admin/categories/") && method == http.MethodPatch:
+		h.UpdateCategory(w, r, strings.TrimPrefix(path, "/admin/categories/"))
+	case strings.HasPrefix(path, "/admin/users/") && strings.HasSuffix(path, "/role") && method == http.MethodPut:
+		userID := strings.TrimSuffix(strings.TrimPrefix(path, "/admin/users/"), "/role")
+		h.SetUserRole(w, r, userID)
+	case strings.HasPrefix(path, "/admin/users/") && strings.HasSuffix(path, "/moderated-categories") && method == http.MethodPost:
+		userID := strings.TrimSuffix(strings.TrimPrefix(path, "/admin/users/"), "/moderated-categories")
+		h.GrantCategoryModerator(w, r, userID)
+	case strings.HasPrefix(path, "/admin/users/") && strings.Contains(path, "/moderated-categories/") && method == http.MethodDelete:
+		userID, categoryID, _ := strings.Cut(strings.TrimPrefix(path, "/admin/users/"), "/moderated-categories/")
+		h.RevokeCategoryModerator(w, r, userID, categoryID)
+	case strings.HasPrefix(path, "/admin/users/") && strings.HasSuffix(path, "/sanctions"):
+		userID := strings.TrimSuffix(strings.TrimPrefix(path, "/admin/users/"), "/sanctions")
+		if method == http.MethodGet {
+			h.GetUserSanctions(w, r, userID)
+		} else if method == http.MethodPost {
+			h.SanctionUser(w, r, userID)
+		}
+	case strings.HasPrefix(path, "/admin/users/") && strings.Contains(path, "/sanctions/") && method == http.MethodDelete:
+		userID, sanctionID, _ := strings.Cut(strings.TrimPrefix(path, "/admin/users/"), "/sanctions/")
+		h.LiftSanction(w, r, userID, sanctionID)
+	case path == "/me/settings" && method == http.MethodGet:
+		h.GetMySettings(w, r)
+	case path == "/me/settings" && method == http.MethodPut:
+		h.UpdateMySettings(w, r)
+	case path == "/subscriptions" && method == http.MethodGet:
+		h.GetSubscriptions(w, r)
+	case path == "/subscriptions/threads" && method == http.MethodGet:
+		h.GetWatchedThreads(w, r)
+	case path == "/digest/unsubscribe" && method == http.MethodGet:
+		h.ConfirmUnsubscribeDigest(w, r)
+	case path == "/digest/unsubscribe" && method == http.MethodPost:
+		h.UnsubscribeDigest(w, r)
+	case path == "/notifications" && method == http.MethodGet:
+		h.GetNotifications(w, r)
+	case path == "/notifications/unread-count" && method == http.MethodGet:
+		h.GetUnreadNotificationCount(w, r)
+	case path == "/notifications/read-all" && method == http.MethodPost:
+		h.MarkAllNotificationsRead(w, r)
+	case strings.HasPrefix(path, "/notifications/") && strings.HasSuffix(path, "/read") && method == http.MethodPost:
+		notificationID := strings.TrimSuffix(strings.TrimPrefix(path, "/notifications/"), "/read")
+		h.MarkNotificationRead(w, r, notificationID)
+	case path == "/users/autocomplete" && method == http.MethodGet:
+		h.AutocompleteUsers(w, r)
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/posts") && method == http.MethodGet:
+		h.GetUserPosts(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/posts"))
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/comments") && method == http.MethodGet:
+		h.GetUserComments(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/comments"))
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/avatar") && method == http.MethodGet:
+		h.GetUserAvatar(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/avatar"))
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/followers") && method == http.MethodGet:
+		h.GetFollowers(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/followers"))
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/following") && method == http.MethodGet:
+		h.GetFollowing(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/following"))
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/follow") && method == http.MethodPut:
+		h.FollowUser(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/follow"))
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/follow") && method == http.MethodDelete:
+		h.UnfollowUser(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/follow"))
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/block") && method == http.MethodPut:
+		h.SetBlock(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/block"), database.BlockLevelBlock)
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/block") && method == http.MethodDelete:
+		h.DeleteBlock(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/block"), database.BlockLevelBlock)
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/mute") && method == http.MethodPut:
+		h.SetBlock(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/mute"), database.BlockLevelMute)
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/mute") && method == http.MethodDelete:
+		h.DeleteBlock(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/mute"), database.BlockLevelMute)
+	case strings.HasPrefix(path, "/users/") && method == http.MethodGet:
+		h.GetProfile(w, r, strings.TrimPrefix(path, "/users/"))
+	case path == "/attachments" && method == http.MethodPost:
+		h.UploadAttachment(w, r)
+	case strings.HasPrefix(path, "/attachments/") && strings.HasSuffix(path, "/thumbnail") && method == http.MethodGet:
+		h.ServeAttachment(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/attachments/"), "/thumbnail"), true)
+	case strings.HasPrefix(path, "/attachments/") && method == http.MethodGet:
+		h.ServeAttachment(w, r, strings.TrimPrefix(path, "/attachments/"), false)
+	case strings.HasPrefix(path, "/avatars/") && method == http.MethodGet:
+		h.ServeAvatar(w, r, strings.TrimPrefix(path, "/avatars/"))
+	case path == "/me" && method == http.MethodGet:
+		h.GetMyProfile(w, r)
+	case path == "/me/avatar" && method == http.MethodPut:
+		h.UploadAvatar(w, r)
+	case path == "/me/avatar" && method == http.MethodDelete:
+		h.DeleteAvatar(w, r)
+	case path == "/me" && method == http.MethodPatch:
+		h.UpdateMyProfile(w, r)
+	case path == "/me/blocks" && method == http.MethodGet:
+		h.GetBlocks(w, r)
+	case path == "/me/sanctions" && method == http.MethodGet:
+		h.GetMySanctions(w, r)
+	case path == "/events" && method == http.MethodGet:
+		h.StreamEvents(w, r)
+	case path == "/admin/automod/rules" && method == http.MethodGet:
+		h.GetAutomodRules(w, r)
+	case path == "/admin/automod/rules" && method == http.MethodPost:
+		h.CreateAutomodRule(w, r)
+	case strings.HasPrefix(path, "/admin/automod/rules/") && method == http.MethodPut:
+		h.UpdateAutomodRule(w, r, strings.TrimPrefix(path, "/admin/automod/rules/"))
+	case strings.HasPrefix(path, "/admin/automod/rules/") && method == http.MethodDelete:
+		h.DeleteAutomodRule(w, r, strings.TrimPrefix(path, "/admin/automod/rules/"))
+	case path == "/admin/automod/log" && method == http.MethodGet:
+		h.GetAutomodLog(w, r)
+	case path == "/admin/role-audit" && method == http.MethodGet:
+		h.GetRoleAudit(w, r)
+	case path == "/conversations" && method == http.MethodGet:
+		h.ListConversations(w, r)
+	case path == "/conversations" && method == http.MethodPost:
+		h.CreateConversation(w, r)
+	case path == "/conversations/unread-count" && method == http.MethodGet:
+		h.GetUnreadMessageCount(w, r)
+	case strings.HasPrefix(path, "/conversations/") && strings.HasSuffix(path, "/messages") && method == http.MethodGet:
+		h.GetConversationMessages(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/conversations/"), "/messages"))
+	case strings.HasPrefix(path, "/conversations/") && strings.HasSuffix(path, "/messages") && method == http.MethodPost:
+		h.SendConversationMessage(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/conversations/"), "/messages"))
+	case strings.HasPrefix(path, "/conversations/") && strings.HasSuffix(path, "/read") && method == http.MethodPost:
+		h.MarkConversationRead(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/conversations/"), "/read"))
+	case strings.HasPrefix(path, "/conversations/") && strings.HasSuffix(path, "/typing") && method == http.MethodPost:
+		h.SendTyping(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/conversations/"), "/typing"))
+	case strings.HasPrefix(path, "/conversations/") && strings.HasSuffix(path, "/join") && method == http.MethodPost:
+		h.JoinRoom(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/conversations/"), "/join"))
+	case strings.HasPrefix(path, "/conversations/") && strings.HasSuffix(path, "/members") && method == http.MethodPost:
+		h.AddConversationMember(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/conversations/"), "/members"))
+	case strings.HasPrefix(path, "/conversations/") && strings.Contains(path, "/members/") && method == http.MethodDelete:
+		conversationID, memberID, _ := strings.Cut(strings.TrimPrefix(path, "/conversations/"), "/members/")
+		h.RemoveConversationMember(w, r, conversationID, memberID)
+	case strings.HasPrefix(path, "/conversations/") && strings.Contains(path, "/bans/") && method == http.MethodDelete:
+		conversationID, memberID, _ := strings.Cut(strings.TrimPrefix(path, "/conversations/"), "/bans/")
+		h.UnbanConversationMember(w, r, conversationID, memberID)
+	case strings.HasPrefix(path, "/conversations/") && strings.Contains(path, "/members/") && method == http.MethodPut:
+		conversationID, memberID, _ := strings.Cut(strings.TrimPrefix(path, "/conversations/"), "/members/")
+		h.SetConversationMemberRole(w, r, conversationID, memberID)
+	case strings.HasPrefix(path, "/conversations/") && method == http.MethodGet:
+		h.GetConversation(w, r, strings.TrimPrefix(path, "/conversations/"))
+	case strings.HasPrefix(path, "/conversations/") && method == http.MethodPatch:
+		h.RenameConversation(w, r, strings.TrimPrefix(path, "/conversations/"))
+	case strings.HasPrefix(path, "/messages/") && strings.HasSuffix(path, "/reactions") && method == http.MethodPost:
+		h.ToggleMessageReaction(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/messages/"), "/reactions"))
+	case strings.HasPrefix(path, "/messages/") && method == http.MethodPatch:
+		h.EditMessage(w, r, strings.TrimPrefix(path, "/messages/"))
+	case strings.HasPrefix(path, "/messages/") && method == http.MethodDelete:
+		h.DeleteMessage(w, r, strings.TrimPrefix(path, "/messages/"))
+	case path == "/chat/messages" && method == http.MethodGet:
+		h.GetChatMessages(w, r)
+	case path == "/chat/send" && method == http.MethodPost:
+		h.SendChatMessage(w, r)
+	case path == "/reports" && method == http.MethodPost:
+		h.ReportContent(w, r)
+	case path == "/moderation/reports" && method == http.MethodGet:
+		h.GetModerationQueue(w, r)
+	case path == "/moderation/actions" && method == http.MethodPost:
+		h.ModerateContent(w, r)
+	case strings.HasPrefix(path, "/moderation/messages/") && strings.HasSuffix(path, "/history") && method == http.MethodGet:
+		h.GetMessageHistory(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/moderation/messages/"), "/history"))
+	case path == "/moderation/log" && method == http.MethodGet:
+		h.GetModerationLog(w, r)
 	case path == "/logout" && method == http.MethodPost:
 		h.Logout(w, r)
 	case path == "/online-users" && method == http.MethodGet:
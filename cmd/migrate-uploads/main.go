@@ -0,0 +1,106 @@
+// Command migrate-uploads copies the uploads kept on local disk, under
+// FORUM_UPLOAD_DIR, into the bucket configured with the FORUM_S3_*
+// variables, so the forum can be switched to FORUM_STORAGE=s3. Keys stay
+// the same, so the database needs no change. Objects already in the bucket
+// are skipped unless -overwrite is given, so an interrupted run can simply
+// be started again.
+package main
+
+import (
+	"flag"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"real-time-forum/internals/storage"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "list what would be copied without copying")
+	overwrite := flag.Bool("overwrite", false, "copy files even when the bucket already has them")
+	flag.Parse()
+
+	dir := os.Getenv("FORUM_UPLOAD_DIR")
+	if dir == "" {
+		dir = storage.DefaultDir
+	}
+	bucket, err := storage.S3FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure S3: %v", err)
+	}
+
+	var copied, skipped, failed int
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Leftovers of interrupted writes are not uploads
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		if !*overwrite {
+			exists, err := bucket.Exists(key)
+			if err != nil {
+				log.Printf("Error checking %s: %v", key, err)
+				failed++
+				return nil
+			}
+			if exists {
+				skipped++
+				return nil
+			}
+		}
+		if *dryRun {
+			log.Printf("Would copy %s", key)
+			copied++
+			return nil
+		}
+		if err := copyFile(bucket, path, key); err != nil {
+			log.Printf("Error copying %s: %v", key, err)
+			failed++
+			return nil
+		}
+		copied++
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed to walk %s: %v", dir, err)
+	}
+
+	log.Printf("Copied %d files, skipped %d already in the bucket, %d failed", copied, skipped, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func copyFile(bucket *storage.S3, path, key string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return bucket.Put(key, f, info.Size(), http.DetectContentType(head[:n]))
+}
@@ -0,0 +1,128 @@
+// Package attachments decides which uploaded files are accepted as
+// attachments, renders image thumbnails and collects files nothing links to.
+package attachments
+
+import (
+	"bytes"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/internals/imaging"
+)
+
+// Config sets upload limits and how garbage collection runs
+type Config struct {
+	MaxBytes     int64         // largest accepted file
+	Quota        int64         // total bytes of attachments per user
+	MaxDimension int           // largest image width or height given a thumbnail
+	UnlinkedTTL  time.Duration // how long an upload may wait to be attached
+	GCInterval   time.Duration // how often to collect expired uploads
+}
+
+// DefaultConfig accepts files of up to 10 MiB, 100 MiB per user, and keeps
+// unattached uploads for a day
+var DefaultConfig = Config{
+	MaxBytes:     10 << 20,
+	Quota:        100 << 20,
+	MaxDimension: 8192,
+	UnlinkedTTL:  24 * time.Hour,
+	GCInterval:   time.Hour,
+}
+
+// ConfigFromEnv reads FORUM_ATTACHMENT_MAX_BYTES, FORUM_ATTACHMENT_QUOTA,
+// FORUM_ATTACHMENT_UNLINKED_TTL and FORUM_ATTACHMENT_GC_INTERVAL, falling
+// back to DefaultConfig
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig
+	for name, value := range map[string]*int64{
+		"FORUM_ATTACHMENT_MAX_BYTES": &cfg.MaxBytes,
+		"FORUM_ATTACHMENT_QUOTA":     &cfg.Quota,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+				*value = n
+			} else {
+				log.Printf("ignoring invalid %s=%q", name, v)
+			}
+		}
+	}
+	for name, value := range map[string]*time.Duration{
+		"FORUM_ATTACHMENT_UNLINKED_TTL": &cfg.UnlinkedTTL,
+		"FORUM_ATTACHMENT_GC_INTERVAL":  &cfg.GCInterval,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d > 0 {
+				*value = d
+			} else {
+				log.Printf("ignoring invalid %s=%q", name, v)
+			}
+		}
+	}
+	return cfg
+}
+
+// contentTypes maps the sniffed types of accepted files to the type they are
+// served with. Anything a browser could run, such as HTML or SVG, sniffs as
+// something else and is refused.
+var contentTypes = map[string]string{
+	"image/png":                 "image/png",
+	"image/jpeg":                "image/jpeg",
+	"image/gif":                 "image/gif",
+	"image/webp":                "image/webp",
+	"application/pdf":           "application/pdf",
+	"application/zip":           "application/zip",
+	"text/plain; charset=utf-8": "text/plain; charset=utf-8",
+}
+
+// Sniff returns the content type of an accepted file from its content, or
+// false when the file is not accepted
+func Sniff(data []byte) (string, bool) {
+	contentType, ok := contentTypes[http.DetectContentType(data)]
+	return contentType, ok
+}
+
+// IsImage tells whether files of a content type are shown inline
+func IsImage(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/")
+}
+
+// ThumbnailSize is the longest side of attachment thumbnails, in pixels
+const ThumbnailSize = 320
+
+// ThumbnailType is the content type of the thumbnails of an image type.
+// Photos stay small as JPEG; drawings and transparency need PNG.
+func ThumbnailType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// Thumbnail renders a thumbnail of an image attachment, of ThumbnailType,
+// and returns it with the size of the original. ok is false for files that
+// get no thumbnail, like WebP images which the standard library cannot
+// decode.
+func Thumbnail(data []byte, maxDimension int) (thumb []byte, width, height int, ok bool) {
+	img, format, err := imaging.Decode(data, maxDimension)
+	if err != nil {
+		return nil, 0, 0, false
+	}
+	var buf bytes.Buffer
+	scaled := imaging.Fit(img, ThumbnailSize)
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, scaled)
+	}
+	if err != nil {
+		return nil, 0, 0, false
+	}
+	b := img.Bounds()
+	return buf.Bytes(), b.Dx(), b.Dy(), true
+}
@@ -0,0 +1,74 @@
+package attachments
+
+import (
+	"log"
+	"time"
+
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/storage"
+)
+
+// collectBatch is how many expired attachments are removed per query
+const collectBatch = 100
+
+// Collector deletes the files of uploads never attached to anything and of
+// attachments whose post, comment or message was deleted
+type Collector struct {
+	db      *database.Database
+	backend storage.Backend
+	config  Config
+}
+
+func NewCollector(db *database.Database, backend storage.Backend, config Config) *Collector {
+	return &Collector{db: db, backend: backend, config: config}
+}
+
+// Run collects expired attachments every interval, forever
+func (c *Collector) Run() {
+	ticker := time.NewTicker(c.config.GCInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := c.Collect(); err != nil {
+			log.Printf("Error collecting attachments: %v", err)
+		} else if n > 0 {
+			log.Printf("Collected %d expired attachments", n)
+		}
+		<-ticker.C
+	}
+}
+
+// Collect removes every expired attachment and returns how many it removed.
+// Records go only once their files are gone, so failures are retried.
+func (c *Collector) Collect() (int, error) {
+	collected := 0
+	for {
+		expired, err := c.db.ExpiredAttachments(time.Now().Add(-c.config.UnlinkedTTL), collectBatch)
+		if err != nil {
+			return collected, err
+		}
+		removed := 0
+		for _, a := range expired {
+			if err := c.remove(a); err != nil {
+				log.Printf("Error removing attachment %d: %v", a.ID, err)
+				continue
+			}
+			removed++
+		}
+		collected += removed
+		if len(expired) < collectBatch || removed == 0 {
+			return collected, nil
+		}
+	}
+}
+
+func (c *Collector) remove(a database.Attachment) error {
+	if a.ThumbnailKey != "" {
+		if err := c.backend.Delete(a.ThumbnailKey); err != nil {
+			return err
+		}
+	}
+	if err := c.backend.Delete(a.StorageKey); err != nil {
+		return err
+	}
+	return c.db.DeleteAttachment(a.ID)
+}
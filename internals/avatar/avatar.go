@@ -0,0 +1,145 @@
+// Package avatar turns uploaded pictures into square avatar thumbnails,
+// stores them content-addressed and draws identicons for users without one.
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"real-time-forum/internals/imaging"
+	"real-time-forum/internals/storage"
+)
+
+// Sizes are the thumbnail widths, in pixels, kept for every avatar. The
+// largest is processed from the upload and the others from it.
+var Sizes = []int{256, 128, 64, 32}
+
+// DefaultSize is the thumbnail used when none is asked for
+const DefaultSize = 128
+
+var ErrNotFound = errors.New("avatar not found")
+
+// keyPattern matches the SHA-256 keys of stored avatars
+var keyPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Config sets what uploads are accepted
+type Config struct {
+	MaxBytes     int64 // largest accepted upload
+	MaxDimension int   // largest accepted width or height, in pixels
+}
+
+// DefaultConfig accepts pictures of up to 5 MiB and 4096×4096 pixels
+var DefaultConfig = Config{MaxBytes: 5 << 20, MaxDimension: 4096}
+
+// ConfigFromEnv reads FORUM_AVATAR_MAX_BYTES and FORUM_AVATAR_MAX_DIMENSION,
+// falling back to DefaultConfig
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig
+	if v := os.Getenv("FORUM_AVATAR_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			cfg.MaxBytes = n
+		} else {
+			log.Printf("ignoring invalid FORUM_AVATAR_MAX_BYTES=%q", v)
+		}
+	}
+	if v := os.Getenv("FORUM_AVATAR_MAX_DIMENSION"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.MaxDimension = n
+		} else {
+			log.Printf("ignoring invalid FORUM_AVATAR_MAX_DIMENSION=%q", v)
+		}
+	}
+	return cfg
+}
+
+// Store keeps avatar thumbnails as avatars/ab/abcdef…-SIZE.png, where the
+// key is the SHA-256 of the largest thumbnail. Identical pictures share
+// their files.
+type Store struct {
+	backend storage.Backend
+	config  Config
+}
+
+func New(backend storage.Backend, config Config) *Store {
+	return &Store{backend: backend, config: config}
+}
+
+// Config returns the limits uploads are checked against
+func (s *Store) Config() Config {
+	return s.config
+}
+
+// Save validates an uploaded picture, renders its thumbnails without any of
+// the original metadata and stores them, returning the avatar key
+func (s *Store) Save(r io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, s.config.MaxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read image: %w", err)
+	}
+	if int64(len(data)) > s.config.MaxBytes {
+		return "", fmt.Errorf("%w: images are limited to %d bytes", imaging.ErrTooLarge, s.config.MaxBytes)
+	}
+	img, _, err := imaging.Decode(data, s.config.MaxDimension)
+	if err != nil {
+		return "", err
+	}
+
+	files := make(map[int][]byte, len(Sizes))
+	thumb := imaging.SquareCrop(img)
+	for _, size := range Sizes {
+		thumb = imaging.Resize(thumb, size, size)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, thumb); err != nil {
+			return "", fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+		files[size] = buf.Bytes()
+	}
+	sum := sha256.Sum256(files[Sizes[0]])
+	key := hex.EncodeToString(sum[:])
+
+	for size, data := range files {
+		if err := s.backend.Put(storageKey(key, size), bytes.NewReader(data), int64(len(data)), "image/png"); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+// Open returns a stored thumbnail
+func (s *Store) Open(key string, size int) (io.ReadCloser, error) {
+	if !keyPattern.MatchString(key) || !slices.Contains(Sizes, size) {
+		return nil, ErrNotFound
+	}
+	f, err := s.backend.Open(storageKey(key, size))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Remove deletes the thumbnails of an avatar nobody uses anymore
+func (s *Store) Remove(key string) error {
+	if !keyPattern.MatchString(key) {
+		return ErrNotFound
+	}
+	for _, size := range Sizes {
+		if err := s.backend.Delete(storageKey(key, size)); err != nil {
+			return fmt.Errorf("failed to remove avatar: %w", err)
+		}
+	}
+	return nil
+}
+
+func storageKey(key string, size int) string {
+	return fmt.Sprintf("avatars/%s/%s-%d.png", key[:2], key, size)
+}
@@ -0,0 +1,76 @@
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+)
+
+// identiconGrid is the number of cells across an identicon, not counting
+// the half cell margin on each side
+const identiconGrid = 5
+
+// Identicon draws a size×size PNG from a seed: a left-right symmetric 5×5
+// pattern in a color picked from the seed's hash, on a light background.
+// The same seed always gives the same picture.
+func Identicon(seed string, size int) []byte {
+	sum := sha256.Sum256([]byte(seed))
+
+	// One bit per cell of the left half and middle column, mirrored
+	var cells [identiconGrid][identiconGrid]bool
+	bit := 0
+	for x := 0; x < (identiconGrid+1)/2; x++ {
+		for y := 0; y < identiconGrid; y++ {
+			on := sum[bit/8]>>(bit%8)&1 == 1
+			cells[y][x], cells[y][identiconGrid-1-x] = on, on
+			bit++
+		}
+	}
+
+	hue := float64(uint16(sum[28])<<8|uint16(sum[29])) / 65536 * 360
+	palette := color.Palette{
+		color.RGBA{0xf0, 0xf0, 0xf0, 0xff},
+		hslColor(hue, 0.55, 0.5),
+	}
+	img := image.NewPaletted(image.Rect(0, 0, size, size), palette)
+	scale := float64(identiconGrid+1) / float64(size)
+	for y := 0; y < size; y++ {
+		cy := int(math.Floor(float64(y)*scale - 0.5))
+		for x := 0; x < size; x++ {
+			cx := int(math.Floor(float64(x)*scale - 0.5))
+			if cx >= 0 && cx < identiconGrid && cy >= 0 && cy < identiconGrid && cells[cy][cx] {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// hslColor converts a hue in degrees, saturation and lightness to RGB
+func hslColor(h, s, l float64) color.RGBA {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 0xff}
+}
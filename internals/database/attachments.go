@@ -0,0 +1,267 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrInvalidAttachment  = errors.New("invalid attachment")
+	ErrQuotaExceeded      = errors.New("attachment quota exceeded")
+)
+
+// MaxAttachments caps how many files a single post, comment or message can carry
+const MaxAttachments = 10
+
+// Attachment is an uploaded file, linked to the post, comment or message it
+// was uploaded for once that is created
+type Attachment struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"-"`
+	Filename     string    `json:"filename"`
+	ContentType  string    `json:"contentType"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"` // images only
+	Height       int       `json:"height,omitempty"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnailUrl,omitempty"`
+	StorageKey   string    `json:"-"`
+	ThumbnailKey string    `json:"-"` // empty when there is no thumbnail
+	TargetType   string    `json:"-"` // empty until linked
+	TargetID     int       `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+const attachmentColumns = `id, user_id, filename, content_type, size, width, height,
+	storage_key, thumbnail_key, target_type, target_id, created_at`
+
+func scanAttachment(row interface{ Scan(...interface{}) error }) (Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.UserID, &a.Filename, &a.ContentType, &a.Size, &a.Width, &a.Height,
+		&a.StorageKey, &a.ThumbnailKey, &a.TargetType, &a.TargetID, &a.CreatedAt)
+	if err != nil {
+		return a, err
+	}
+	a.setURLs()
+	return a, nil
+}
+
+// setURLs fills in where the API serves the attachment
+func (a *Attachment) setURLs() {
+	a.URL = fmt.Sprintf("/api/attachments/%d", a.ID)
+	if a.ThumbnailKey != "" {
+		a.ThumbnailURL = a.URL + "/thumbnail"
+	}
+}
+
+// CreateAttachment records an uploaded file, not linked to anything yet,
+// and sets its ID and URLs. It returns ErrQuotaExceeded if the file would
+// take the user's attachments over quota bytes; the check and the insert are
+// one statement, so concurrent uploads cannot both slip under the quota.
+func (db *Database) CreateAttachment(a *Attachment, quota int64) error {
+	a.CreatedAt = time.Now()
+	result, err := db.DB.Exec(`
+		INSERT INTO attachments (user_id, filename, content_type, size, width, height, storage_key, thumbnail_key, created_at)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE (SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ?) + ? <= ?
+	`, a.UserID, a.Filename, a.ContentType, a.Size, a.Width, a.Height, a.StorageKey, a.ThumbnailKey, a.CreatedAt,
+		a.UserID, a.Size, quota)
+	if err != nil {
+		return fmt.Errorf("failed to create attachment: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrQuotaExceeded
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get attachment ID: %w", err)
+	}
+	a.ID = int(id)
+	a.setURLs()
+	return nil
+}
+
+// GetAttachment returns an attachment, linked or not
+func (db *Database) GetAttachment(id int) (*Attachment, error) {
+	a, err := scanAttachment(db.DB.QueryRow("SELECT "+attachmentColumns+" FROM attachments WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return &a, nil
+}
+
+// AttachmentUsage returns how many bytes of attachments a user has stored
+func (db *Database) AttachmentUsage(userID int) (int64, error) {
+	var total int64
+	err := db.DB.QueryRow("SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ?", userID).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum attachments: %w", err)
+	}
+	return total, nil
+}
+
+// CheckAttachments makes sure a user may attach files to new content: at
+// most MaxAttachments of their own uploads, none linked yet. Duplicate IDs
+// are dropped.
+func (db *Database) CheckAttachments(userID int, ids []int) ([]int, error) {
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if len(ids) > MaxAttachments {
+		return nil, fmt.Errorf("%w: at most %d attachments are allowed", ErrInvalidAttachment, MaxAttachments)
+	}
+
+	args := []interface{}{userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	var count int
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*) FROM attachments
+		WHERE user_id = ? AND target_type = '' AND id IN (`+placeholders(len(ids))+`)
+	`, args...).Scan(&count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check attachments: %w", err)
+	}
+	if count != len(ids) {
+		return nil, fmt.Errorf("%w: attachments must be your own uploads, not attached elsewhere", ErrInvalidAttachment)
+	}
+	return ids, nil
+}
+
+// LinkAttachments attaches uploads checked with CheckAttachments to the post,
+// comment or message they were uploaded for
+func (db *Database) LinkAttachments(userID int, ids []int, targetType string, targetID int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []interface{}{targetType, targetID, userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := db.DB.Exec(`
+		UPDATE attachments SET target_type = ?, target_id = ?
+		WHERE user_id = ? AND target_type = '' AND id IN (`+placeholders(len(ids))+`)
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to link attachments: %w", err)
+	}
+	return nil
+}
+
+// GetAttachments returns the attachments of a single post, comment or message
+func (db *Database) GetAttachments(targetType string, targetID int) ([]Attachment, error) {
+	attachments, err := db.attachmentsOf(targetType, []int{targetID})
+	if err != nil {
+		return nil, err
+	}
+	return attachments[targetID], nil
+}
+
+// attachmentsOf loads the attachments of several posts, comments or messages
+// by ID, in upload order
+func (db *Database) attachmentsOf(targetType string, ids []int) (map[int][]Attachment, error) {
+	attachments := make(map[int][]Attachment)
+	err := inChunks(ids, func(ids []int) error {
+		args := []interface{}{targetType}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+
+		rows, err := db.DB.Query(`
+			SELECT `+attachmentColumns+` FROM attachments
+			WHERE target_type = ? AND target_id IN (`+placeholders(len(ids))+`)
+			ORDER BY id
+		`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query attachments: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			a, err := scanAttachment(rows)
+			if err != nil {
+				return fmt.Errorf("failed to scan attachment: %w", err)
+			}
+			attachments[a.TargetID] = append(attachments[a.TargetID], a)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// attachPostAttachments fills in the Attachments of each post
+func (db *Database) attachPostAttachments(posts []Post) error {
+	attachments, err := db.attachmentsOf(TargetPost, postIDs(posts))
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Attachments = attachments[posts[i].ID]
+	}
+	return nil
+}
+
+// attachCommentAttachments fills in the Attachments of each comment
+func (db *Database) attachCommentAttachments(comments []Comment) error {
+	ids := make([]int, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.ID
+	}
+	attachments, err := db.attachmentsOf(TargetComment, ids)
+	if err != nil {
+		return err
+	}
+	for i := range comments {
+		comments[i].Attachments = attachments[comments[i].ID]
+	}
+	return nil
+}
+
+// ExpiredAttachments returns attachments whose files can go: uploads never
+// linked to anything since before a time, and attachments of posts,
+// comments or messages that were deleted
+func (db *Database) ExpiredAttachments(unlinkedBefore time.Time, limit int) ([]Attachment, error) {
+	rows, err := db.DB.Query(`
+		SELECT `+attachmentColumns+` FROM attachments a
+		WHERE (a.target_type = '' AND a.created_at < ?)
+		   OR (a.target_type = 'post' AND NOT EXISTS (SELECT 1 FROM posts WHERE id = a.target_id))
+		   OR (a.target_type = 'comment' AND NOT EXISTS (SELECT 1 FROM comments WHERE id = a.target_id))
+		   OR (a.target_type = 'message' AND NOT EXISTS (SELECT 1 FROM messages WHERE id = a.target_id))
+		LIMIT ?
+	`, unlinkedBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// DeleteAttachment removes the record of an attachment whose files are gone
+func (db *Database) DeleteAttachment(id int) error {
+	if _, err := db.DB.Exec("DELETE FROM attachments WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	return nil
+}
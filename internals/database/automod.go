@@ -0,0 +1,512 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kinds of auto-moderation rule
+const (
+	AutomodWord      = "word"      // Pattern is a comma separated list of blocked words
+	AutomodRegex     = "regex"     // Pattern is a regular expression
+	AutomodLinks     = "links"     // matches more than Threshold links
+	AutomodCaps      = "caps"      // matches when at least Threshold percent of letters are capitals
+	AutomodDuplicate = "duplicate" // matches content the author already sent within Threshold minutes
+)
+
+// What happens to content a rule matches
+const (
+	AutomodReject = "reject"
+	AutomodHold   = "hold" // saved hidden and queued for moderator review
+	AutomodMask   = "mask" // matched text is replaced with asterisks; word and regex rules only
+)
+
+// ModAutoHold is logged when a rule holds content for review
+const ModAutoHold = "auto_hold"
+
+const (
+	// automodReloadInterval bounds how long rule changes made outside the
+	// API, for example directly in the database, take to apply
+	automodReloadInterval = 30 * time.Second
+
+	// minCapsLetters keeps caps rules from firing on short shouts like "OK"
+	minCapsLetters = 10
+
+	automodExcerptLength = 200
+)
+
+var (
+	ErrInvalidAutomodRule  = errors.New("invalid auto-moderation rule")
+	ErrAutomodRuleNotFound = errors.New("auto-moderation rule not found")
+)
+
+var (
+	linkPattern    = regexp.MustCompile(`(?i)\bhttps?://|\bwww\.`)
+	automodKinds   = []string{AutomodWord, AutomodRegex, AutomodLinks, AutomodCaps, AutomodDuplicate}
+	automodTargets = []string{TargetPost, TargetComment, TargetMessage}
+)
+
+// AutomodRule is an admin managed content rule
+type AutomodRule struct {
+	ID             int       `json:"id"`
+	Name           string    `json:"name"`
+	Kind           string    `json:"kind"`
+	Pattern        string    `json:"pattern"`
+	Threshold      int       `json:"threshold"`
+	Action         string    `json:"action"`
+	Targets        []string  `json:"targets"`        // empty applies to posts, comments and messages
+	NewAccountDays int       `json:"newAccountDays"` // only accounts younger than this many days; 0 for all
+	DryRun         bool      `json:"dryRun"`         // log matches without acting on them
+	Enabled        bool      `json:"enabled"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+
+	matcher *regexp.Regexp // word and regex rules
+}
+
+// AutomodInput is new content to check before it is stored
+type AutomodInput struct {
+	TargetType string // TargetPost, TargetComment or TargetMessage
+	AuthorID   int
+	Title      string // posts only
+	Content    string
+}
+
+// AutomodVerdict is the outcome of checking content. Title and Content carry
+// the text with any masking applied and should be stored instead of the input.
+type AutomodVerdict struct {
+	Action  string // "" to accept, AutomodReject or AutomodHold
+	Rule    *AutomodRule
+	Title   string
+	Content string
+}
+
+// AutomodLogEntry records a rule match
+type AutomodLogEntry struct {
+	ID         int       `json:"id"`
+	RuleID     int       `json:"ruleId,omitempty"` // 0 once the rule is deleted
+	Rule       string    `json:"rule,omitempty"`
+	UserID     int       `json:"userId"`
+	User       string    `json:"user"`
+	TargetType string    `json:"targetType"`
+	Action     string    `json:"action"`
+	DryRun     bool      `json:"dryRun"`
+	Excerpt    string    `json:"excerpt"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// automodCache holds the compiled enabled rules
+type automodCache struct {
+	mu       sync.Mutex
+	rules    []*AutomodRule
+	loadedAt time.Time
+}
+
+// compile validates a rule and prepares its matcher
+func (r *AutomodRule) compile() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidAutomodRule)
+	}
+	if !slices.Contains(automodKinds, r.Kind) {
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidAutomodRule, r.Kind)
+	}
+	switch r.Action {
+	case AutomodReject, AutomodHold:
+	case AutomodMask:
+		if r.Kind != AutomodWord && r.Kind != AutomodRegex {
+			return fmt.Errorf("%w: only word and regex rules can mask", ErrInvalidAutomodRule)
+		}
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidAutomodRule, r.Action)
+	}
+	for _, t := range r.Targets {
+		if !slices.Contains(automodTargets, t) {
+			return fmt.Errorf("%w: unknown target %q", ErrInvalidAutomodRule, t)
+		}
+	}
+	if r.Threshold < 0 || r.NewAccountDays < 0 {
+		return fmt.Errorf("%w: threshold and newAccountDays must not be negative", ErrInvalidAutomodRule)
+	}
+
+	switch r.Kind {
+	case AutomodWord:
+		var words []string
+		for _, w := range strings.Split(r.Pattern, ",") {
+			if w = strings.TrimSpace(w); w != "" {
+				words = append(words, regexp.QuoteMeta(w))
+			}
+		}
+		if len(words) == 0 {
+			return fmt.Errorf("%w: at least one word is required", ErrInvalidAutomodRule)
+		}
+		r.matcher = regexp.MustCompile(`(?i)\b(?:` + strings.Join(words, "|") + `)\b`)
+	case AutomodRegex:
+		matcher, err := regexp.Compile(r.Pattern)
+		if err != nil || r.Pattern == "" {
+			return fmt.Errorf("%w: invalid regular expression", ErrInvalidAutomodRule)
+		}
+		r.matcher = matcher
+	case AutomodCaps:
+		if r.Threshold == 0 || r.Threshold > 100 {
+			return fmt.Errorf("%w: caps threshold must be a percentage between 1 and 100", ErrInvalidAutomodRule)
+		}
+	case AutomodDuplicate:
+		if r.Threshold == 0 {
+			return fmt.Errorf("%w: duplicate threshold must be a window in minutes", ErrInvalidAutomodRule)
+		}
+	}
+	return nil
+}
+
+func (r *AutomodRule) appliesTo(targetType string) bool {
+	return len(r.Targets) == 0 || slices.Contains(r.Targets, targetType)
+}
+
+const automodRuleColumns = `id, name, kind, pattern, threshold, action, targets, new_account_days, dry_run, enabled, created_at, updated_at`
+
+func scanAutomodRule(scan func(...interface{}) error) (*AutomodRule, error) {
+	var r AutomodRule
+	var targets string
+	if err := scan(&r.ID, &r.Name, &r.Kind, &r.Pattern, &r.Threshold, &r.Action, &targets,
+		&r.NewAccountDays, &r.DryRun, &r.Enabled, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	r.Targets = []string{}
+	if targets != "" {
+		r.Targets = strings.Split(targets, ",")
+	}
+	return &r, nil
+}
+
+func (db *Database) queryAutomodRules(where string) ([]*AutomodRule, error) {
+	rows, err := db.DB.Query("SELECT " + automodRuleColumns + " FROM automod_rules " + where + " ORDER BY id ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query automod rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []*AutomodRule
+	for rows.Next() {
+		r, err := scanAutomodRule(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan automod rule: %w", err)
+		}
+		rules = append(rules, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during automod rule iteration: %w", err)
+	}
+	return rules, nil
+}
+
+// automodRules returns the enabled rules, reloading them when stale
+func (db *Database) automodRules() ([]*AutomodRule, error) {
+	db.automod.mu.Lock()
+	defer db.automod.mu.Unlock()
+	if db.automod.rules != nil && time.Since(db.automod.loadedAt) < automodReloadInterval {
+		return db.automod.rules, nil
+	}
+
+	loaded, err := db.queryAutomodRules("WHERE enabled = 1")
+	if err != nil {
+		return nil, err
+	}
+	rules := []*AutomodRule{}
+	for _, r := range loaded {
+		if err := r.compile(); err != nil {
+			log.Printf("skipping automod rule %d: %v", r.ID, err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	db.automod.rules = rules
+	db.automod.loadedAt = time.Now()
+	return rules, nil
+}
+
+// invalidateAutomodRules makes the next check reload the rules
+func (db *Database) invalidateAutomodRules() {
+	db.automod.mu.Lock()
+	defer db.automod.mu.Unlock()
+	db.automod.rules = nil
+}
+
+// CheckContent runs the enabled rules against new content. Every match is
+// logged; dry-run rules stop there. Reject wins over hold, and masking
+// applies whatever the final action.
+func (db *Database) CheckContent(in AutomodInput) (*AutomodVerdict, error) {
+	verdict := &AutomodVerdict{Title: in.Title, Content: in.Content}
+	rules, err := db.automodRules()
+	if err != nil || len(rules) == 0 {
+		return verdict, err
+	}
+
+	var accountAge time.Duration = -1
+	for _, rule := range rules {
+		if !rule.appliesTo(in.TargetType) {
+			continue
+		}
+		if rule.NewAccountDays > 0 {
+			if accountAge < 0 {
+				var createdAt time.Time
+				if err := db.DB.QueryRow("SELECT created_at FROM users WHERE id = ?", in.AuthorID).Scan(&createdAt); err != nil {
+					return nil, fmt.Errorf("failed to get account age: %w", err)
+				}
+				accountAge = time.Since(createdAt)
+			}
+			if accountAge >= time.Duration(rule.NewAccountDays)*24*time.Hour {
+				continue
+			}
+		}
+
+		matched, err := db.automodMatches(rule, in.AuthorID, verdict.Title, verdict.Content)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		if err := db.logAutomodMatch(rule, in); err != nil {
+			return nil, err
+		}
+		if rule.DryRun {
+			continue
+		}
+
+		switch rule.Action {
+		case AutomodMask:
+			verdict.Title = maskMatches(rule.matcher, verdict.Title)
+			verdict.Content = maskMatches(rule.matcher, verdict.Content)
+		case AutomodHold:
+			if verdict.Action == "" {
+				verdict.Action, verdict.Rule = AutomodHold, rule
+			}
+		case AutomodReject:
+			if verdict.Action != AutomodReject {
+				verdict.Action, verdict.Rule = AutomodReject, rule
+			}
+		}
+	}
+	return verdict, nil
+}
+
+func (db *Database) automodMatches(rule *AutomodRule, authorID int, title, content string) (bool, error) {
+	text := strings.TrimSpace(title + "\n" + content)
+	switch rule.Kind {
+	case AutomodWord, AutomodRegex:
+		return rule.matcher.MatchString(text), nil
+	case AutomodLinks:
+		return len(linkPattern.FindAllStringIndex(text, -1)) > rule.Threshold, nil
+	case AutomodCaps:
+		return capsPercent(text) >= rule.Threshold, nil
+	case AutomodDuplicate:
+		return db.isDuplicate(authorID, content, time.Duration(rule.Threshold)*time.Minute)
+	}
+	return false, nil
+}
+
+// capsPercent returns the share of capital letters, or 0 for short texts
+func capsPercent(text string) int {
+	var letters, upper int
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters < minCapsLetters {
+		return 0
+	}
+	return upper * 100 / letters
+}
+
+func normalizeForDuplicate(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// isDuplicate reports whether the author sent the same content anywhere within window
+func (db *Database) isDuplicate(authorID int, content string, window time.Duration) (bool, error) {
+	rows, err := db.DB.Query(`
+		SELECT content FROM posts WHERE user_id = ? AND created_at > ?
+		UNION ALL
+		SELECT content FROM comments WHERE user_id = ? AND created_at > ?
+		UNION ALL
+		SELECT content FROM messages WHERE sender_id = ? AND created_at > ?
+	`, authorID, time.Now().Add(-window), authorID, time.Now().Add(-window), authorID, time.Now().Add(-window))
+	if err != nil {
+		return false, fmt.Errorf("failed to query recent content: %w", err)
+	}
+	defer rows.Close()
+
+	normalized := normalizeForDuplicate(content)
+	for rows.Next() {
+		var previous string
+		if err := rows.Scan(&previous); err != nil {
+			return false, fmt.Errorf("failed to scan recent content: %w", err)
+		}
+		if normalizeForDuplicate(previous) == normalized {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func maskMatches(matcher *regexp.Regexp, s string) string {
+	return matcher.ReplaceAllStringFunc(s, func(m string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(m))
+	})
+}
+
+func (db *Database) logAutomodMatch(rule *AutomodRule, in AutomodInput) error {
+	excerpt := strings.TrimSpace(in.Title + " " + in.Content)
+	if utf8.RuneCountInString(excerpt) > automodExcerptLength {
+		excerpt = string([]rune(excerpt)[:automodExcerptLength])
+	}
+	if rule.DryRun {
+		log.Printf("automod dry run: rule %d (%s) would %s %s by user %d", rule.ID, rule.Name, rule.Action, in.TargetType, in.AuthorID)
+	}
+	_, err := db.DB.Exec(`
+		INSERT INTO automod_log (rule_id, user_id, target_type, action, dry_run, excerpt, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, rule.ID, in.AuthorID, in.TargetType, rule.Action, rule.DryRun, excerpt, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to log automod match: %w", err)
+	}
+	return nil
+}
+
+// HoldRule returns the rule holding content for review, or nil when the
+// content can be published
+func (v *AutomodVerdict) HoldRule() *AutomodRule {
+	if v.Action != AutomodHold {
+		return nil
+	}
+	return v.Rule
+}
+
+// holdContent queues content already stored hidden for moderator review. It
+// runs in the transaction storing the content, so held content is never
+// visible, not even briefly or when queueing it fails.
+func holdContent(tx *sql.Tx, targetType string, targetID int, rule *AutomodRule) error {
+	target, err := reportTarget(tx, targetType, targetID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO automod_holds (target_type, target_id, rule_id, created_at) VALUES (?, ?, ?, ?)
+	`, targetType, targetID, rule.ID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to hold content: %w", err)
+	}
+	return recordModeration(tx, 0, ModAutoHold, target, "rule: "+rule.Name)
+}
+
+// ListAutomodRules returns every rule, enabled or not
+func (db *Database) ListAutomodRules() ([]*AutomodRule, error) {
+	rules, err := db.queryAutomodRules("")
+	if rules == nil && err == nil {
+		rules = []*AutomodRule{}
+	}
+	return rules, err
+}
+
+// CreateAutomodRule validates and stores a rule; it applies immediately
+func (db *Database) CreateAutomodRule(rule AutomodRule) (int, error) {
+	if err := rule.compile(); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	result, err := db.DB.Exec(`
+		INSERT INTO automod_rules (name, kind, pattern, threshold, action, targets, new_account_days, dry_run, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.Name, rule.Kind, rule.Pattern, rule.Threshold, rule.Action, strings.Join(rule.Targets, ","),
+		rule.NewAccountDays, rule.DryRun, rule.Enabled, now, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create automod rule: %w", err)
+	}
+	db.invalidateAutomodRules()
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get automod rule ID: %w", err)
+	}
+	return int(id), nil
+}
+
+// UpdateAutomodRule replaces a rule; it applies immediately
+func (db *Database) UpdateAutomodRule(ruleID int, rule AutomodRule) error {
+	if err := rule.compile(); err != nil {
+		return err
+	}
+	result, err := db.DB.Exec(`
+		UPDATE automod_rules
+		SET name = ?, kind = ?, pattern = ?, threshold = ?, action = ?, targets = ?,
+		    new_account_days = ?, dry_run = ?, enabled = ?, updated_at = ?
+		WHERE id = ?
+	`, rule.Name, rule.Kind, rule.Pattern, rule.Threshold, rule.Action, strings.Join(rule.Targets, ","),
+		rule.NewAccountDays, rule.DryRun, rule.Enabled, time.Now(), ruleID)
+	if err != nil {
+		return fmt.Errorf("failed to update automod rule: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrAutomodRuleNotFound
+	}
+	db.invalidateAutomodRules()
+	return nil
+}
+
+// DeleteAutomodRule removes a rule; past log entries and holds are kept
+func (db *Database) DeleteAutomodRule(ruleID int) error {
+	result, err := db.DB.Exec("DELETE FROM automod_rules WHERE id = ?", ruleID)
+	if err != nil {
+		return fmt.Errorf("failed to delete automod rule: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrAutomodRuleNotFound
+	}
+	db.invalidateAutomodRules()
+	return nil
+}
+
+// GetAutomodLog returns the most recent rule matches, newest first
+func (db *Database) GetAutomodLog(limit int) ([]AutomodLogEntry, error) {
+	rows, err := db.DB.Query(`
+		SELECT l.id, COALESCE(l.rule_id, 0), COALESCE(r.name, ''), l.user_id, COALESCE(u.nickname, ''),
+		       l.target_type, l.action, l.dry_run, l.excerpt, l.created_at
+		FROM automod_log l
+		LEFT JOIN automod_rules r ON r.id = l.rule_id
+		LEFT JOIN users u ON u.id = l.user_id
+		ORDER BY l.id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query automod log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AutomodLogEntry{}
+	for rows.Next() {
+		var e AutomodLogEntry
+		if err := rows.Scan(&e.ID, &e.RuleID, &e.Rule, &e.UserID, &e.User,
+			&e.TargetType, &e.Action, &e.DryRun, &e.Excerpt, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan automod log row: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during automod log iteration: %w", err)
+	}
+	return entries, nil
+}
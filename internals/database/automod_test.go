@@ -0,0 +1,248 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCompileAutomodRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  AutomodRule
+		valid bool
+	}{
+		{"word", AutomodRule{Name: "w", Kind: AutomodWord, Pattern: "spam, scam", Action: AutomodReject}, true},
+		{"mask words", AutomodRule{Name: "w", Kind: AutomodWord, Pattern: "darn", Action: AutomodMask}, true},
+		{"regex", AutomodRule{Name: "r", Kind: AutomodRegex, Pattern: `\d{4}`, Action: AutomodHold}, true},
+		{"links", AutomodRule{Name: "l", Kind: AutomodLinks, Threshold: 0, Action: AutomodHold}, true},
+		{"caps", AutomodRule{Name: "c", Kind: AutomodCaps, Threshold: 70, Action: AutomodHold}, true},
+		{"duplicate", AutomodRule{Name: "d", Kind: AutomodDuplicate, Threshold: 5, Action: AutomodReject}, true},
+		{"targets", AutomodRule{Name: "t", Kind: AutomodLinks, Action: AutomodHold, Targets: []string{TargetComment, TargetMessage}}, true},
+
+		{"blank name", AutomodRule{Name: "  ", Kind: AutomodWord, Pattern: "spam", Action: AutomodReject}, false},
+		{"unknown kind", AutomodRule{Name: "x", Kind: "vibes", Action: AutomodReject}, false},
+		{"unknown action", AutomodRule{Name: "x", Kind: AutomodWord, Pattern: "spam", Action: "ban"}, false},
+		{"mask links", AutomodRule{Name: "x", Kind: AutomodLinks, Action: AutomodMask}, false},
+		{"unknown target", AutomodRule{Name: "x", Kind: AutomodLinks, Action: AutomodHold, Targets: []string{"profile"}}, false},
+		{"negative threshold", AutomodRule{Name: "x", Kind: AutomodLinks, Threshold: -1, Action: AutomodHold}, false},
+		{"negative account age", AutomodRule{Name: "x", Kind: AutomodLinks, NewAccountDays: -1, Action: AutomodHold}, false},
+		{"no words", AutomodRule{Name: "x", Kind: AutomodWord, Pattern: " , ,", Action: AutomodReject}, false},
+		{"empty regex", AutomodRule{Name: "x", Kind: AutomodRegex, Action: AutomodReject}, false},
+		{"bad regex", AutomodRule{Name: "x", Kind: AutomodRegex, Pattern: "(", Action: AutomodReject}, false},
+		{"caps without threshold", AutomodRule{Name: "x", Kind: AutomodCaps, Action: AutomodHold}, false},
+		{"caps over 100", AutomodRule{Name: "x", Kind: AutomodCaps, Threshold: 101, Action: AutomodHold}, false},
+		{"duplicate without window", AutomodRule{Name: "x", Kind: AutomodDuplicate, Action: AutomodReject}, false},
+	}
+	for _, tt := range tests {
+		err := tt.rule.compile()
+		if tt.valid && err != nil {
+			t.Errorf("%s: compile = %v, want nil", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidAutomodRule) {
+			t.Errorf("%s: compile = %v, want ErrInvalidAutomodRule", tt.name, err)
+		}
+	}
+}
+
+func TestCompiledWordRuleMatchesWholeWords(t *testing.T) {
+	rule := AutomodRule{Name: "w", Kind: AutomodWord, Pattern: "spam, free money", Action: AutomodReject}
+	if err := rule.compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	for text, want := range map[string]bool{
+		"buy SPAM now":  true,
+		"FREE money!":   true,
+		"freedom money": false,
+		"spammer":       false,
+		"antispam":      false,
+	} {
+		if got := rule.matcher.MatchString(text); got != want {
+			t.Errorf("matching %q = %v, want %v", text, got, want)
+		}
+	}
+}
+
+// newTestRules creates enabled rules and returns their IDs in order
+func newTestRules(t *testing.T, db *Database, rules ...AutomodRule) []int {
+	t.Helper()
+	var ids []int
+	for _, rule := range rules {
+		rule.Enabled = true
+		id, err := db.CreateAutomodRule(rule)
+		if err != nil {
+			t.Fatalf("CreateAutomodRule(%s): %v", rule.Name, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestCheckContentPrecedence(t *testing.T) {
+	db, _ := newTestDB(t)
+	userID, _ := newTestPost(t, db)
+	ids := newTestRules(t, db,
+		AutomodRule{Name: "scams", Kind: AutomodWord, Pattern: "scam", Action: AutomodReject},
+		AutomodRule{Name: "spam", Kind: AutomodWord, Pattern: "spam", Action: AutomodHold},
+		AutomodRule{Name: "swearing", Kind: AutomodWord, Pattern: "darn", Action: AutomodMask},
+		AutomodRule{Name: "trial", Kind: AutomodWord, Pattern: "bogus", Action: AutomodReject, DryRun: true},
+	)
+	rejectID, holdID := ids[0], ids[1]
+
+	tests := []struct {
+		title, content string
+		action         string
+		ruleID         int
+		wantTitle      string
+		wantContent    string
+	}{
+		{"", "nothing to see", "", 0, "", "nothing to see"},
+		{"", "spam here", AutomodHold, holdID, "", "spam here"},
+		{"", "spam then scam", AutomodReject, rejectID, "", "spam then scam"},
+		{"", "scam then spam", AutomodReject, rejectID, "", "scam then spam"},
+		{"Darn it", "darn spam", AutomodHold, holdID, "**** it", "**** spam"},
+		{"", "darn scam", AutomodReject, rejectID, "", "**** scam"},
+		{"", "a bogus offer", "", 0, "", "a bogus offer"},
+	}
+	for _, tt := range tests {
+		verdict, err := db.CheckContent(AutomodInput{TargetType: TargetPost, AuthorID: userID, Title: tt.title, Content: tt.content})
+		if err != nil {
+			t.Fatalf("CheckContent(%q): %v", tt.content, err)
+		}
+		ruleID := 0
+		if verdict.Rule != nil {
+			ruleID = verdict.Rule.ID
+		}
+		if verdict.Action != tt.action || ruleID != tt.ruleID {
+			t.Errorf("CheckContent(%q) = %q by rule %d, want %q by rule %d", tt.content, verdict.Action, ruleID, tt.action, tt.ruleID)
+		}
+		if verdict.Title != tt.wantTitle || verdict.Content != tt.wantContent {
+			t.Errorf("CheckContent(%q) stored %q, %q; want %q, %q", tt.content, verdict.Title, verdict.Content, tt.wantTitle, tt.wantContent)
+		}
+	}
+
+	var matches, dryRuns int
+	if err := db.DB.QueryRow("SELECT COUNT(*), COALESCE(SUM(dry_run), 0) FROM automod_log WHERE rule_id = ?", ids[3]).Scan(&matches, &dryRuns); err != nil {
+		t.Fatalf("counting log entries: %v", err)
+	}
+	if matches != 1 || dryRuns != 1 {
+		t.Errorf("dry run rule logged %d matches, %d as dry runs; want 1 of each", matches, dryRuns)
+	}
+}
+
+func TestCheckContentTargetsAndAccountAge(t *testing.T) {
+	db, _ := newTestDB(t)
+	userID, _ := newTestPost(t, db)
+	newTestRules(t, db,
+		AutomodRule{Name: "comments", Kind: AutomodWord, Pattern: "spam", Action: AutomodReject, Targets: []string{TargetComment}},
+		AutomodRule{Name: "newcomers", Kind: AutomodWord, Pattern: "scam", Action: AutomodHold, NewAccountDays: 3},
+	)
+
+	check := func(targetType, content string) string {
+		t.Helper()
+		verdict, err := db.CheckContent(AutomodInput{TargetType: targetType, AuthorID: userID, Content: content})
+		if err != nil {
+			t.Fatalf("CheckContent: %v", err)
+		}
+		return verdict.Action
+	}
+	if got := check(TargetPost, "spam"); got != "" {
+		t.Errorf("comment-only rule applied to a post: %q", got)
+	}
+	if got := check(TargetComment, "spam"); got != AutomodReject {
+		t.Errorf("comment-only rule on a comment = %q, want reject", got)
+	}
+	if got := check(TargetMessage, "scam"); got != AutomodHold {
+		t.Errorf("new account rule on a new account = %q, want hold", got)
+	}
+
+	if _, err := db.DB.Exec("UPDATE users SET created_at = ? WHERE id = ?", time.Now().Add(-4*24*time.Hour), userID); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(TargetMessage, "scam"); got != "" {
+		t.Errorf("new account rule applied to an older account: %q", got)
+	}
+}
+
+func TestCheckContentThresholds(t *testing.T) {
+	db, _ := newTestDB(t)
+	userID, _ := newTestPost(t, db)
+	newTestRules(t, db,
+		AutomodRule{Name: "links", Kind: AutomodLinks, Threshold: 2, Action: AutomodReject},
+		AutomodRule{Name: "shouting", Kind: AutomodCaps, Threshold: 70, Action: AutomodHold},
+	)
+
+	tests := []struct {
+		title, content string
+		want           string
+	}{
+		{"", "see https://a.example and http://b.example", ""},
+		{"", "see https://a.example, http://b.example and www.c.example", AutomodReject},
+		{"Links: https://a.example", "http://b.example and HTTPS://c.example", AutomodReject},
+		{"", "OK STOP", ""},
+		{"", "THIS IS ALL SHOUTING", AutomodHold},
+		{"", "THIS IS mostly shouting", ""},
+		{"STOP SHOUTING", "PLEASE, all", AutomodHold},
+		{"", "ÜNÏCÖDÉ ÄÖÜ ÉÈÊ", AutomodHold},
+		{"", "ünïcödé äöü éèê", ""},
+	}
+	for _, tt := range tests {
+		verdict, err := db.CheckContent(AutomodInput{TargetType: TargetComment, AuthorID: userID, Title: tt.title, Content: tt.content})
+		if err != nil {
+			t.Fatalf("CheckContent(%q): %v", tt.content, err)
+		}
+		if verdict.Action != tt.want {
+			t.Errorf("CheckContent(%q, %q) = %q, want %q", tt.title, tt.content, verdict.Action, tt.want)
+		}
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	db, _ := newTestDB(t)
+	aliceID, _ := newTestPost(t, db)
+	if err := db.RegisterUser("bob", "bob@example.com", "secret", "B", "Bob", "other", 30); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+	var bobID int
+	if err := db.DB.QueryRow("SELECT id FROM users WHERE nickname = 'bob'").Scan(&bobID); err != nil {
+		t.Fatalf("loading user: %v", err)
+	}
+	conversationID, err := db.DirectConversation(aliceID, bobID, true)
+	if err != nil {
+		t.Fatalf("DirectConversation: %v", err)
+	}
+	if _, err := db.CreateMessage(conversationID, aliceID, "Buy my   stuff\nnow", nil); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	oldID, err := db.CreateMessage(conversationID, aliceID, "old news", nil)
+	if err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	if _, err := db.DB.Exec("UPDATE messages SET created_at = ? WHERE id = ?", time.Now().Add(-time.Hour), oldID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		authorID int
+		content  string
+		want     bool
+	}{
+		{aliceID, "buy my stuff now", true},
+		{aliceID, "  BUY MY STUFF NOW  ", true},
+		{aliceID, "buy my stuff later", false},
+		{aliceID, "old news", false},
+		{bobID, "buy my stuff now", false},
+	}
+	for _, tt := range tests {
+		got, err := db.isDuplicate(tt.authorID, tt.content, 10*time.Minute)
+		if err != nil {
+			t.Fatalf("isDuplicate: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("isDuplicate(%d, %q) = %v, want %v", tt.authorID, tt.content, got, tt.want)
+		}
+	}
+	if got, err := db.isDuplicate(aliceID, "old news", 2*time.Hour); err != nil || !got {
+		t.Errorf("isDuplicate in a wider window = %v, %v; want true", got, err)
+	}
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrBlockSelf     = errors.New("cannot block or mute yourself")
+	ErrBlockNotFound = errors.New("block not found")
+)
+
+// Levels of a user block. Both hide the other user's posts and comments.
+const (
+	BlockLevelBlock = "block" // also stops messages and notifications both ways and hides presence
+	BlockLevelMute  = "mute"  // only hides content
+)
+
+// BlockedUser is a user the current user blocked or muted
+type BlockedUser struct {
+	ID        int       `json:"id"`
+	Nickname  string    `json:"nickname"`
+	Level     string    `json:"level"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SetBlock blocks or mutes a user, replacing any previous level. Blocking
+// also ends follows between the two users.
+func (db *Database) SetBlock(userID, targetID int, level string) error {
+	if userID == targetID {
+		return ErrBlockSelf
+	}
+	if level != BlockLevelBlock && level != BlockLevelMute {
+		return fmt.Errorf("unknown block level: %s", level)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO user_blocks (user_id, target_id, level, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, target_id) DO UPDATE SET level = excluded.level
+	`, userID, targetID, level, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to store block: %w", err)
+	}
+	if level == BlockLevelBlock {
+		_, err = tx.Exec(`
+			DELETE FROM follows
+			WHERE (follower_id = ? AND followee_id = ?) OR (follower_id = ? AND followee_id = ?)
+		`, userID, targetID, targetID, userID)
+		if err != nil {
+			return fmt.Errorf("failed to remove follows: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteBlock lifts a block or mute of the given level
+func (db *Database) DeleteBlock(userID, targetID int, level string) error {
+	result, err := db.DB.Exec("DELETE FROM user_blocks WHERE user_id = ? AND target_id = ? AND level = ?",
+		userID, targetID, level)
+	if err != nil {
+		return fmt.Errorf("failed to delete block: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrBlockNotFound
+	}
+	return nil
+}
+
+// GetBlocks lists the users a user blocked or muted, newest first
+func (db *Database) GetBlocks(userID int) ([]BlockedUser, error) {
+	rows, err := db.DB.Query(`
+		SELECT u.id, u.nickname, b.level, b.created_at
+		FROM user_blocks b JOIN users u ON u.id = b.target_id
+		WHERE b.user_id = ?
+		ORDER BY b.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blocks: %w", err)
+	}
+	defer rows.Close()
+
+	blocks := []BlockedUser{}
+	for rows.Next() {
+		var b BlockedUser
+		if err := rows.Scan(&b.ID, &b.Nickname, &b.Level, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan block: %w", err)
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// Blocked tells whether either of two users blocked the other
+func (db *Database) Blocked(userID, otherID int) (bool, error) {
+	var count int
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*) FROM user_blocks
+		WHERE level = ? AND ((user_id = ? AND target_id = ?) OR (user_id = ? AND target_id = ?))
+	`, BlockLevelBlock, userID, otherID, otherID, userID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check block: %w", err)
+	}
+	return count > 0, nil
+}
+
+// BlockedWith returns the users a user blocked or was blocked by
+func (db *Database) BlockedWith(userID int) (map[int]bool, error) {
+	return db.blockSet(`
+		SELECT target_id FROM user_blocks WHERE user_id = ? AND level = ?
+		UNION
+		SELECT user_id FROM user_blocks WHERE target_id = ? AND level = ?
+	`, userID, BlockLevelBlock, userID, BlockLevelBlock)
+}
+
+// ignoredUsersQuery selects the users whose content a user hides, blocked or
+// muted. It takes the user ID.
+const ignoredUsersQuery = "SELECT target_id FROM user_blocks WHERE user_id = ?"
+
+// IgnoredUsers returns the users whose content a user hides
+func (db *Database) IgnoredUsers(userID int) (map[int]bool, error) {
+	return db.blockSet(ignoredUsersQuery, userID)
+}
+
+// IgnoringUsers returns the users who hide a user's content
+func (db *Database) IgnoringUsers(userID int) (map[int]bool, error) {
+	return db.blockSet("SELECT user_id FROM user_blocks WHERE target_id = ?", userID)
+}
+
+func (db *Database) blockSet(query string, args ...interface{}) (map[int]bool, error) {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blocks: %w", err)
+	}
+	defer rows.Close()
+
+	users := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan block: %w", err)
+		}
+		users[id] = true
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,266 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrCategoryExists   = errors.New("category name already exists")
+	ErrCategoryArchived = errors.New("category is archived")
+	ErrDuplicateOrder   = errors.New("category listed more than once")
+)
+
+// CategoryUpdate holds the fields to change on a category; nil fields are left alone
+type CategoryUpdate struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Icon        *string `json:"icon"`
+	Color       *string `json:"color"`
+	Archived    *bool   `json:"archived"`
+	ParentID    *int    `json:"parentId"` // 0 moves the category to the top level
+}
+
+// GetCategoryByID retrieves a category by its ID
+func (db *Database) GetCategoryByID(id int) (*Category, error) {
+	var category Category
+	var description sql.NullString
+
+	err := db.DB.QueryRow(`
+		SELECT id, name, description, COALESCE(parent_id, 0), position, icon, color, archived, created_at
+		FROM categories
+		WHERE id = ?
+	`, id).Scan(
+		&category.ID,
+		&category.Name,
+		&description,
+		&category.ParentID,
+		&category.Position,
+		&category.Icon,
+		&category.Color,
+		&category.Archived,
+		&category.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query category: %w", err)
+	}
+	category.Description = description.String
+	return &category, nil
+}
+
+// CreateCategory adds a category at the end of the display order, nested
+// under parentID unless it is 0
+func (db *Database) CreateCategory(name, description, icon, color string, parentID int) (int, error) {
+	var parent interface{}
+	if parentID != 0 {
+		if _, err := db.GetCategoryByID(parentID); err != nil {
+			return 0, err
+		}
+		parent = parentID
+	}
+
+	result, err := db.DB.Exec(`
+		INSERT INTO categories (name, description, icon, color, parent_id, position)
+		VALUES (?, ?, ?, ?, ?, (SELECT COALESCE(MAX(position), -1) + 1 FROM categories))
+	`, name, description, icon, color, parent)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrCategoryExists
+		}
+		return 0, fmt.Errorf("failed to create category: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get category ID: %w", err)
+	}
+	return int(id), nil
+}
+
+// UpdateCategory renames, describes, restyles or (un)archives a category
+func (db *Database) UpdateCategory(id int, update CategoryUpdate) error {
+	var sets []string
+	var args []interface{}
+	if update.Name != nil {
+		sets = append(sets, "name = ?")
+		args = append(args, *update.Name)
+	}
+	if update.Description != nil {
+		sets = append(sets, "description = ?")
+		args = append(args, *update.Description)
+	}
+	if update.Icon != nil {
+		sets = append(sets, "icon = ?")
+		args = append(args, *update.Icon)
+	}
+	if update.Color != nil {
+		sets = append(sets, "color = ?")
+		args = append(args, *update.Color)
+	}
+	if update.Archived != nil {
+		sets = append(sets, "archived = ?")
+		args = append(args, *update.Archived)
+	}
+	if len(sets) == 0 && update.ParentID == nil {
+		return nil
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if update.ParentID != nil {
+		var parent interface{}
+		if *update.ParentID != 0 {
+			if err := checkCategoryParent(tx, id, *update.ParentID); err != nil {
+				return err
+			}
+			parent = *update.ParentID
+		}
+		sets = append(sets, "parent_id = ?")
+		args = append(args, parent)
+	}
+
+	args = append(args, id)
+	result, err := tx.Exec("UPDATE categories SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrCategoryExists
+		}
+		return fmt.Errorf("failed to update category: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrCategoryNotFound
+	}
+	return tx.Commit()
+}
+
+// ReorderCategories sets the display order to the given list of IDs.
+// Categories left out of the list keep their relative order after the listed ones.
+func (db *Database) ReorderCategories(ids []int) error {
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return fmt.Errorf("%w: %d", ErrDuplicateOrder, id)
+		}
+		seen[id] = true
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE categories SET position = position + ?", len(ids)); err != nil {
+		return fmt.Errorf("failed to shift category positions: %w", err)
+	}
+	for position, id := range ids {
+		result, err := tx.Exec("UPDATE categories SET position = ? WHERE id = ?", position, id)
+		if err != nil {
+			return fmt.Errorf("failed to reorder category: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return fmt.Errorf("%w: %d", ErrCategoryNotFound, id)
+		}
+	}
+	return tx.Commit()
+}
+
+// MergeCategories moves every post, subcategory and chat room from source
+// into target, along with the subscriptions and read watermarks of source,
+// and removes source
+func (db *Database) MergeCategories(sourceID, targetID int) (int, error) {
+	if sourceID == targetID {
+		return 0, fmt.Errorf("cannot merge a category into itself")
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, id := range []int{sourceID, targetID} {
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM categories WHERE id = ?", id).Scan(&exists); err != nil {
+			return 0, fmt.Errorf("failed to check category: %w", err)
+		}
+		if exists == 0 {
+			return 0, fmt.Errorf("%w: %d", ErrCategoryNotFound, id)
+		}
+	}
+
+	result, err := tx.Exec("UPDATE posts SET category_id = ? WHERE category_id = ?", targetID, sourceID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move posts: %w", err)
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count moved posts: %w", err)
+	}
+
+	// If target sits below source it takes source's place in the tree,
+	// and the rest of source's children move under target
+	if _, err := tx.Exec(`
+		UPDATE categories SET parent_id = (SELECT parent_id FROM categories WHERE id = ?)
+		WHERE id = ? AND id IN (`+subtreeQuery+`)
+	`, sourceID, targetID, sourceID); err != nil {
+		return 0, fmt.Errorf("failed to move target category: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE categories SET parent_id = ? WHERE parent_id = ?", targetID, sourceID); err != nil {
+		return 0, fmt.Errorf("failed to move subcategories: %w", err)
+	}
+
+	// Rooms would otherwise go down with source, messages and all
+	if _, err := tx.Exec("UPDATE conversations SET category_id = ? WHERE category_id = ?", targetID, sourceID); err != nil {
+		return 0, fmt.Errorf("failed to move chat rooms: %w", err)
+	}
+
+	// A subscription on target wins over one on source
+	if _, err := tx.Exec(`
+		UPDATE OR IGNORE subscriptions SET target_id = ? WHERE target_type = ? AND target_id = ?
+	`, targetID, TargetCategory, sourceID); err != nil {
+		return 0, fmt.Errorf("failed to move subscriptions: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM subscriptions WHERE target_type = ? AND target_id = ?", TargetCategory, sourceID); err != nil {
+		return 0, fmt.Errorf("failed to delete subscriptions: %w", err)
+	}
+
+	// Where both categories have a watermark, the lower one is kept, so no
+	// comment of either is marked read by the merge
+	if _, err := tx.Exec(`
+		UPDATE read_marks SET last_comment_id = MIN(last_comment_id,
+		    (SELECT s.last_comment_id FROM read_marks s WHERE s.user_id = read_marks.user_id AND s.category_id = ?))
+		WHERE category_id = ? AND user_id IN (SELECT user_id FROM read_marks WHERE category_id = ?)
+	`, sourceID, targetID, sourceID); err != nil {
+		return 0, fmt.Errorf("failed to merge read marks: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE OR IGNORE read_marks SET category_id = ? WHERE category_id = ?", targetID, sourceID); err != nil {
+		return 0, fmt.Errorf("failed to move read marks: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM read_marks WHERE category_id = ?", sourceID); err != nil {
+		return 0, fmt.Errorf("failed to delete read marks: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = ?", sourceID); err != nil {
+		return 0, fmt.Errorf("failed to delete merged category: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit merge: %w", err)
+	}
+	return int(moved), nil
+}
+
+// isUniqueViolation reports whether err comes from a UNIQUE constraint
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -0,0 +1,62 @@
+package database
+
+import "testing"
+
+func TestMergeCategoriesMovesRoomsSubscriptionsAndReadMarks(t *testing.T) {
+	db, _ := newTestDB(t)
+	userID, _ := newTestPost(t, db)
+	sourceID, err := db.CreateCategory("Source", "", "", "", 0)
+	if err != nil {
+		t.Fatalf("CreateCategory: %v", err)
+	}
+	targetID, err := db.CreateCategory("Target", "", "", "", 0)
+	if err != nil {
+		t.Fatalf("CreateCategory: %v", err)
+	}
+
+	roomID, err := db.CreateRoom(userID, sourceID, "Lobby")
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if _, err := db.CreateMessage(roomID, userID, "hello", nil); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	if err := db.SetSubscription(userID, TargetCategory, sourceID, SubscriptionWatch); err != nil {
+		t.Fatalf("SetSubscription: %v", err)
+	}
+	for categoryID, mark := range map[int]int{sourceID: 5, targetID: 9} {
+		if _, err := db.DB.Exec("INSERT INTO read_marks (user_id, category_id, last_comment_id) VALUES (?, ?, ?)",
+			userID, categoryID, mark); err != nil {
+			t.Fatalf("inserting read mark: %v", err)
+		}
+	}
+
+	if _, err := db.MergeCategories(sourceID, targetID); err != nil {
+		t.Fatalf("MergeCategories: %v", err)
+	}
+
+	count := func(query string, args ...interface{}) int {
+		t.Helper()
+		var n int
+		if err := db.DB.QueryRow(query, args...).Scan(&n); err != nil {
+			t.Fatalf("counting: %v", err)
+		}
+		return n
+	}
+	if n := count("SELECT COUNT(*) FROM conversations WHERE id = ? AND category_id = ?", roomID, targetID); n != 1 {
+		t.Error("room was not moved to the target category")
+	}
+	if n := count("SELECT COUNT(*) FROM messages WHERE conversation_id = ?", roomID); n != 1 {
+		t.Errorf("room has %d messages after the merge, want 1", n)
+	}
+	if n := count("SELECT COUNT(*) FROM subscriptions WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, TargetCategory, targetID); n != 1 {
+		t.Error("subscription was not moved to the target category")
+	}
+	if n := count("SELECT COUNT(*) FROM read_marks WHERE category_id = ?", sourceID); n != 0 {
+		t.Errorf("%d read marks left on the merged category", n)
+	}
+	if n := count("SELECT last_comment_id FROM read_marks WHERE user_id = ? AND category_id = ?", userID, targetID); n != 5 {
+		t.Errorf("merged read mark = %d, want the lower one, 5", n)
+	}
+}
@@ -0,0 +1,350 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrCategoryCycle     = errors.New("category cannot be nested under itself or its subcategories")
+	ErrUnknownSetting    = errors.New("unknown category setting")
+	ErrInvalidSetting    = errors.New("invalid category setting value")
+	ErrPostingRestricted = errors.New("posting in this category is restricted")
+	ErrAccountTooNew     = errors.New("account is too new to post in this category")
+)
+
+// Category settings that subcategories inherit unless they override them
+const (
+	SettingPosting           = "posting"              // PostingEveryone, PostingModerators or PostingAdmins
+	SettingMinAccountAgeDays = "min_account_age_days" // whole days since registration
+	SettingSlowModeSeconds   = "slow_mode_seconds"    // minimum interval between a user's posts
+)
+
+// Values of the posting setting
+const (
+	PostingEveryone   = "everyone"
+	PostingModerators = "moderators"
+	PostingAdmins     = "admins"
+)
+
+// categorySettingValidators lists the known settings and checks their values
+var categorySettingValidators = map[string]func(string) error{
+	SettingPosting: func(v string) error {
+		if v != PostingEveryone && v != PostingModerators && v != PostingAdmins {
+			return fmt.Errorf("%w: posting must be everyone, moderators or admins", ErrInvalidSetting)
+		}
+		return nil
+	},
+	SettingMinAccountAgeDays: func(v string) error {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			return fmt.Errorf("%w: %s must be a non-negative integer", ErrInvalidSetting, SettingMinAccountAgeDays)
+		}
+		return nil
+	},
+	SettingSlowModeSeconds: func(v string) error {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			return fmt.Errorf("%w: %s must be a non-negative integer", ErrInvalidSetting, SettingSlowModeSeconds)
+		}
+		return nil
+	},
+}
+
+// CategoryCrumb is one step of the path from a root category down to a category
+type CategoryCrumb struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// CategorySetting is an effective setting value and the category it comes from
+type CategorySetting struct {
+	Value         string `json:"value"`
+	InheritedFrom int    `json:"inheritedFrom,omitempty"` // 0 when set on the category itself
+}
+
+// subtreeQuery selects the IDs of a category and all of its subcategories
+const subtreeQuery = `
+	WITH RECURSIVE subtree(id) AS (
+		SELECT ?
+		UNION
+		SELECT c.id FROM categories c JOIN subtree s ON c.parent_id = s.id
+	)
+	SELECT id FROM subtree`
+
+// categoryAncestors returns the path from the root down to the category,
+// including the category itself as the last element
+func categoryAncestors(q interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}, categoryID int) ([]Category, error) {
+	rows, err := q.Query(`
+		WITH RECURSIVE ancestors(id, depth) AS (
+			SELECT ?, 0
+			UNION
+			SELECT c.parent_id, a.depth + 1
+			FROM categories c JOIN ancestors a ON c.id = a.id
+			WHERE c.parent_id IS NOT NULL
+		)
+		SELECT c.id, c.name, c.archived
+		FROM ancestors a JOIN categories c ON c.id = a.id
+		ORDER BY a.depth DESC
+	`, categoryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query category ancestors: %w", err)
+	}
+	defer rows.Close()
+
+	var path []Category
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Archived); err != nil {
+			return nil, fmt.Errorf("failed to scan category ancestor: %w", err)
+		}
+		path = append(path, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during ancestor iteration: %w", err)
+	}
+	if len(path) == 0 {
+		return nil, ErrCategoryNotFound
+	}
+	return path, nil
+}
+
+// GetCategoryBreadcrumbs returns the names and IDs from the root category down to categoryID
+func (db *Database) GetCategoryBreadcrumbs(categoryID int) ([]CategoryCrumb, error) {
+	path, err := categoryAncestors(db.DB, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	crumbs := make([]CategoryCrumb, len(path))
+	for i, c := range path {
+		crumbs[i] = CategoryCrumb{ID: c.ID, Name: c.Name}
+	}
+	return crumbs, nil
+}
+
+// checkCategoryParent makes sure parentID exists and is not categoryID or one of its descendants
+func checkCategoryParent(tx *sql.Tx, categoryID, parentID int) error {
+	path, err := categoryAncestors(tx, parentID)
+	if err != nil {
+		return err
+	}
+	for _, c := range path {
+		if c.ID == categoryID {
+			return ErrCategoryCycle
+		}
+	}
+	return nil
+}
+
+// SetCategorySettings overrides settings on a category; an empty value removes
+// the override so the category inherits from its parent again. Every setting
+// is validated first, and either all of them are stored or none.
+func (db *Database) SetCategorySettings(categoryID int, settings map[string]string) error {
+	for key, value := range settings {
+		validate, ok := categorySettingValidators[key]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+		}
+		if value == "" {
+			continue
+		}
+		if err := validate(value); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for key, value := range settings {
+		if value == "" {
+			_, err := tx.Exec("DELETE FROM category_settings WHERE category_id = ? AND key = ?", categoryID, key)
+			if err != nil {
+				return fmt.Errorf("failed to clear category setting: %w", err)
+			}
+			continue
+		}
+		_, err := tx.Exec(`
+			INSERT INTO category_settings (category_id, key, value) VALUES (?, ?, ?)
+			ON CONFLICT(category_id, key) DO UPDATE SET value = excluded.value
+		`, categoryID, key, value)
+		if err != nil {
+			return fmt.Errorf("failed to store category setting: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetEffectiveCategorySettings resolves every setting of a category, taking
+// the closest value walking up from the category to the root
+func (db *Database) GetEffectiveCategorySettings(categoryID int) (map[string]CategorySetting, error) {
+	path, err := categoryAncestors(db.DB, categoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	depth := make(map[int]int, len(path))
+	for i, c := range path {
+		depth[c.ID] = i
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT cs.category_id, cs.key, cs.value
+		FROM category_settings cs
+		WHERE cs.category_id IN (`+placeholders(len(path))+`)
+	`, categoryIDs(path)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query category settings: %w", err)
+	}
+	defer rows.Close()
+
+	settings := make(map[string]CategorySetting)
+	owner := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var key, value string
+		if err := rows.Scan(&id, &key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan category setting: %w", err)
+		}
+		// The deepest category on the path wins
+		if prev, ok := owner[key]; ok && depth[prev] > depth[id] {
+			continue
+		}
+		owner[key] = id
+		inherited := id
+		if id == categoryID {
+			inherited = 0
+		}
+		settings[key] = CategorySetting{Value: value, InheritedFrom: inherited}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during category setting iteration: %w", err)
+	}
+	return settings, nil
+}
+
+// CanPostInCategory checks the effective archive state and posting rules of a
+// category for the given user
+func (db *Database) CanPostInCategory(userID, categoryID int) error {
+	path, err := categoryAncestors(db.DB, categoryID)
+	if err != nil {
+		return err
+	}
+	for _, c := range path {
+		if c.Archived {
+			return ErrCategoryArchived
+		}
+	}
+
+	settings, err := db.GetEffectiveCategorySettings(categoryID)
+	if err != nil {
+		return err
+	}
+
+	if s, ok := settings[SettingPosting]; ok && s.Value != PostingEveryone {
+		permission, scope := PermCategoryManage, 0
+		if s.Value == PostingModerators {
+			permission, scope = PermCategoryModerate, categoryID
+		}
+		allowed, err := db.HasPermission(userID, permission, scope)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return ErrPostingRestricted
+		}
+	}
+
+	if s, ok := settings[SettingMinAccountAgeDays]; ok {
+		days, _ := strconv.Atoi(s.Value)
+		age, err := db.AccountAge(userID)
+		if err != nil {
+			return err
+		}
+		if age < time.Duration(days)*24*time.Hour {
+			return ErrAccountTooNew
+		}
+	}
+	return nil
+}
+
+// AccountAge returns how long ago the user registered
+func (db *Database) AccountAge(userID int) (time.Duration, error) {
+	var createdAt time.Time
+	if err := db.DB.QueryRow("SELECT created_at FROM users WHERE id = ?", userID).Scan(&createdAt); err != nil {
+		return 0, fmt.Errorf("failed to get account age: %w", err)
+	}
+	return time.Since(createdAt), nil
+}
+
+// SlowModeWait returns how long the user must wait before posting again in a
+// category with slow mode on. Moderators of the category are exempt.
+func (db *Database) SlowModeWait(userID, categoryID int) (time.Duration, error) {
+	settings, err := db.GetEffectiveCategorySettings(categoryID)
+	if err != nil {
+		return 0, err
+	}
+	s, ok := settings[SettingSlowModeSeconds]
+	if !ok {
+		return 0, nil
+	}
+	seconds, _ := strconv.Atoi(s.Value)
+	if seconds == 0 {
+		return 0, nil
+	}
+
+	exempt, err := db.HasPermission(userID, PermCategoryModerate, categoryID)
+	if err != nil || exempt {
+		return 0, err
+	}
+
+	var last time.Time
+	err = db.DB.QueryRow(
+		"SELECT created_at FROM posts WHERE user_id = ? AND category_id = ? ORDER BY created_at DESC LIMIT 1",
+		userID, categoryID,
+	).Scan(&last)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last post time: %w", err)
+	}
+	return max(0, time.Duration(seconds)*time.Second-time.Since(last)), nil
+}
+
+// withSubtreeCounts fills TotalPostCount with the posts of each category and
+// all of its subcategories
+func withSubtreeCounts(categories []Category) {
+	index := make(map[int]int, len(categories))
+	for i, c := range categories {
+		index[c.ID] = i
+		categories[i].TotalPostCount = 0
+	}
+	for _, c := range categories {
+		// Walk up from each category, crediting its own posts to every ancestor
+		seen := make(map[int]bool)
+		for id := c.ID; id != 0 && !seen[id]; {
+			seen[id] = true
+			i, ok := index[id]
+			if !ok {
+				break
+			}
+			categories[i].TotalPostCount += c.PostCount
+			id = categories[i].ParentID
+		}
+	}
+}
+
+func categoryIDs(categories []Category) []interface{} {
+	ids := make([]interface{}, len(categories))
+	for i, c := range categories {
+		ids[i] = c.ID
+	}
+	return ids
+}
@@ -0,0 +1,720 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrInvalidConversation  = errors.New("invalid conversation")
+	ErrChatForbidden        = errors.New("not allowed in this conversation")
+	ErrAlreadyMember        = errors.New("already a member of this conversation")
+	ErrNotMember            = errors.New("not a member of this conversation")
+	ErrNotBanned            = errors.New("not banned from this conversation")
+)
+
+// Kinds of conversations
+const (
+	ConversationDirect = "direct" // two users, created on their first message
+	ConversationGroup  = "group"  // named, invite only
+	ConversationRoom   = "room"   // named, attached to a category, anyone may join
+)
+
+// Roles of conversation members. Admins may rename the conversation and
+// invite or remove members; the owner also manages roles.
+const (
+	ChatRoleOwner  = "owner"
+	ChatRoleAdmin  = "admin"
+	ChatRoleMember = "member"
+)
+
+// chatRoleRank orders roles; members can only act on members of lower rank
+var chatRoleRank = map[string]int{ChatRoleMember: 1, ChatRoleAdmin: 2, ChatRoleOwner: 3}
+
+const (
+	maxConversationNameLength = 100
+	MaxGroupMembers           = 100
+)
+
+// Conversation is a direct conversation, group or room as seen by one user
+type Conversation struct {
+	ID          int                  `json:"id"`
+	Kind        string               `json:"kind"`
+	Name        string               `json:"name"` // the other user's nickname in direct conversations
+	CategoryID  int                  `json:"categoryId,omitempty"`
+	Role        string               `json:"role,omitempty"` // the viewer's role, empty when they are not a member
+	MemberCount int                  `json:"memberCount"`
+	UnreadCount int                  `json:"unreadCount"`
+	LastMessage *Message             `json:"lastMessage,omitempty"`
+	Members     []ConversationMember `json:"members,omitempty"` // only filled in for a single conversation
+	CreatedAt   time.Time            `json:"createdAt"`
+}
+
+// ConversationMember is a member of a conversation
+type ConversationMember struct {
+	UserID   int       `json:"userId"`
+	Nickname string    `json:"nickname"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+// unreadMessagesQuery counts the messages member cm of conversation c has
+// not read, leaving out their own messages, deleted ones and, outside direct
+// conversations, those of users they blocked or muted
+const unreadMessagesQuery = `
+	SELECT COUNT(*) FROM messages um
+	WHERE um.conversation_id = cm.conversation_id AND um.id > cm.last_read_id
+	  AND um.sender_id != cm.user_id AND um.hidden = FALSE AND um.deleted_at IS NULL
+	  AND um.id NOT IN (SELECT message_id FROM message_deletions WHERE user_id = cm.user_id)
+	  AND (c.kind = 'direct' OR um.sender_id NOT IN (SELECT target_id FROM user_blocks WHERE user_id = cm.user_id))`
+
+// conversationSelect lists conversations as seen by a viewer, whose ID it
+// takes twice, in scanConversations order
+const conversationSelect = `
+	SELECT c.id, c.kind,
+	       CASE c.kind WHEN 'direct' THEN COALESCE((
+	           SELECT u.nickname FROM conversation_members o JOIN users u ON u.id = o.user_id
+	           WHERE o.conversation_id = c.id AND o.user_id != ?), '') ELSE c.name END,
+	       COALESCE(c.category_id, 0), c.created_at, COALESCE(cm.role, ''),
+	       (SELECT COUNT(*) FROM conversation_members WHERE conversation_id = c.id),
+	       CASE WHEN cm.user_id IS NULL THEN 0 ELSE (` + unreadMessagesQuery + `) END,
+	       (SELECT MAX(id) FROM messages WHERE conversation_id = c.id AND hidden = FALSE) AS last_message_id
+	FROM conversations c
+	LEFT JOIN conversation_members cm ON cm.conversation_id = c.id AND cm.user_id = ?`
+
+// directKey identifies the direct conversation between two users
+func directKey(userID, otherID int) string {
+	return strconv.Itoa(min(userID, otherID)) + ":" + strconv.Itoa(max(userID, otherID))
+}
+
+// conversationName trims a group or room name and checks its length
+func conversationName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxConversationNameLength {
+		return "", fmt.Errorf("%w: names are 1 to %d characters", ErrInvalidConversation, maxConversationNameLength)
+	}
+	return name, nil
+}
+
+// DirectConversation returns the ID of the direct conversation between two
+// users. When there is none yet, it is created if create is set.
+func (db *Database) DirectConversation(userID, otherID int, create bool) (int, error) {
+	key := directKey(userID, otherID)
+	var id int
+	err := db.DB.QueryRow("SELECT id FROM conversations WHERE direct_key = ?", key).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to get direct conversation: %w", err)
+	}
+	if !create {
+		return 0, ErrConversationNotFound
+	}
+
+	var exists int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", otherID).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("failed to check recipient: %w", err)
+	}
+	if exists == 0 {
+		return 0, ErrUserNotFound
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec("INSERT INTO conversations (kind, direct_key, created_by, created_at) VALUES (?, ?, ?, ?)",
+		ConversationDirect, key, userID, now)
+	if isUniqueViolation(err) {
+		// Started by the other user in the meantime
+		tx.Rollback()
+		return db.DirectConversation(userID, otherID, false)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create direct conversation: %w", err)
+	}
+	id64, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get conversation ID: %w", err)
+	}
+	id = int(id64)
+	for _, member := range []int{userID, otherID} {
+		if err := addMember(tx, id, member, ChatRoleMember, now); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit direct conversation: %w", err)
+	}
+	return id, nil
+}
+
+// CreateGroup starts a named group owned by its creator with the given
+// members, who must all exist
+func (db *Database) CreateGroup(ownerID int, name string, memberIDs []int) (int, error) {
+	name, err := conversationName(name)
+	if err != nil {
+		return 0, err
+	}
+	var members []int
+	seen := map[int]bool{ownerID: true}
+	for _, id := range memberIDs {
+		if !seen[id] {
+			seen[id] = true
+			members = append(members, id)
+		}
+	}
+	if len(members)+1 > MaxGroupMembers {
+		return 0, fmt.Errorf("%w: groups have at most %d members", ErrInvalidConversation, MaxGroupMembers)
+	}
+	if len(members) > 0 {
+		args := make([]interface{}, len(members))
+		for i, id := range members {
+			args[i] = id
+		}
+		var found int
+		err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE id IN ("+placeholders(len(members))+")", args...).Scan(&found)
+		if err != nil {
+			return 0, fmt.Errorf("failed to check members: %w", err)
+		}
+		if found != len(members) {
+			return 0, ErrUserNotFound
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	id, err := insertConversation(tx, ConversationGroup, name, nil, ownerID, now)
+	if err != nil {
+		return 0, err
+	}
+	if err := addMember(tx, id, ownerID, ChatRoleOwner, now); err != nil {
+		return 0, err
+	}
+	for _, member := range members {
+		if err := addMember(tx, id, member, ChatRoleMember, now); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit group: %w", err)
+	}
+	return id, nil
+}
+
+// CreateRoom opens a named public room in a category, owned by its creator
+func (db *Database) CreateRoom(creatorID, categoryID int, name string) (int, error) {
+	name, err := conversationName(name)
+	if err != nil {
+		return 0, err
+	}
+	var exists int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM categories WHERE id = ?", categoryID).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("failed to check category: %w", err)
+	}
+	if exists == 0 {
+		return 0, ErrCategoryNotFound
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	id, err := insertConversation(tx, ConversationRoom, name, categoryID, creatorID, now)
+	if err != nil {
+		return 0, err
+	}
+	if err := addMember(tx, id, creatorID, ChatRoleOwner, now); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit room: %w", err)
+	}
+	return id, nil
+}
+
+func insertConversation(tx *sql.Tx, kind, name string, categoryID interface{}, creatorID int, now time.Time) (int, error) {
+	result, err := tx.Exec(
+		"INSERT INTO conversations (kind, name, category_id, created_by, created_at) VALUES (?, ?, ?, ?, ?)",
+		kind, name, categoryID, creatorID, now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", kind, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get conversation ID: %w", err)
+	}
+	return int(id), nil
+}
+
+// addMember adds a user to a conversation. Members start out having read
+// everything said before they joined.
+func addMember(tx *sql.Tx, conversationID, userID int, role string, now time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO conversation_members (conversation_id, user_id, role, joined_at, last_read_id)
+		VALUES (?, ?, ?, ?, COALESCE((SELECT MAX(id) FROM messages WHERE conversation_id = ?), 0))
+	`, conversationID, userID, role, now, conversationID)
+	if isUniqueViolation(err) {
+		return ErrAlreadyMember
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add conversation member: %w", err)
+	}
+	return nil
+}
+
+// conversationRoles returns the kind of a conversation and the roles of two
+// users in it, "" for users who are not members
+func conversationRoles(tx *sql.Tx, conversationID, actorID, userID int) (kind, actorRole, userRole string, err error) {
+	err = tx.QueryRow(`
+		SELECT c.kind,
+		       COALESCE((SELECT role FROM conversation_members WHERE conversation_id = c.id AND user_id = ?), ''),
+		       COALESCE((SELECT role FROM conversation_members WHERE conversation_id = c.id AND user_id = ?), '')
+		FROM conversations c WHERE c.id = ?
+	`, actorID, userID, conversationID).Scan(&kind, &actorRole, &userRole)
+	if err == sql.ErrNoRows {
+		return "", "", "", ErrConversationNotFound
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to get conversation roles: %w", err)
+	}
+	// Only rooms are visible to non-members
+	if actorRole == "" && kind != ConversationRoom {
+		return "", "", "", ErrConversationNotFound
+	}
+	return kind, actorRole, userRole, nil
+}
+
+// AddMember lets a group admin invite a user
+func (db *Database) AddMember(actorID, conversationID, userID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	kind, actorRole, userRole, err := conversationRoles(tx, conversationID, actorID, userID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case kind == ConversationDirect:
+		return fmt.Errorf("%w: direct conversations cannot have more members", ErrChatForbidden)
+	case kind == ConversationRoom:
+		return fmt.Errorf("%w: rooms are joined, not invited to", ErrInvalidConversation)
+	case chatRoleRank[actorRole] < chatRoleRank[ChatRoleAdmin]:
+		return fmt.Errorf("%w: only admins can invite members", ErrChatForbidden)
+	case userRole != "":
+		return ErrAlreadyMember
+	}
+
+	var exists, count int
+	err = tx.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM users WHERE id = ?),
+		       (SELECT COUNT(*) FROM conversation_members WHERE conversation_id = ?)
+	`, userID, conversationID).Scan(&exists, &count)
+	if err != nil {
+		return fmt.Errorf("failed to check member: %w", err)
+	}
+	if exists == 0 {
+		return ErrUserNotFound
+	}
+	if count >= MaxGroupMembers {
+		return fmt.Errorf("%w: groups have at most %d members", ErrInvalidConversation, MaxGroupMembers)
+	}
+
+	if err := addMember(tx, conversationID, userID, ChatRoleMember, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// JoinRoom makes a user a member of a public room
+func (db *Database) JoinRoom(userID, conversationID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	kind, role, _, err := conversationRoles(tx, conversationID, userID, userID)
+	if err != nil {
+		return err
+	}
+	if kind != ConversationRoom {
+		return fmt.Errorf("%w: only rooms can be joined", ErrInvalidConversation)
+	}
+	if role != "" {
+		return ErrAlreadyMember
+	}
+	var banned int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM conversation_bans WHERE conversation_id = ? AND user_id = ?",
+		conversationID, userID).Scan(&banned); err != nil {
+		return fmt.Errorf("failed to check room ban: %w", err)
+	}
+	if banned > 0 {
+		return fmt.Errorf("%w: you were removed from this room", ErrChatForbidden)
+	}
+	if err := addMember(tx, conversationID, userID, ChatRoleMember, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveMember takes a user out of a group or room: themselves, to leave, or
+// a member of lower rank, as an admin. Users an admin removes from a room are
+// banned from joining it again. A leaving owner hands the conversation to the
+// longest standing admin, or member; a group nobody is left in is deleted.
+func (db *Database) RemoveMember(actorID, conversationID, userID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	kind, actorRole, userRole, err := conversationRoles(tx, conversationID, actorID, userID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case kind == ConversationDirect:
+		return fmt.Errorf("%w: direct conversations cannot be left", ErrChatForbidden)
+	case userRole == "":
+		return ErrNotMember
+	case actorID != userID && (chatRoleRank[actorRole] < chatRoleRank[ChatRoleAdmin] || chatRoleRank[actorRole] <= chatRoleRank[userRole]):
+		return fmt.Errorf("%w: only admins can remove members, and only of lower rank", ErrChatForbidden)
+	}
+
+	if _, err := tx.Exec("DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?", conversationID, userID); err != nil {
+		return fmt.Errorf("failed to remove conversation member: %w", err)
+	}
+	if kind == ConversationRoom && actorID != userID {
+		if _, err := tx.Exec(`
+			INSERT OR REPLACE INTO conversation_bans (conversation_id, user_id, banned_by, created_at)
+			VALUES (?, ?, ?, ?)
+		`, conversationID, userID, actorID, time.Now()); err != nil {
+			return fmt.Errorf("failed to ban room member: %w", err)
+		}
+	}
+	if userRole == ChatRoleOwner {
+		var successor int
+		err := tx.QueryRow(`
+			SELECT user_id FROM conversation_members WHERE conversation_id = ?
+			ORDER BY role = ? DESC, joined_at, user_id
+			LIMIT 1
+		`, conversationID, ChatRoleAdmin).Scan(&successor)
+		switch {
+		case err == sql.ErrNoRows && kind == ConversationGroup:
+			if _, err := tx.Exec("DELETE FROM conversations WHERE id = ?", conversationID); err != nil {
+				return fmt.Errorf("failed to delete empty group: %w", err)
+			}
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return fmt.Errorf("failed to find new owner: %w", err)
+		default:
+			if err := setRole(tx, conversationID, successor, ChatRoleOwner); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// UnbanMember lets a room admin allow a removed user to join again
+func (db *Database) UnbanMember(actorID, conversationID, userID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	kind, actorRole, _, err := conversationRoles(tx, conversationID, actorID, userID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case kind != ConversationRoom:
+		return fmt.Errorf("%w: only rooms have bans", ErrInvalidConversation)
+	case chatRoleRank[actorRole] < chatRoleRank[ChatRoleAdmin]:
+		return fmt.Errorf("%w: only admins can lift bans", ErrChatForbidden)
+	}
+
+	result, err := tx.Exec("DELETE FROM conversation_bans WHERE conversation_id = ? AND user_id = ?", conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to lift room ban: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotBanned
+	}
+	return tx.Commit()
+}
+
+// SetMemberRole lets the owner make a member an admin or back. Making
+// someone else the owner hands the conversation over, leaving the previous
+// owner an admin.
+func (db *Database) SetMemberRole(actorID, conversationID, userID int, role string) error {
+	if _, ok := chatRoleRank[role]; !ok {
+		return fmt.Errorf("%w: role must be %s, %s or %s", ErrInvalidConversation, ChatRoleOwner, ChatRoleAdmin, ChatRoleMember)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	kind, actorRole, userRole, err := conversationRoles(tx, conversationID, actorID, userID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case kind == ConversationDirect:
+		return fmt.Errorf("%w: direct conversations have no roles", ErrChatForbidden)
+	case actorRole != ChatRoleOwner:
+		return fmt.Errorf("%w: only the owner can change roles", ErrChatForbidden)
+	case userRole == "":
+		return ErrNotMember
+	case actorID == userID:
+		return fmt.Errorf("%w: make another member the owner instead", ErrChatForbidden)
+	}
+
+	if err := setRole(tx, conversationID, userID, role); err != nil {
+		return err
+	}
+	if role == ChatRoleOwner {
+		if err := setRole(tx, conversationID, actorID, ChatRoleAdmin); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func setRole(tx *sql.Tx, conversationID, userID int, role string) error {
+	_, err := tx.Exec("UPDATE conversation_members SET role = ? WHERE conversation_id = ? AND user_id = ?",
+		role, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set conversation role: %w", err)
+	}
+	return nil
+}
+
+// RenameConversation lets an admin rename a group or room
+func (db *Database) RenameConversation(actorID, conversationID int, name string) error {
+	name, err := conversationName(name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	kind, actorRole, _, err := conversationRoles(tx, conversationID, actorID, actorID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case kind == ConversationDirect:
+		return fmt.Errorf("%w: direct conversations cannot be renamed", ErrChatForbidden)
+	case chatRoleRank[actorRole] < chatRoleRank[ChatRoleAdmin]:
+		return fmt.Errorf("%w: only admins can rename the conversation", ErrChatForbidden)
+	}
+
+	if _, err := tx.Exec("UPDATE conversations SET name = ? WHERE id = ?", name, conversationID); err != nil {
+		return fmt.Errorf("failed to rename conversation: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetConversation returns a conversation with its members as seen by a
+// viewer. Groups and direct conversations are only visible to their members.
+func (db *Database) GetConversation(conversationID, viewerID int) (*Conversation, error) {
+	rows, err := db.DB.Query(conversationSelect+" WHERE c.id = ?", viewerID, viewerID, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversation: %w", err)
+	}
+	conversations, err := db.scanConversations(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(conversations) == 0 {
+		return nil, ErrConversationNotFound
+	}
+	c := &conversations[0]
+	if c.Role == "" && c.Kind != ConversationRoom {
+		return nil, ErrConversationNotFound
+	}
+	if c.Members, err = db.ConversationMembers(conversationID); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ListConversations returns the conversations a user is a member of, most
+// recently active first
+func (db *Database) ListConversations(userID int) ([]Conversation, error) {
+	rows, err := db.DB.Query(conversationSelect+`
+		WHERE cm.user_id IS NOT NULL
+		ORDER BY COALESCE(last_message_id, 0) DESC, c.id DESC
+	`, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversations: %w", err)
+	}
+	return db.scanConversations(rows)
+}
+
+// ListRooms returns the rooms of a category as seen by a viewer, by name
+func (db *Database) ListRooms(categoryID, viewerID int) ([]Conversation, error) {
+	rows, err := db.DB.Query(conversationSelect+`
+		WHERE c.kind = ? AND c.category_id = ?
+		ORDER BY c.name COLLATE NOCASE, c.id
+	`, viewerID, viewerID, ConversationRoom, categoryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rooms: %w", err)
+	}
+	return db.scanConversations(rows)
+}
+
+// scanConversations reads rows selected with conversationSelect and loads
+// their last messages
+func (db *Database) scanConversations(rows *sql.Rows) ([]Conversation, error) {
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	var lastIDs []int
+	for rows.Next() {
+		var c Conversation
+		var lastID sql.NullInt64
+		err := rows.Scan(&c.ID, &c.Kind, &c.Name, &c.CategoryID, &c.CreatedAt, &c.Role, &c.MemberCount,
+			&c.UnreadCount, &lastID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan conversation: %w", err)
+		}
+		conversations = append(conversations, c)
+		lastIDs = append(lastIDs, int(lastID.Int64))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during conversation iteration: %w", err)
+	}
+	rows.Close()
+
+	last, err := db.messagesByID(lastIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range conversations {
+		if m, ok := last[lastIDs[i]]; ok {
+			conversations[i].LastMessage = &m
+		}
+	}
+	return conversations, nil
+}
+
+// ConversationMembers lists the members of a conversation, owner first
+func (db *Database) ConversationMembers(conversationID int) ([]ConversationMember, error) {
+	rows, err := db.DB.Query(`
+		SELECT cm.user_id, u.nickname, cm.role, cm.joined_at
+		FROM conversation_members cm JOIN users u ON u.id = cm.user_id
+		WHERE cm.conversation_id = ?
+		ORDER BY CASE cm.role WHEN ? THEN 0 WHEN ? THEN 1 ELSE 2 END, cm.joined_at, cm.user_id
+	`, conversationID, ChatRoleOwner, ChatRoleAdmin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversation members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []ConversationMember{}
+	for rows.Next() {
+		var m ConversationMember
+		if err := rows.Scan(&m.UserID, &m.Nickname, &m.Role, &m.JoinedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation member: %w", err)
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// ConversationMemberIDs returns the IDs of the members of a conversation
+func (db *Database) ConversationMemberIDs(conversationID int) ([]int, error) {
+	rows, err := db.DB.Query("SELECT user_id FROM conversation_members WHERE conversation_id = ?", conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversation members: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation member: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// IsConversationMember tells whether a user is a member of a conversation
+func (db *Database) IsConversationMember(conversationID, userID int) (bool, error) {
+	var count int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM conversation_members WHERE conversation_id = ? AND user_id = ?",
+		conversationID, userID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check conversation member: %w", err)
+	}
+	return count > 0, nil
+}
+
+// MarkConversationRead marks a conversation read up to a message, or up to
+// the latest message when messageID is 0. Read markers never move back.
+func (db *Database) MarkConversationRead(userID, conversationID, messageID int) error {
+	result, err := db.DB.Exec(`
+		UPDATE conversation_members
+		SET last_read_id = MAX(last_read_id, MIN(
+			COALESCE((SELECT MAX(id) FROM messages WHERE conversation_id = ?), 0),
+			CASE WHEN ? > 0 THEN ? ELSE COALESCE((SELECT MAX(id) FROM messages WHERE conversation_id = ?), 0) END))
+		WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, messageID, messageID, conversationID, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to mark conversation read: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+// CountUnreadMessages counts the unread messages of a user across all their
+// conversations
+func (db *Database) CountUnreadMessages(userID int) (int, error) {
+	var count int
+	err := db.DB.QueryRow(`
+		SELECT COALESCE(SUM((`+unreadMessagesQuery+`)), 0)
+		FROM conversation_members cm JOIN conversations c ON c.id = cm.conversation_id
+		WHERE cm.user_id = ?
+	`, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unread messages: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRemovedRoomMembersCannotRejoinUntilUnbanned(t *testing.T) {
+	db, _ := newTestDB(t)
+	aliceID, _ := newTestPost(t, db)
+	if err := db.RegisterUser("bob", "bob@example.com", "secret", "B", "Bob", "other", 30); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+	var bobID int
+	if err := db.DB.QueryRow("SELECT id FROM users WHERE nickname = 'bob'").Scan(&bobID); err != nil {
+		t.Fatalf("loading user: %v", err)
+	}
+	var categoryID int
+	if err := db.DB.QueryRow("SELECT id FROM categories WHERE name = 'General'").Scan(&categoryID); err != nil {
+		t.Fatalf("loading category: %v", err)
+	}
+	roomID, err := db.CreateRoom(aliceID, categoryID, "Lobby")
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	// Leaving is not a ban
+	if err := db.JoinRoom(bobID, roomID); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+	if err := db.RemoveMember(bobID, roomID, bobID); err != nil {
+		t.Fatalf("leaving: %v", err)
+	}
+	if err := db.JoinRoom(bobID, roomID); err != nil {
+		t.Fatalf("JoinRoom after leaving: %v", err)
+	}
+
+	if err := db.RemoveMember(aliceID, roomID, bobID); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+	if err := db.JoinRoom(bobID, roomID); !errors.Is(err, ErrChatForbidden) {
+		t.Fatalf("JoinRoom after removal = %v, want ErrChatForbidden", err)
+	}
+	if err := db.UnbanMember(bobID, roomID, bobID); !errors.Is(err, ErrChatForbidden) {
+		t.Errorf("UnbanMember by the banned user = %v, want ErrChatForbidden", err)
+	}
+
+	if err := db.UnbanMember(aliceID, roomID, bobID); err != nil {
+		t.Fatalf("UnbanMember: %v", err)
+	}
+	if err := db.UnbanMember(aliceID, roomID, bobID); !errors.Is(err, ErrNotBanned) {
+		t.Errorf("second UnbanMember = %v, want ErrNotBanned", err)
+	}
+	if err := db.JoinRoom(bobID, roomID); err != nil {
+		t.Errorf("JoinRoom after unban: %v", err)
+	}
+}
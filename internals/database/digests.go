@@ -0,0 +1,218 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Digest frequencies, chosen with UserSettingDigest
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestPeriods is how long a user must have been away, and how long to wait
+// between two digests, for each frequency
+var DigestPeriods = map[string]time.Duration{
+	DigestDaily:  24 * time.Hour,
+	DigestWeekly: 7 * 24 * time.Hour,
+}
+
+// DigestRecipient is a user who has not turned email digests off
+type DigestRecipient struct {
+	UserID       int
+	Nickname     string
+	Email        string
+	Frequency    string
+	LastActiveAt time.Time // the latest of sign up, last login and last seen online
+	LastSentAt   time.Time // zero when no digest was sent yet
+}
+
+// DigestPost is a popular new post in a category the user watches
+type DigestPost struct {
+	ID        int
+	Title     string
+	Category  string
+	Author    string
+	Comments  int
+	Reactions int
+}
+
+// DigestMention is an unread mention
+type DigestMention struct {
+	Actor      string
+	TargetType string
+	TargetID   int
+	PostID     int // the post a mentioning comment belongs to
+	CreatedAt  time.Time
+}
+
+// DigestConversation counts the unread messages of a direct conversation or group
+type DigestConversation struct {
+	ID    int
+	Kind  string
+	Name  string // the other user's nickname in direct conversations
+	Count int
+}
+
+// GetDigestRecipients lists every user with digests on, with what is needed
+// to tell whether a digest is due
+func (db *Database) GetDigestRecipients() ([]DigestRecipient, error) {
+	rows, err := db.DB.Query(`
+		SELECT u.id, u.nickname, u.email, COALESCE(st.value, ?), u.created_at, us.last_seen, s.created_at, d.last_sent_at
+		FROM users u
+		LEFT JOIN user_settings st ON st.user_id = u.id AND st.key = ?
+		LEFT JOIN user_status us ON us.user_id = u.id
+		LEFT JOIN sessions s ON s.id = (SELECT MAX(id) FROM sessions WHERE user_id = u.id)
+		LEFT JOIN digests d ON d.user_id = u.id
+		WHERE COALESCE(st.value, ?) != ?
+	`, userSettingDefaults[UserSettingDigest], UserSettingDigest, userSettingDefaults[UserSettingDigest], DigestOff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query digest recipients: %w", err)
+	}
+	defer rows.Close()
+
+	var recipients []DigestRecipient
+	for rows.Next() {
+		var r DigestRecipient
+		var createdAt, lastSeen, lastLogin, lastSent sql.NullTime
+		err := rows.Scan(&r.UserID, &r.Nickname, &r.Email, &r.Frequency, &createdAt, &lastSeen, &lastLogin, &lastSent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan digest recipient: %w", err)
+		}
+		for _, t := range []sql.NullTime{createdAt, lastSeen, lastLogin} {
+			if t.Valid && t.Time.After(r.LastActiveAt) {
+				r.LastActiveAt = t.Time
+			}
+		}
+		if lastSent.Valid {
+			r.LastSentAt = lastSent.Time
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// RecordDigestSent remembers when a user was last sent a digest
+func (db *Database) RecordDigestSent(userID int, at time.Time) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO digests (user_id, last_sent_at) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET last_sent_at = excluded.last_sent_at
+	`, userID, at)
+	if err != nil {
+		return fmt.Errorf("failed to record digest: %w", err)
+	}
+	return nil
+}
+
+// DigestTopPosts returns the hottest posts by others created since a time in
+// the categories a user watches and their subcategories, leaving out muted
+// categories and posts
+func (db *Database) DigestTopPosts(userID int, since time.Time, limit int) ([]DigestPost, error) {
+	rows, err := db.DB.Query(`
+		SELECT p.id, p.title, c.name, u.nickname, COALESCE(ps.comment_count, 0), COALESCE(ps.reaction_count, 0)
+		FROM posts p
+		JOIN categories c ON c.id = p.category_id
+		JOIN users u ON u.id = p.user_id
+		LEFT JOIN post_stats ps ON ps.post_id = p.id
+		WHERE p.category_id IN (`+watchedCategoriesQuery+`)
+		  AND p.hidden = FALSE AND p.user_id != ? AND p.created_at > ?
+		  AND NOT EXISTS (
+			SELECT 1 FROM subscriptions m
+			WHERE m.user_id = ? AND m.target_type = 'post' AND m.target_id = p.id AND m.level = 'mute')
+		ORDER BY COALESCE(ps.hot_score, 0) DESC, p.created_at DESC
+		LIMIT ?
+	`, userID, userID, userID, since, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query digest posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []DigestPost
+	for rows.Next() {
+		var p DigestPost
+		if err := rows.Scan(&p.ID, &p.Title, &p.Category, &p.Author, &p.Comments, &p.Reactions); err != nil {
+			return nil, fmt.Errorf("failed to scan digest post: %w", err)
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// DigestMentions returns the latest unread mentions of a user and how many
+// unread mentions there are in total
+func (db *Database) DigestMentions(userID, limit int) ([]DigestMention, int, error) {
+	var total int
+	err := db.DB.QueryRow(
+		"SELECT COUNT(*) FROM notifications WHERE user_id = ? AND kind = ? AND read_at IS NULL",
+		userID, NotifyMention,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count unread mentions: %w", err)
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT COALESCE(u.nickname, ''), n.target_type, n.target_id,
+		       CASE n.target_type WHEN 'post' THEN n.target_id ELSE COALESCE(c.post_id, 0) END, n.created_at
+		FROM notifications n
+		LEFT JOIN users u ON u.id = n.actor_id
+		LEFT JOIN comments c ON n.target_type = 'comment' AND c.id = n.target_id
+		WHERE n.user_id = ? AND n.kind = ? AND n.read_at IS NULL
+		ORDER BY n.id DESC
+		LIMIT ?
+	`, userID, NotifyMention, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query unread mentions: %w", err)
+	}
+	defer rows.Close()
+
+	var mentions []DigestMention
+	for rows.Next() {
+		var m DigestMention
+		if err := rows.Scan(&m.Actor, &m.TargetType, &m.TargetID, &m.PostID, &m.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan unread mention: %w", err)
+		}
+		mentions = append(mentions, m)
+	}
+	return mentions, total, rows.Err()
+}
+
+// DigestConversations counts the unread messages of a user in direct
+// conversations and groups, most recently active first. Rooms are left out,
+// as are messages from users the reader blocked or muted in groups.
+func (db *Database) DigestConversations(userID int) ([]DigestConversation, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, kind, name, unread FROM (
+			SELECT c.id, c.kind,
+			       CASE c.kind WHEN ? THEN COALESCE((
+			           SELECT u.nickname FROM conversation_members o JOIN users u ON u.id = o.user_id
+			           WHERE o.conversation_id = c.id AND o.user_id != cm.user_id), '') ELSE c.name END AS name,
+			       (`+unreadMessagesQuery+`) AS unread,
+			       (SELECT MAX(id) FROM messages WHERE conversation_id = c.id) AS last_message_id
+			FROM conversation_members cm
+			JOIN conversations c ON c.id = cm.conversation_id
+			WHERE cm.user_id = ? AND c.kind != ?
+		)
+		WHERE unread > 0
+		ORDER BY last_message_id DESC
+	`, ConversationDirect, userID, ConversationRoom)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unread messages: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []DigestConversation
+	for rows.Next() {
+		var c DigestConversation
+		if err := rows.Scan(&c.ID, &c.Kind, &c.Name, &c.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan unread messages: %w", err)
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
@@ -0,0 +1,65 @@
+package database
+
+import "testing"
+
+func TestDigestConversationsCountsUnreadMessages(t *testing.T) {
+	db, _ := newTestDB(t)
+	aliceID, _ := newTestPost(t, db)
+	if err := db.RegisterUser("bob", "bob@example.com", "secret", "B", "Bob", "other", 30); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+	var bobID int
+	if err := db.DB.QueryRow("SELECT id FROM users WHERE nickname = 'bob'").Scan(&bobID); err != nil {
+		t.Fatalf("loading user: %v", err)
+	}
+	directID, err := db.DirectConversation(aliceID, bobID, true)
+	if err != nil {
+		t.Fatalf("DirectConversation: %v", err)
+	}
+	groupID, err := db.CreateGroup(bobID, "Book club", []int{aliceID})
+	if err != nil {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+
+	send := func(conversationID, senderID int, content string) {
+		t.Helper()
+		if _, err := db.CreateMessage(conversationID, senderID, content, nil); err != nil {
+			t.Fatalf("CreateMessage: %v", err)
+		}
+	}
+	send(directID, bobID, "read already")
+	if err := db.MarkConversationRead(aliceID, directID, 0); err != nil {
+		t.Fatalf("MarkConversationRead: %v", err)
+	}
+	send(directID, bobID, "one")
+	send(directID, aliceID, "my own")
+	send(directID, bobID, "two")
+	send(groupID, bobID, "chapter one")
+
+	conversations, err := db.DigestConversations(aliceID)
+	if err != nil {
+		t.Fatalf("DigestConversations: %v", err)
+	}
+	want := []DigestConversation{
+		{ID: groupID, Kind: ConversationGroup, Name: "Book club", Count: 1},
+		{ID: directID, Kind: ConversationDirect, Name: "bob", Count: 2},
+	}
+	if len(conversations) != len(want) {
+		t.Fatalf("DigestConversations = %+v, want %+v", conversations, want)
+	}
+	for i := range want {
+		if conversations[i] != want[i] {
+			t.Errorf("conversation %d = %+v, want %+v", i, conversations[i], want[i])
+		}
+	}
+
+	if err := db.MarkConversationRead(aliceID, groupID, 0); err != nil {
+		t.Fatalf("MarkConversationRead: %v", err)
+	}
+	if err := db.MarkConversationRead(aliceID, directID, 0); err != nil {
+		t.Fatalf("MarkConversationRead: %v", err)
+	}
+	if conversations, err := db.DigestConversations(aliceID); err != nil || len(conversations) != 0 {
+		t.Errorf("DigestConversations after reading = %+v, %v; want none", conversations, err)
+	}
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"real-time-forum/internals/realtime"
+)
+
+// EventLogSize is how many of their latest real-time events are kept per
+// user for replay. Clients that missed more have to reload their state.
+const EventLogSize = 500
+
+// Event types whose data is a Message
+const (
+	EventMessage        = "message"
+	EventMessageUpdated = "message_updated"
+)
+
+// AppendEvent stores a published event in the user's log, numbered after
+// their previous one, and prunes events beyond EventLogSize. It implements
+// realtime.EventLog.
+func (db *Database) AppendEvent(userID int, eventType string, data []byte) (int64, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Pruning keeps the latest events, so the numbering never restarts.
+	// Numbering in the insert makes it the first statement, so the
+	// transaction waits for other writers instead of failing to upgrade a
+	// read lock when the log is written for several users at once.
+	var seq int64
+	err = tx.QueryRow(`
+		INSERT INTO user_events (user_id, seq, type, data, created_at)
+		SELECT ?, COALESCE(MAX(seq), 0) + 1, ?, ?, ? FROM user_events WHERE user_id = ?
+		RETURNING seq
+	`, userID, eventType, string(data), time.Now(), userID).Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("failed to log event: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM user_events WHERE user_id = ? AND seq <= ?", userID, seq-EventLogSize); err != nil {
+		return 0, fmt.Errorf("failed to prune event log: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit event: %w", err)
+	}
+	return seq, nil
+}
+
+// redactMessageEvents blanks a message deleted for everyone in the logged
+// events carrying it, so that replays no longer reveal its content
+func redactMessageEvents(tx *sql.Tx, messageID int) error {
+	_, err := tx.Exec(`
+		UPDATE user_events
+		SET data = json_set(json_remove(data, '$.mentions', '$.attachments'), '$.content', '', '$.deleted', json('true'))
+		WHERE type IN (?, ?) AND json_valid(data) AND json_extract(data, '$.id') = ?
+	`, EventMessage, EventMessageUpdated, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to redact logged message events: %w", err)
+	}
+	return nil
+}
+
+// EventsSince returns the logged events of a user after a sequence number,
+// oldest first. complete is false when events after it were already pruned,
+// or when the number is ahead of the log.
+func (db *Database) EventsSince(userID int, seq int64) ([]realtime.Event, bool, error) {
+	last, err := db.LastEventSeq(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	if seq > last {
+		return nil, false, nil
+	}
+
+	rows, err := db.DB.Query("SELECT seq, type, data FROM user_events WHERE user_id = ? AND seq > ? ORDER BY seq",
+		userID, seq)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query event log: %w", err)
+	}
+	defer rows.Close()
+
+	var events []realtime.Event
+	for rows.Next() {
+		var e realtime.Event
+		var data string
+		if err := rows.Scan(&e.Seq, &e.Type, &data); err != nil {
+			return nil, false, fmt.Errorf("failed to scan event: %w", err)
+		}
+		if data != "" {
+			e.Data = json.RawMessage(data)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("error during event iteration: %w", err)
+	}
+	complete := seq == last || (len(events) > 0 && events[0].Seq == seq+1)
+	return events, complete, nil
+}
+
+// LastEventSeq returns the sequence number of a user's latest event, 0 if
+// they have none
+func (db *Database) LastEventSeq(userID int) (int64, error) {
+	var seq int64
+	if err := db.DB.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM user_events WHERE user_id = ?", userID).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to get last event: %w", err)
+	}
+	return seq, nil
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDeleteMessageRedactsLoggedEvents(t *testing.T) {
+	db, _ := newTestDB(t)
+	aliceID, _ := newTestPost(t, db)
+	if err := db.RegisterUser("bob", "bob@example.com", "secret", "B", "Bob", "other", 30); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+	var bobID int
+	if err := db.DB.QueryRow("SELECT id FROM users WHERE nickname = 'bob'").Scan(&bobID); err != nil {
+		t.Fatalf("loading user: %v", err)
+	}
+	conversationID, err := db.DirectConversation(aliceID, bobID, true)
+	if err != nil {
+		t.Fatalf("DirectConversation: %v", err)
+	}
+
+	logMessage := func(eventType string, messageID int) {
+		t.Helper()
+		message, err := db.GetMessageByID(messageID)
+		if err != nil {
+			t.Fatalf("GetMessageByID: %v", err)
+		}
+		data, err := json.Marshal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.AppendEvent(bobID, eventType, data); err != nil {
+			t.Fatalf("AppendEvent: %v", err)
+		}
+	}
+	secretID, err := db.CreateMessage(conversationID, aliceID, "the secret", nil)
+	if err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	keptID, err := db.CreateMessage(conversationID, aliceID, "still here", nil)
+	if err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	logMessage(EventMessage, secretID)
+	logMessage(EventMessage, keptID)
+	if _, err := db.AppendEvent(bobID, "unread", nil); err != nil {
+		t.Fatalf("AppendEvent: %v", err)
+	}
+	if err := db.EditMessage(aliceID, secretID, "the edited secret", nil); err != nil {
+		t.Fatalf("EditMessage: %v", err)
+	}
+	logMessage(EventMessageUpdated, secretID)
+
+	if err := db.DeleteMessage(aliceID, secretID); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+
+	events, complete, err := db.EventsSince(bobID, 0)
+	if err != nil || !complete || len(events) != 4 {
+		t.Fatalf("EventsSince = %d events, %v, %v; want 4 complete", len(events), complete, err)
+	}
+	for _, e := range events {
+		if e.Data == nil {
+			continue
+		}
+		var message Message
+		if err := json.Unmarshal(e.Data.(json.RawMessage), &message); err != nil {
+			t.Fatalf("decoding event %d: %v", e.Seq, err)
+		}
+		switch {
+		case message.ID == secretID && (message.Content != "" || !message.Deleted):
+			t.Errorf("event %d still carries the deleted message: %+v", e.Seq, message)
+		case message.ID == keptID && message.Content != "still here":
+			t.Errorf("event %d lost the content of another message: %+v", e.Seq, message)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Sort orders accepted by ListPosts
+const (
+	SortNew = "new"
+	SortHot = "hot"
+)
+
+// PostFilter narrows down and orders the posts returned by ListPosts
+type PostFilter struct {
+	CategoryID           int      // 0 means any category
+	IncludeSubcategories bool     // also match posts in subcategories of CategoryID
+	Tags                 []string // posts must carry every listed tag
+	Sort                 string   // SortNew (default) or SortHot
+	ReaderID             int      // when set, unread comment counts are attached for this user and posts by users they blocked or muted are left out
+	AuthorID             int      // 0 means any author
+	FollowerID           int      // when set, only posts by users this user follows or in categories they watch
+	Limit                int      // 0 means no limit
+	Offset               int
+}
+
+// postColumns is the column list every post listing selects, in scanPosts order
+const postColumns = `p.id, p.title, p.content, p.user_id, p.category_id, c.name, u.nickname, p.created_at, p.updated_at`
+
+// ListPosts retrieves posts matching the filter, with their tags attached
+func (db *Database) ListPosts(filter PostFilter) ([]Post, error) {
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		JOIN users u ON p.user_id = u.id
+		JOIN categories c ON p.category_id = c.id
+		LEFT JOIN post_stats s ON s.post_id = p.id`
+	conditions := []string{"p.hidden = 0"}
+	var args []interface{}
+
+	if filter.CategoryID > 0 && filter.IncludeSubcategories {
+		conditions = append(conditions, "p.category_id IN ("+subtreeQuery+")")
+		args = append(args, filter.CategoryID)
+	} else if filter.CategoryID > 0 {
+		conditions = append(conditions, "p.category_id = ?")
+		args = append(args, filter.CategoryID)
+	}
+
+	if filter.AuthorID > 0 {
+		conditions = append(conditions, "p.user_id = ?")
+		args = append(args, filter.AuthorID)
+	}
+
+	if filter.ReaderID > 0 {
+		conditions = append(conditions, "p.user_id NOT IN ("+ignoredUsersQuery+")")
+		args = append(args, filter.ReaderID)
+	}
+
+	if filter.FollowerID > 0 {
+		// Both halves of the union are index lookups, so the feed never
+		// scans posts by people or in categories the user does not follow
+		conditions = append(conditions, `p.id IN (
+			SELECT fp.id FROM follows f JOIN posts fp ON fp.user_id = f.followee_id
+			WHERE f.follower_id = ?
+			UNION
+			SELECT wp.id FROM posts wp WHERE wp.category_id IN (`+watchedCategoriesQuery+`))`,
+			"p.user_id != ?",
+			`NOT EXISTS (
+			SELECT 1 FROM subscriptions m
+			WHERE m.user_id = ? AND m.level = 'mute'
+			  AND ((m.target_type = 'post' AND m.target_id = p.id)
+			    OR (m.target_type = 'category' AND m.target_id = p.category_id)))`)
+		args = append(args, filter.FollowerID, filter.FollowerID, filter.FollowerID, filter.FollowerID, filter.FollowerID)
+	}
+
+	if len(filter.Tags) > 0 {
+		conditions = append(conditions, `p.id IN (
+			SELECT pt.post_id FROM post_tags pt
+			JOIN tags t ON t.id = pt.tag_id
+			WHERE t.name IN (`+placeholders(len(filter.Tags))+`)
+			GROUP BY pt.post_id
+			HAVING COUNT(DISTINCT t.id) = ?)`)
+		for _, tag := range filter.Tags {
+			args = append(args, tag)
+		}
+		args = append(args, len(filter.Tags))
+	}
+
+	query += " WHERE " + strings.Join(conditions, " AND ")
+
+	switch filter.Sort {
+	case SortHot:
+		query += " ORDER BY s.hot_score DESC, p.created_at DESC"
+	case "", SortNew:
+		query += " ORDER BY p.created_at DESC"
+	default:
+		return nil, fmt.Errorf("unknown sort order: %s", filter.Sort)
+	}
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query posts: %w", err)
+	}
+	defer rows.Close()
+
+	posts, err := scanPosts(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.attachTags(posts); err != nil {
+		return nil, err
+	}
+	if err := db.attachPostMentions(posts); err != nil {
+		return nil, err
+	}
+	if err := db.attachPostAttachments(posts); err != nil {
+		return nil, err
+	}
+	if filter.ReaderID != 0 {
+		if err := db.attachUnread(filter.ReaderID, posts); err != nil {
+			return nil, err
+		}
+	}
+	return posts, nil
+}
+
+// placeholders returns n comma separated SQL parameter markers
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// maxQueryIDs caps how many IDs go into one IN list, well below the number
+// of variables SQLite allows in a statement
+const maxQueryIDs = 500
+
+// inChunks calls fn with consecutive runs of at most maxQueryIDs of ids
+func inChunks(ids []int, fn func(chunk []int) error) error {
+	for len(ids) > 0 {
+		n := min(len(ids), maxQueryIDs)
+		if err := fn(ids[:n]); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// postIDs returns the IDs of posts, in order
+func postIDs(posts []Post) []int {
+	ids := make([]int, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	return ids
+}
+
+// scanPosts reads rows selected with postColumns
+func scanPosts(rows *sql.Rows) ([]Post, error) {
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		var createdAtStr, updatedAtStr string
+		err := rows.Scan(
+			&post.ID,
+			&post.Title,
+			&post.Content,
+			&post.UserID,
+			&post.CategoryID,
+			&post.Category,
+			&post.Author,
+			&createdAtStr,
+			&updatedAtStr,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan post row: %w", err)
+		}
+
+		post.CreatedAt, err = time.Parse(time.RFC3339, createdAtStr)
+		if err != nil {
+			log.Printf("failed to parse created_at timestamp: %v", err)
+			post.CreatedAt = time.Now()
+		}
+		post.UpdatedAt, err = time.Parse(time.RFC3339, updatedAtStr)
+		if err != nil {
+			log.Printf("failed to parse updated_at timestamp: %v", err)
+			post.UpdatedAt = time.Now()
+		}
+
+		posts = append(posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return posts, nil
+}
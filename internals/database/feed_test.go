@@ -0,0 +1,26 @@
+package database
+
+import "testing"
+
+// Listing every post of a large forum must not run into SQLite's limit on
+// variables per statement
+func TestAttachToManyPosts(t *testing.T) {
+	db, _ := newTestDB(t)
+	posts := make([]Post, 40000)
+	for i := range posts {
+		posts[i].ID = i + 1
+	}
+
+	if err := db.attachTags(posts); err != nil {
+		t.Errorf("attachTags: %v", err)
+	}
+	if err := db.attachPostMentions(posts); err != nil {
+		t.Errorf("attachPostMentions: %v", err)
+	}
+	if err := db.attachPostAttachments(posts); err != nil {
+		t.Errorf("attachPostAttachments: %v", err)
+	}
+	if err := db.attachUnread(1, posts); err != nil {
+		t.Errorf("attachUnread: %v", err)
+	}
+}
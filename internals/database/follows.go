@@ -0,0 +1,124 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrFollowSelf   = errors.New("cannot follow yourself")
+	ErrNotFollowing = errors.New("not following this user")
+)
+
+// FollowUser is a user listed as a follower or followee
+type FollowUser struct {
+	ID         int       `json:"id"`
+	Nickname   string    `json:"nickname"`
+	Avatar     string    `json:"-"`
+	AvatarURL  string    `json:"avatarUrl"`
+	FollowedAt time.Time `json:"followedAt"`
+}
+
+// Follow makes a user follow another. Following someone twice is not an error.
+func (db *Database) Follow(followerID, followeeID int) error {
+	if followerID == followeeID {
+		return ErrFollowSelf
+	}
+	_, err := db.DB.Exec(
+		"INSERT OR IGNORE INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?)",
+		followerID, followeeID, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to follow user: %w", err)
+	}
+	return nil
+}
+
+// Unfollow stops a user from following another
+func (db *Database) Unfollow(followerID, followeeID int) error {
+	result, err := db.DB.Exec("DELETE FROM follows WHERE follower_id = ? AND followee_id = ?", followerID, followeeID)
+	if err != nil {
+		return fmt.Errorf("failed to unfollow user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFollowing
+	}
+	return nil
+}
+
+// IsFollowing tells whether a user follows another
+func (db *Database) IsFollowing(followerID, followeeID int) (bool, error) {
+	var count int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM follows WHERE follower_id = ? AND followee_id = ?",
+		followerID, followeeID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check follow: %w", err)
+	}
+	return count > 0, nil
+}
+
+// ListFollowers returns a page of the users following a user, latest first
+func (db *Database) ListFollowers(userID, limit, offset int) ([]FollowUser, error) {
+	return db.listFollows(`
+		SELECT u.id, u.nickname, u.avatar, f.created_at
+		FROM follows f JOIN users u ON u.id = f.follower_id
+		WHERE f.followee_id = ?
+		ORDER BY f.created_at DESC, u.id DESC
+		LIMIT ? OFFSET ?
+	`, userID, limit, offset)
+}
+
+// ListFollowing returns a page of the users a user follows, latest first
+func (db *Database) ListFollowing(userID, limit, offset int) ([]FollowUser, error) {
+	return db.listFollows(`
+		SELECT u.id, u.nickname, u.avatar, f.created_at
+		FROM follows f JOIN users u ON u.id = f.followee_id
+		WHERE f.follower_id = ?
+		ORDER BY f.created_at DESC, u.id DESC
+		LIMIT ? OFFSET ?
+	`, userID, limit, offset)
+}
+
+func (db *Database) listFollows(query string, userID, limit, offset int) ([]FollowUser, error) {
+	rows, err := db.DB.Query(query, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query follows: %w", err)
+	}
+	defer rows.Close()
+
+	users := []FollowUser{}
+	for rows.Next() {
+		var u FollowUser
+		if err := rows.Scan(&u.ID, &u.Nickname, &u.Avatar, &u.FollowedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan follow: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// FollowersToNotify lists the followers of an author who want to hear about
+// the author's new posts
+func (db *Database) FollowersToNotify(authorID int) ([]int, error) {
+	rows, err := db.DB.Query(`
+		SELECT f.follower_id
+		FROM follows f
+		LEFT JOIN user_settings s ON s.user_id = f.follower_id AND s.key = ?
+		WHERE f.followee_id = ? AND COALESCE(s.value, ?) = 'true'
+	`, UserSettingFollowedPosts, authorID, userSettingDefaults[UserSettingFollowedPosts])
+	if err != nil {
+		return nil, fmt.Errorf("failed to query followers: %w", err)
+	}
+	defer rows.Close()
+
+	var followers []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan follower: %w", err)
+		}
+		followers = append(followers, id)
+	}
+	return followers, rows.Err()
+}
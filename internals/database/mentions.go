@@ -0,0 +1,166 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// MaxMentions caps how many users a single post, comment or message can mention
+const MaxMentions = 10
+
+// Mention is a user mentioned in content. Mentions are stored by user ID,
+// so they keep pointing at the user after a nickname change.
+type Mention struct {
+	UserID   int    `json:"userId"`
+	Nickname string `json:"nickname"` // the user's current nickname
+	Handle   string `json:"-"`        // the nickname as written in the content
+}
+
+// UserSuggestion is a nickname offered while typing an @mention
+type UserSuggestion struct {
+	ID       int    `json:"id"`
+	Nickname string `json:"nickname"`
+}
+
+// RecordMentions links the @handles written in content to users, ignoring
+// handles that match nobody, and returns the resulting mentions
+func (db *Database) RecordMentions(sourceType string, sourceID int, handles []string) ([]Mention, error) {
+	if len(handles) > MaxMentions {
+		handles = handles[:MaxMentions]
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var mentions []Mention
+	for _, handle := range handles {
+		// Nicknames are unique but case-sensitive, so an exact match wins
+		m := Mention{Handle: handle}
+		err := tx.QueryRow(`
+			SELECT id, nickname FROM users
+			WHERE nickname = ? COLLATE NOCASE
+			ORDER BY nickname = ? DESC
+			LIMIT 1
+		`, handle, handle).Scan(&m.UserID, &m.Nickname)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve mention: %w", err)
+		}
+
+		result, err := tx.Exec(
+			"INSERT OR IGNORE INTO mentions (source_type, source_id, user_id, handle) VALUES (?, ?, ?, ?)",
+			sourceType, sourceID, m.UserID, handle,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store mention: %w", err)
+		}
+		// Different spellings of the same nickname mention the user once
+		if n, _ := result.RowsAffected(); n > 0 {
+			mentions = append(mentions, m)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit mentions: %w", err)
+	}
+	return mentions, nil
+}
+
+// AllowsMentions reports whether a user wants to be notified of mentions
+func (db *Database) AllowsMentions(userID int) (bool, error) {
+	allowed, err := db.GetUserSetting(userID, UserSettingAllowMentions)
+	if err != nil {
+		return false, err
+	}
+	return allowed == "true", nil
+}
+
+// mentionsOf loads the mentions of several posts, comments or messages by ID
+func (db *Database) mentionsOf(sourceType string, ids []int) (map[int][]Mention, error) {
+	mentions := make(map[int][]Mention)
+	err := inChunks(ids, func(ids []int) error {
+		args := []interface{}{sourceType}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+
+		rows, err := db.DB.Query(`
+			SELECT m.source_id, m.user_id, u.nickname, m.handle
+			FROM mentions m
+			JOIN users u ON u.id = m.user_id
+			WHERE m.source_type = ? AND m.source_id IN (`+placeholders(len(ids))+`)
+		`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query mentions: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var sourceID int
+			var m Mention
+			if err := rows.Scan(&sourceID, &m.UserID, &m.Nickname, &m.Handle); err != nil {
+				return fmt.Errorf("failed to scan mention: %w", err)
+			}
+			mentions[sourceID] = append(mentions[sourceID], m)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mentions, nil
+}
+
+// attachPostMentions fills in the Mentions of each post
+func (db *Database) attachPostMentions(posts []Post) error {
+	mentions, err := db.mentionsOf(TargetPost, postIDs(posts))
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Mentions = mentions[posts[i].ID]
+	}
+	return nil
+}
+
+// SearchUsers returns users whose nickname starts with prefix, for @mention
+// autocomplete. The prefix search uses the case-insensitive nickname index.
+func (db *Database) SearchUsers(prefix string, limit int) ([]UserSuggestion, error) {
+	prefix = strings.TrimSpace(strings.TrimPrefix(prefix, "@"))
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	rows, err := db.DB.Query(`
+		SELECT id, nickname FROM users
+		WHERE nickname LIKE ? ESCAPE '\'
+		ORDER BY nickname COLLATE NOCASE
+		LIMIT ?
+	`, escaped+"%", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []UserSuggestion{}
+	for rows.Next() {
+		var u UserSuggestion
+		if err := rows.Scan(&u.ID, &u.Nickname); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// GetMentions returns the mentions of a single post, comment or message
+func (db *Database) GetMentions(sourceType string, sourceID int) ([]Mention, error) {
+	mentions, err := db.mentionsOf(sourceType, []int{sourceID})
+	if err != nil {
+		return nil, err
+	}
+	return mentions[sourceID], nil
+}
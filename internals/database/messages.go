@@ -0,0 +1,452 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var (
+	ErrMessageNotFound   = errors.New("message not found")
+	ErrMessageDeleted    = errors.New("message was deleted")
+	ErrEditWindowExpired = errors.New("messages can only be edited shortly after sending")
+)
+
+// MessageEditWindow is how long after sending a message its sender may edit it
+const MessageEditWindow = 15 * time.Minute
+
+// Message is a chat message in a conversation. The JSON names match what the
+// chat frontend reads; recipient_id is only set in direct conversations.
+// Messages deleted for everyone keep their place in the history with no
+// content.
+type Message struct {
+	ID             int               `json:"id"`
+	ConversationID int               `json:"conversation_id"`
+	SenderID       int               `json:"sender_id"`
+	SenderUsername string            `json:"sender_username"`
+	RecipientID    int               `json:"recipient_id"`
+	Content        string            `json:"content"`
+	Hidden         bool              `json:"-"`
+	CreatedAt      time.Time         `json:"created_at"`
+	EditedAt       *time.Time        `json:"edited_at,omitempty"`
+	Deleted        bool              `json:"deleted,omitempty"`
+	Mentions       []Mention         `json:"mentions,omitempty"`
+	Attachments    []Attachment      `json:"attachments,omitempty"`
+	Reactions      []MessageReaction `json:"reactions,omitempty"`
+}
+
+// MessageReaction is one emoji on a message with the users who left it
+type MessageReaction struct {
+	Reaction string `json:"reaction"`
+	Count    int    `json:"count"`
+	UserIDs  []int  `json:"userIds"`
+}
+
+// MessageEdit is an earlier version of a message
+type MessageEdit struct {
+	Content    string    `json:"content"`
+	ReplacedAt time.Time `json:"replacedAt"`
+}
+
+// messageColumns is the column list every message query selects, in
+// scanMessages order
+const messageColumns = `m.id, m.conversation_id, m.sender_id, u.nickname, COALESCE(m.recipient_id, 0), m.content, m.hidden,
+	m.created_at, m.edited_at, m.deleted_at IS NOT NULL`
+
+// CreateMessage stores a message in a conversation and returns its ID. In
+// direct conversations the other member is recorded as the recipient. With
+// a hold rule the message is stored hidden and queued for review.
+func (db *Database) CreateMessage(conversationID, senderID int, content string, hold *AutomodRule) (int, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO messages (conversation_id, sender_id, recipient_id, content, hidden, created_at)
+		SELECT c.id, ?, CASE c.kind WHEN 'direct' THEN (
+		           SELECT user_id FROM conversation_members WHERE conversation_id = c.id AND user_id != ?) END,
+		       ?, ?, ?
+		FROM conversations c WHERE c.id = ?
+	`, senderID, senderID, content, hold != nil, time.Now(), conversationID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create message: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, ErrConversationNotFound
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get message ID: %w", err)
+	}
+	if hold != nil {
+		if err := holdContent(tx, TargetMessage, int(id), hold); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit message: %w", err)
+	}
+	return int(id), nil
+}
+
+// GetMessages returns up to limit visible messages of a conversation before
+// a message ID, oldest first. A limit or beforeID of 0 means no bound.
+// Messages the viewer deleted for themselves are left out, as are, outside
+// direct conversations, messages by users they blocked or muted.
+func (db *Database) GetMessages(conversationID, viewerID, beforeID, limit int) ([]Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		JOIN users u ON u.id = m.sender_id
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.conversation_id = ? AND m.hidden = 0
+		  AND m.id NOT IN (SELECT message_id FROM message_deletions WHERE user_id = ?)
+		  AND (c.kind = 'direct' OR m.sender_id = ? OR m.sender_id NOT IN (` + ignoredUsersQuery + `))`
+	args := []interface{}{conversationID, viewerID, viewerID, viewerID}
+	if beforeID > 0 {
+		query += " AND m.id < ?"
+		args = append(args, beforeID)
+	}
+	query += " ORDER BY m.id DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	messages, err := db.scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(messages)
+	return messages, nil
+}
+
+// GetMessageByID retrieves a single message, hidden or not
+func (db *Database) GetMessageByID(messageID int) (*Message, error) {
+	messages, err := db.messagesByID([]int{messageID})
+	if err != nil {
+		return nil, err
+	}
+	m, ok := messages[messageID]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return &m, nil
+}
+
+// messagesByID loads messages by ID, hidden or not. IDs of 0 are skipped.
+func (db *Database) messagesByID(ids []int) (map[int]Message, error) {
+	var args []interface{}
+	for _, id := range ids {
+		if id != 0 {
+			args = append(args, id)
+		}
+	}
+	byID := make(map[int]Message, len(args))
+	if len(args) == 0 {
+		return byID, nil
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON u.id = m.sender_id
+		WHERE m.id IN (`+placeholders(len(args))+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	messages, err := db.scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range messages {
+		byID[m.ID] = m
+	}
+	return byID, nil
+}
+
+// scanMessages reads rows selected with messageColumns and attaches their
+// mentions, attachments and reactions
+func (db *Database) scanMessages(rows *sql.Rows) ([]Message, error) {
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var m Message
+		var editedAt sql.NullTime
+		err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.SenderUsername, &m.RecipientID, &m.Content,
+			&m.Hidden, &m.CreatedAt, &editedAt, &m.Deleted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message row: %w", err)
+		}
+		if editedAt.Valid {
+			m.EditedAt = &editedAt.Time
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during message iteration: %w", err)
+	}
+	rows.Close()
+
+	ids := make([]int, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+	mentions, err := db.mentionsOf(TargetMessage, ids)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := db.attachmentsOf(TargetMessage, ids)
+	if err != nil {
+		return nil, err
+	}
+	reactions, err := db.messageReactionsOf(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		// Deleted messages keep their reactions but nothing of their content
+		messages[i].Reactions = reactions[messages[i].ID]
+		if !messages[i].Deleted {
+			messages[i].Mentions = mentions[messages[i].ID]
+			messages[i].Attachments = attachments[messages[i].ID]
+		}
+	}
+	return messages, nil
+}
+
+// messageReactionsOf loads the reactions of several messages by ID, most
+// used first
+func (db *Database) messageReactionsOf(ids []int) (map[int][]MessageReaction, error) {
+	reactions := make(map[int][]MessageReaction)
+	if len(ids) == 0 {
+		return reactions, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT message_id, reaction, user_id FROM message_reactions
+		WHERE message_id IN (`+placeholders(len(ids))+`)
+		ORDER BY message_id, reaction, created_at
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, userID int
+		var reaction string
+		if err := rows.Scan(&messageID, &reaction, &userID); err != nil {
+			return nil, fmt.Errorf("failed to scan message reaction: %w", err)
+		}
+		list := reactions[messageID]
+		if n := len(list); n > 0 && list[n-1].Reaction == reaction {
+			list[n-1].Count++
+			list[n-1].UserIDs = append(list[n-1].UserIDs, userID)
+		} else {
+			list = append(list, MessageReaction{Reaction: reaction, Count: 1, UserIDs: []int{userID}})
+		}
+		reactions[messageID] = list
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during message reaction iteration: %w", err)
+	}
+	for _, list := range reactions {
+		slices.SortStableFunc(list, func(a, b MessageReaction) int { return b.Count - a.Count })
+	}
+	return reactions, nil
+}
+
+// EditMessage replaces the content of a message its sender wrote within the
+// last MessageEditWindow, keeping the previous version for moderators.
+// With a hold rule the edited message is hidden and queued for review.
+func (db *Database) EditMessage(userID, messageID int, content string, hold *AutomodRule) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var senderID int
+	var previous string
+	var createdAt time.Time
+	var deleted bool
+	err = tx.QueryRow("SELECT sender_id, content, created_at, deleted_at IS NOT NULL FROM messages WHERE id = ? AND hidden = FALSE",
+		messageID).Scan(&senderID, &previous, &createdAt, &deleted)
+	if err == sql.ErrNoRows {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+	switch {
+	case senderID != userID:
+		return fmt.Errorf("%w: only the sender can edit a message", ErrChatForbidden)
+	case deleted:
+		return ErrMessageDeleted
+	case time.Since(createdAt) > MessageEditWindow:
+		return ErrEditWindowExpired
+	}
+
+	now := time.Now()
+	if err := recordMessageEdit(tx, messageID, previous, now); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE messages SET content = ?, edited_at = ?, hidden = ? WHERE id = ?",
+		content, now, hold != nil, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+	if hold != nil {
+		if err := holdContent(tx, TargetMessage, messageID, hold); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteMessage deletes a message for everyone, leaving an empty placeholder
+// in the history. Senders may delete their own messages; in groups and
+// rooms, admins may also delete those of members ranking below them. The
+// content is kept for moderators, but removed from the event log.
+func (db *Database) DeleteMessage(actorID, messageID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var senderID int
+	var content, kind, actorRole, senderRole string
+	var deleted bool
+	err = tx.QueryRow(`
+		SELECT m.sender_id, m.content, m.deleted_at IS NOT NULL, c.kind,
+		       COALESCE((SELECT role FROM conversation_members WHERE conversation_id = c.id AND user_id = ?), ''),
+		       COALESCE((SELECT role FROM conversation_members WHERE conversation_id = c.id AND user_id = m.sender_id), '')
+		FROM messages m JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.id = ? AND m.hidden = FALSE
+	`, actorID, messageID).Scan(&senderID, &content, &deleted, &kind, &actorRole, &senderRole)
+	if err == sql.ErrNoRows || (err == nil && actorRole == "") {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+	if deleted {
+		return ErrMessageDeleted
+	}
+	if senderID != actorID && (kind == ConversationDirect ||
+		chatRoleRank[actorRole] < chatRoleRank[ChatRoleAdmin] || chatRoleRank[actorRole] <= chatRoleRank[senderRole]) {
+		return fmt.Errorf("%w: only the sender or an admin can delete a message", ErrChatForbidden)
+	}
+
+	now := time.Now()
+	if err := recordMessageEdit(tx, messageID, content, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE messages SET content = '', deleted_at = ? WHERE id = ?", now, messageID); err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	// Nobody should still be pointed at the message from their mentions
+	if _, err := tx.Exec("DELETE FROM mentions WHERE source_type = ? AND source_id = ?", TargetMessage, messageID); err != nil {
+		return fmt.Errorf("failed to delete message mentions: %w", err)
+	}
+	if err := redactMessageEvents(tx, messageID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func recordMessageEdit(tx *sql.Tx, messageID int, content string, replacedAt time.Time) error {
+	_, err := tx.Exec("INSERT INTO message_edits (message_id, content, replaced_at) VALUES (?, ?, ?)",
+		messageID, content, replacedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record message edit: %w", err)
+	}
+	return nil
+}
+
+// DeleteMessageForUser hides a message from one member's history only
+func (db *Database) DeleteMessageForUser(userID, messageID int) error {
+	result, err := db.DB.Exec(`
+		INSERT OR IGNORE INTO message_deletions (message_id, user_id, deleted_at)
+		SELECT m.id, cm.user_id, ?
+		FROM messages m JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = ?
+		WHERE m.id = ? AND m.hidden = FALSE
+	`, time.Now(), userID, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var exists int
+		err := db.DB.QueryRow("SELECT COUNT(*) FROM message_deletions WHERE message_id = ? AND user_id = ?",
+			messageID, userID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to check deleted message: %w", err)
+		}
+		if exists == 0 {
+			return ErrMessageNotFound
+		}
+	}
+	return nil
+}
+
+// ToggleMessageReaction adds the user's reaction to a message, or removes it
+// if it is already there. It reports whether the reaction is present
+// afterwards.
+func (db *Database) ToggleMessageReaction(userID, messageID int, reaction string) (bool, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? AND reaction = ?",
+		messageID, userID, reaction)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove message reaction: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return false, tx.Commit()
+	}
+	_, err = tx.Exec("INSERT INTO message_reactions (message_id, user_id, reaction, created_at) VALUES (?, ?, ?, ?)",
+		messageID, userID, reaction, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to add message reaction: %w", err)
+	}
+	return true, tx.Commit()
+}
+
+// GetMessageEdits returns the earlier versions of a message, oldest first
+func (db *Database) GetMessageEdits(messageID int) ([]MessageEdit, error) {
+	rows, err := db.DB.Query("SELECT content, replaced_at FROM message_edits WHERE message_id = ? ORDER BY id",
+		messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message edits: %w", err)
+	}
+	defer rows.Close()
+
+	edits := []MessageEdit{}
+	for rows.Next() {
+		var e MessageEdit
+		if err := rows.Scan(&e.Content, &e.ReplacedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message edit: %w", err)
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
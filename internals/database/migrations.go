@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// columnMigration adds a column to a table created by an older schema.sql.
+// Fresh databases already get the column from CREATE TABLE.
+type columnMigration struct {
+	table      string
+	column     string
+	definition string
+}
+
+var columnMigrations = []columnMigration{
+	{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+	{"categories", "position", "INTEGER NOT NULL DEFAULT 0"},
+	{"categories", "icon", "TEXT NOT NULL DEFAULT ''"},
+	{"categories", "color", "TEXT NOT NULL DEFAULT ''"},
+	{"categories", "archived", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"categories", "parent_id", "INTEGER REFERENCES categories(id) ON DELETE SET NULL"},
+	{"posts", "hidden", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"comments", "hidden", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"user_sanctions", "lifted_at", "DATETIME"},
+	{"user_sanctions", "lifted_by", "INTEGER REFERENCES users(id) ON DELETE SET NULL"},
+	{"notifications", "action", "TEXT NOT NULL DEFAULT ''"},
+	{"notifications", "note", "TEXT NOT NULL DEFAULT ''"},
+	{"comments", "parent_id", "INTEGER REFERENCES comments(id) ON DELETE SET NULL"},
+	{"users", "avatar", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// indexMigrations run after columnMigrations, since they may cover new columns
+var indexMigrations = []string{
+	"CREATE INDEX IF NOT EXISTS idx_categories_parent ON categories(parent_id)",
+}
+
+// defaultCategories seeds an empty categories table
+var defaultCategories = []string{
+	"Sports", "Lifestyle", "Education", "Finance", "Music",
+	"Culture", "Technology", "Health", "Travel", "Food",
+}
+
+// migrate brings an existing database up to date with schema.sql
+func migrate(db *sql.DB) error {
+	for _, m := range columnMigrations {
+		exists, err := columnExists(db, m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", m.table, m.column, err)
+		}
+	}
+
+	for _, stmt := range indexMigrations {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+
+	// Only seed categories once, so renamed or merged categories stay that way
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM categories").Scan(&count); err != nil {
+		return fmt.Errorf("failed to count categories: %w", err)
+	}
+	if count == 0 {
+		for _, name := range defaultCategories {
+			if _, err := db.Exec("INSERT INTO categories (name) VALUES (?)", name); err != nil {
+				return fmt.Errorf("failed to seed category %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    bool
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return false, fmt.Errorf("failed to scan table info: %w", err)
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
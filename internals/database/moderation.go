@@ -0,0 +1,464 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Kinds of content that can be reported and moderated
+const (
+	TargetPost    = "post"
+	TargetComment = "comment"
+	TargetMessage = "message"
+	TargetUser    = "user" // sanctions applied directly to an account
+)
+
+// targetTables maps a target type to the table holding it
+var targetTables = map[string]string{
+	TargetPost:    "posts",
+	TargetComment: "comments",
+	TargetMessage: "messages",
+}
+
+// ReportReasons lists the reasons a report may give
+var ReportReasons = []string{"spam", "harassment", "hate", "nsfw", "off_topic", "other"}
+
+// Report states
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// Moderation actions recorded in the moderation log
+const (
+	ModDismiss  = "dismiss"
+	ModHide     = "hide"
+	ModWarn     = "warn"
+	ModSuspend  = "suspend"
+	ModMute     = "mute"
+	ModLift     = "lift"
+	ModAutoHide = "auto_hide"
+)
+
+// DefaultReportThreshold is the number of open reports that hides content
+// until a moderator reviews it
+const DefaultReportThreshold = 3
+
+var (
+	ErrUnknownTarget     = errors.New("unknown report target type")
+	ErrContentNotFound   = errors.New("content not found")
+	ErrInvalidReason     = errors.New("invalid report reason")
+	ErrAlreadyReported   = errors.New("you have already reported this content")
+	ErrCannotReportOwn   = errors.New("you cannot report your own content")
+	ErrUnknownModeration = errors.New("unknown moderation action")
+)
+
+// ReportThresholdFromEnv reads FORUM_REPORT_THRESHOLD; 0 disables auto-hiding
+func ReportThresholdFromEnv() int {
+	if v := os.Getenv("FORUM_REPORT_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Printf("ignoring invalid FORUM_REPORT_THRESHOLD=%q", v)
+			return DefaultReportThreshold
+		}
+		return n
+	}
+	return DefaultReportThreshold
+}
+
+// ReportTarget describes a reported post, comment or message
+type ReportTarget struct {
+	Type       string `json:"type"`
+	ID         int    `json:"id"`
+	AuthorID   int    `json:"authorId"`
+	Author     string `json:"author"`
+	CategoryID int    `json:"categoryId,omitempty"` // 0 for messages
+	PostID     int    `json:"postId,omitempty"`     // the post a comment belongs to
+	Title      string `json:"title,omitempty"`
+	Content    string `json:"content"`
+	Hidden     bool   `json:"hidden"`
+
+	conversationID int // messages only
+}
+
+// Report is a single user report on a target
+type Report struct {
+	ID         int       `json:"id"`
+	ReporterID int       `json:"reporterId"`
+	Reporter   string    `json:"reporter"`
+	Reason     string    `json:"reason"`
+	Details    string    `json:"details"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// ReportGroup gathers the open reports on one target for the moderation queue
+type ReportGroup struct {
+	Target      ReportTarget   `json:"target"`
+	ReportCount int            `json:"reportCount"`
+	Reasons     map[string]int `json:"reasons"`
+	Reports     []Report       `json:"reports"`
+	HeldBy      string         `json:"heldBy,omitempty"` // auto-moderation rule holding the content
+}
+
+// ModerationLogEntry records one moderation decision
+type ModerationLogEntry struct {
+	ID           int       `json:"id"`
+	ActorID      int       `json:"actorId,omitempty"` // 0 for automatic actions
+	Actor        string    `json:"actor,omitempty"`
+	Action       string    `json:"action"`
+	TargetType   string    `json:"targetType"`
+	TargetID     int       `json:"targetId"`
+	TargetUserID int       `json:"targetUserId,omitempty"`
+	Note         string    `json:"note"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type rowQuerier interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}
+
+// reportTarget loads a target of any type
+func reportTarget(q rowQuerier, targetType string, targetID int) (*ReportTarget, error) {
+	t := ReportTarget{Type: targetType, ID: targetID}
+	var err error
+	switch targetType {
+	case TargetPost:
+		err = q.QueryRow(`
+			SELECT p.user_id, u.nickname, p.category_id, p.id, p.title, p.content, p.hidden
+			FROM posts p JOIN users u ON u.id = p.user_id
+			WHERE p.id = ?
+		`, targetID).Scan(&t.AuthorID, &t.Author, &t.CategoryID, &t.PostID, &t.Title, &t.Content, &t.Hidden)
+	case TargetComment:
+		err = q.QueryRow(`
+			SELECT c.user_id, u.nickname, p.category_id, p.id, p.title, c.content, c.hidden
+			FROM comments c
+			JOIN users u ON u.id = c.user_id
+			JOIN posts p ON p.id = c.post_id
+			WHERE c.id = ?
+		`, targetID).Scan(&t.AuthorID, &t.Author, &t.CategoryID, &t.PostID, &t.Title, &t.Content, &t.Hidden)
+	case TargetMessage:
+		err = q.QueryRow(`
+			SELECT m.sender_id, u.nickname, m.conversation_id, m.content, m.hidden
+			FROM messages m JOIN users u ON u.id = m.sender_id
+			WHERE m.id = ?
+		`, targetID).Scan(&t.AuthorID, &t.Author, &t.conversationID, &t.Content, &t.Hidden)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTarget, targetType)
+	}
+	if err == sql.ErrNoRows {
+		return nil, ErrContentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", targetType, err)
+	}
+	return &t, nil
+}
+
+// GetReportTarget loads a post, comment or message for moderation
+func (db *Database) GetReportTarget(targetType string, targetID int) (*ReportTarget, error) {
+	return reportTarget(db.DB, targetType, targetID)
+}
+
+// setContentHidden hides or shows a post, comment or message. Comments only
+// count towards the activity of their post while visible.
+func (db *Database) setContentHidden(tx *sql.Tx, targetType string, targetID int, hidden bool) error {
+	table, ok := targetTables[targetType]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownTarget, targetType)
+	}
+	result, err := tx.Exec("UPDATE "+table+" SET hidden = ? WHERE id = ? AND hidden != ?", hidden, targetID, hidden)
+	if err != nil {
+		return fmt.Errorf("failed to update %s visibility: %w", targetType, err)
+	}
+	if changed, _ := result.RowsAffected(); changed == 0 || targetType != TargetComment {
+		return nil
+	}
+
+	var postID int
+	var createdAt time.Time
+	if err := tx.QueryRow("SELECT post_id, created_at FROM comments WHERE id = ?", targetID).Scan(&postID, &createdAt); err != nil {
+		return fmt.Errorf("failed to load comment: %w", err)
+	}
+	delta := 1
+	if hidden {
+		delta = -1
+	}
+	return db.recordActivity(tx, postID, statComments, db.HotRank.CommentWeight, delta, createdAt)
+}
+
+func recordModeration(tx *sql.Tx, actorID int, action string, target *ReportTarget, note string) error {
+	var actor interface{}
+	if actorID != 0 {
+		actor = actorID
+	}
+	_, err := tx.Exec(`
+		INSERT INTO moderation_log (actor_id, action, target_type, target_id, target_user_id, note, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, actor, action, target.Type, target.ID, target.AuthorID, note, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record moderation action: %w", err)
+	}
+	return nil
+}
+
+// ReportContent files a report on a target. Once the target collects
+// ReportThreshold open reports it is hidden pending review, which is
+// reported back through hidden.
+func (db *Database) ReportContent(reporterID int, targetType string, targetID int, reason, details string) (hidden bool, err error) {
+	if !slices.Contains(ReportReasons, reason) {
+		return false, fmt.Errorf("%w: %s", ErrInvalidReason, reason)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	target, err := reportTarget(tx, targetType, targetID)
+	if err != nil {
+		return false, err
+	}
+	// Only the members of a conversation can see its messages
+	if targetType == TargetMessage {
+		var member int
+		err := tx.QueryRow("SELECT COUNT(*) FROM conversation_members WHERE conversation_id = ? AND user_id = ?",
+			target.conversationID, reporterID).Scan(&member)
+		if err != nil {
+			return false, fmt.Errorf("failed to check conversation member: %w", err)
+		}
+		if member == 0 {
+			return false, ErrContentNotFound
+		}
+	}
+	if target.AuthorID == reporterID {
+		return false, ErrCannotReportOwn
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO reports (reporter_id, target_type, target_id, reason, details, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, reporterID, targetType, targetID, reason, details, time.Now())
+	if isUniqueViolation(err) {
+		return false, ErrAlreadyReported
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to create report: %w", err)
+	}
+
+	if !target.Hidden && db.ReportThreshold > 0 {
+		var open int
+		err := tx.QueryRow(`
+			SELECT COUNT(*) FROM reports WHERE target_type = ? AND target_id = ? AND status = ?
+		`, targetType, targetID, ReportOpen).Scan(&open)
+		if err != nil {
+			return false, fmt.Errorf("failed to count reports: %w", err)
+		}
+		if open >= db.ReportThreshold {
+			if err := db.setContentHidden(tx, targetType, targetID, true); err != nil {
+				return false, err
+			}
+			note := fmt.Sprintf("%d open reports", open)
+			if err := recordModeration(tx, 0, ModAutoHide, target, note); err != nil {
+				return false, err
+			}
+			hidden = true
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit report: %w", err)
+	}
+	return hidden, nil
+}
+
+// GetOpenReports returns the moderation queue: open reports grouped by
+// target, most reported first
+func (db *Database) GetOpenReports() ([]ReportGroup, error) {
+	rows, err := db.DB.Query(`
+		SELECT r.id, r.target_type, r.target_id, r.reporter_id, u.nickname, r.reason, r.details, r.created_at
+		FROM reports r
+		JOIN users u ON u.id = r.reporter_id
+		WHERE r.status = ?
+		ORDER BY r.id ASC
+	`, ReportOpen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reports: %w", err)
+	}
+	defer rows.Close()
+
+	type key struct {
+		targetType string
+		targetID   int
+	}
+	var order []key
+	groups := make(map[key]*ReportGroup)
+	for rows.Next() {
+		var r Report
+		var k key
+		if err := rows.Scan(&r.ID, &k.targetType, &k.targetID, &r.ReporterID, &r.Reporter, &r.Reason, &r.Details, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan report row: %w", err)
+		}
+		g, ok := groups[k]
+		if !ok {
+			g = &ReportGroup{Reasons: make(map[string]int)}
+			groups[k] = g
+			order = append(order, k)
+		}
+		g.Reports = append(g.Reports, r)
+		g.Reasons[r.Reason]++
+		g.ReportCount++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during report iteration: %w", err)
+	}
+	rows.Close()
+
+	// Content held by auto-moderation waits in the same queue
+	holds, err := db.DB.Query(`
+		SELECT h.target_type, h.target_id, COALESCE(r.name, '')
+		FROM automod_holds h
+		LEFT JOIN automod_rules r ON r.id = h.rule_id
+		WHERE h.status = ?
+		ORDER BY h.id ASC
+	`, ReportOpen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query held content: %w", err)
+	}
+	defer holds.Close()
+	for holds.Next() {
+		var k key
+		var rule string
+		if err := holds.Scan(&k.targetType, &k.targetID, &rule); err != nil {
+			return nil, fmt.Errorf("failed to scan held content: %w", err)
+		}
+		g, ok := groups[k]
+		if !ok {
+			g = &ReportGroup{Reasons: make(map[string]int), Reports: []Report{}}
+			groups[k] = g
+			order = append(order, k)
+		}
+		g.HeldBy = rule
+	}
+	if err := holds.Err(); err != nil {
+		return nil, fmt.Errorf("error during held content iteration: %w", err)
+	}
+	holds.Close()
+
+	queue := make([]ReportGroup, 0, len(order))
+	for _, k := range order {
+		target, err := reportTarget(db.DB, k.targetType, k.targetID)
+		if errors.Is(err, ErrContentNotFound) {
+			continue // deleted since it was reported
+		}
+		if err != nil {
+			return nil, err
+		}
+		g := groups[k]
+		g.Target = *target
+		queue = append(queue, *g)
+	}
+	// Reports are scanned oldest first, so equal counts keep the oldest on top
+	sort.SliceStable(queue, func(i, j int) bool {
+		return queue[i].ReportCount > queue[j].ReportCount
+	})
+	return queue, nil
+}
+
+// ModerateTarget applies a moderator decision to a target, closes its open
+// reports and holds and records the action. duration only applies to ModSuspend and
+// ModMute; 0 makes the sanction permanent.
+func (db *Database) ModerateTarget(actorID int, targetType string, targetID int, action, note string, duration time.Duration) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	target, err := reportTarget(tx, targetType, targetID)
+	if err != nil {
+		return err
+	}
+
+	status := ReportResolved
+	switch action {
+	case ModDismiss:
+		// Reports or holds were unfounded, so undo any automatic hiding
+		status = ReportDismissed
+		if err := db.setContentHidden(tx, targetType, targetID, false); err != nil {
+			return err
+		}
+	case ModHide:
+		if err := db.setContentHidden(tx, targetType, targetID, true); err != nil {
+			return err
+		}
+	case ModWarn:
+		// The log entry is the warning
+	case ModSuspend:
+		if _, err := addSanction(tx, actorID, target.AuthorID, SanctionSuspension, note, duration); err != nil {
+			return err
+		}
+	case ModMute:
+		if _, err := addSanction(tx, actorID, target.AuthorID, SanctionMute, note, duration); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownModeration, action)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE reports SET status = ?, resolved_by = ?, resolved_at = ?
+		WHERE target_type = ? AND target_id = ? AND status = ?
+	`, status, actorID, time.Now(), targetType, targetID, ReportOpen)
+	if err != nil {
+		return fmt.Errorf("failed to close reports: %w", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE automod_holds SET status = ? WHERE target_type = ? AND target_id = ? AND status = ?
+	`, status, targetType, targetID, ReportOpen)
+	if err != nil {
+		return fmt.Errorf("failed to release held content: %w", err)
+	}
+	if err := recordModeration(tx, actorID, action, target, note); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetModerationLog returns the most recent moderation actions, newest first
+func (db *Database) GetModerationLog(limit int) ([]ModerationLogEntry, error) {
+	rows, err := db.DB.Query(`
+		SELECT l.id, COALESCE(l.actor_id, 0), COALESCE(u.nickname, ''), l.action, l.target_type,
+		       l.target_id, COALESCE(l.target_user_id, 0), l.note, l.created_at
+		FROM moderation_log l
+		LEFT JOIN users u ON u.id = l.actor_id
+		ORDER BY l.id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query moderation log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []ModerationLogEntry{}
+	for rows.Next() {
+		var e ModerationLogEntry
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Actor, &e.Action, &e.TargetType,
+			&e.TargetID, &e.TargetUserID, &e.Note, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan moderation log row: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during moderation log iteration: %w", err)
+	}
+	return entries, nil
+}
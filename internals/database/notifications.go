@@ -0,0 +1,315 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// Kinds of notifications
+const (
+	NotifyMention      = "mention"
+	NotifyPostReply    = "post_reply"    // a comment on the user's post
+	NotifyCommentReply = "comment_reply" // a reply to the user's comment
+	NotifyThreadReply  = "thread_reply"  // a comment on a post the user watches
+	NotifyReaction     = "reaction"      // a reaction to the user's post
+	NotifyModeration   = "moderation"    // a moderator acted on the user or their content
+	NotifyFollowedPost = "followed_post" // a new post by someone the user follows
+)
+
+// groupedKinds are listed as one entry per target, such as "5 people
+// commented on your post"; other kinds are listed one by one
+var groupedKinds = []string{NotifyPostReply, NotifyCommentReply, NotifyThreadReply, NotifyReaction}
+
+// notificationGroup is the SQL expression that tells notification groups apart
+var notificationGroup = `n.kind, n.target_type, n.target_id, n.read_at IS NOT NULL,
+	CASE WHEN n.kind IN ('` + strings.Join(groupedKinds, "', '") + `') THEN 0 ELSE n.id END`
+
+// maxListedActors is how many actor nicknames a grouped notification names
+const maxListedActors = 3
+
+// NotificationEvent is something a user is notified of
+type NotificationEvent struct {
+	UserID     int
+	Kind       string
+	ActorID    int // 0 when nobody should be named, as for moderator actions
+	TargetType string
+	TargetID   int
+	Action     string // the moderation action, for moderation notifications
+	Note       string
+}
+
+// Notification is a single notification or a group of notifications of the
+// same kind about the same target
+type Notification struct {
+	ID         int       `json:"id"` // the latest notification in the group
+	Kind       string    `json:"kind"`
+	TargetType string    `json:"targetType"`
+	TargetID   int       `json:"targetId"`
+	PostID     int       `json:"postId,omitempty"` // the post a target comment belongs to
+	Action     string    `json:"action,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	Actors     []string  `json:"actors"` // latest first, at most maxListedActors
+	ActorCount int       `json:"actorCount"`
+	Count      int       `json:"count"`
+	Text       string    `json:"text"`
+	Read       bool      `json:"read"`
+	CreatedAt  time.Time `json:"createdAt"` // of the latest notification
+}
+
+// Notify stores a notification and returns its ID
+func (db *Database) Notify(e NotificationEvent) (int, error) {
+	var actor interface{}
+	if e.ActorID != 0 {
+		actor = e.ActorID
+	}
+	result, err := db.DB.Exec(`
+		INSERT INTO notifications (user_id, kind, actor_id, target_type, target_id, action, note, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.UserID, e.Kind, actor, e.TargetType, e.TargetID, e.Action, e.Note, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to store notification: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get notification ID: %w", err)
+	}
+	return int(id), nil
+}
+
+// GetNotifications returns the most recent notifications of a user, grouped
+func (db *Database) GetNotifications(userID, limit int) ([]Notification, error) {
+	return db.queryNotifications(userID, "", limit)
+}
+
+// GetNotification returns the group that contains a notification
+func (db *Database) GetNotification(userID, notificationID int) (*Notification, error) {
+	row, err := db.notificationRow(userID, notificationID)
+	if err != nil {
+		return nil, err
+	}
+	where, args := row.groupFilter()
+	notifications, err := db.queryNotifications(userID, where, 1, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(notifications) == 0 {
+		return nil, ErrNotificationNotFound
+	}
+	return &notifications[0], nil
+}
+
+// CountUnreadNotifications counts the unread notification groups of a user
+func (db *Database) CountUnreadNotifications(userID int) (int, error) {
+	var count int
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*) FROM (
+			SELECT 1 FROM notifications n
+			WHERE n.user_id = ? AND n.read_at IS NULL
+			GROUP BY `+notificationGroup+`
+		)
+	`, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count notifications: %w", err)
+	}
+	return count, nil
+}
+
+// MarkNotificationRead marks the group a notification belongs to as read
+func (db *Database) MarkNotificationRead(userID, notificationID int) error {
+	row, err := db.notificationRow(userID, notificationID)
+	if err != nil {
+		return err
+	}
+	where, args := row.groupFilter()
+	_, err = db.DB.Exec(
+		"UPDATE notifications AS n SET read_at = ? WHERE n.user_id = ? AND n.read_at IS NULL"+where,
+		append([]interface{}{time.Now(), userID}, args...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark notification read: %w", err)
+	}
+	return nil
+}
+
+// MarkAllNotificationsRead marks every notification of a user as read
+func (db *Database) MarkAllNotificationsRead(userID int) error {
+	_, err := db.DB.Exec("UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL", time.Now(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to mark notifications read: %w", err)
+	}
+	return nil
+}
+
+// storedNotification is the part of a notification row that identifies its group
+type storedNotification struct {
+	id         int
+	kind       string
+	targetType string
+	targetID   int
+	read       bool
+}
+
+func (db *Database) notificationRow(userID, notificationID int) (*storedNotification, error) {
+	n := storedNotification{id: notificationID}
+	err := db.DB.QueryRow(`
+		SELECT kind, target_type, target_id, read_at IS NOT NULL
+		FROM notifications WHERE id = ? AND user_id = ?
+	`, notificationID, userID).Scan(&n.kind, &n.targetType, &n.targetID, &n.read)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotificationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification: %w", err)
+	}
+	return &n, nil
+}
+
+// groupFilter narrows a query on notifications n to the group of the row
+func (n *storedNotification) groupFilter() (string, []interface{}) {
+	for _, kind := range groupedKinds {
+		if n.kind == kind {
+			return " AND n.kind = ? AND n.target_type = ? AND n.target_id = ? AND (n.read_at IS NOT NULL) = ?",
+				[]interface{}{n.kind, n.targetType, n.targetID, n.read}
+		}
+	}
+	return " AND n.id = ?", []interface{}{n.id}
+}
+
+// queryNotifications lists notification groups, newest first. The note,
+// action and time of a group come from its latest row, as SQLite takes bare
+// columns of a MAX() aggregate from the row holding the maximum.
+func (db *Database) queryNotifications(userID int, where string, limit int, args ...interface{}) ([]Notification, error) {
+	rows, err := db.DB.Query(`
+		SELECT MAX(n.id), n.kind, n.target_type, n.target_id, n.action, n.note, n.created_at,
+		       n.read_at IS NOT NULL, COUNT(*), COUNT(DISTINCT n.actor_id),
+		       CASE n.target_type WHEN 'post' THEN n.target_id ELSE COALESCE(c.post_id, 0) END
+		FROM notifications n
+		LEFT JOIN comments c ON n.target_type = 'comment' AND c.id = n.target_id
+		WHERE n.user_id = ?`+where+`
+		GROUP BY `+notificationGroup+`
+		ORDER BY MAX(n.id) DESC
+		LIMIT ?
+	`, append(append([]interface{}{userID}, args...), limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications: %w", err)
+	}
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		err := rows.Scan(&n.ID, &n.Kind, &n.TargetType, &n.TargetID, &n.Action, &n.Note, &n.CreatedAt,
+			&n.Read, &n.Count, &n.ActorCount, &n.PostID)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan notification: %w", err)
+		}
+		notifications = append(notifications, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during notification iteration: %w", err)
+	}
+
+	for i := range notifications {
+		n := &notifications[i]
+		row := storedNotification{id: n.ID, kind: n.Kind, targetType: n.TargetType, targetID: n.TargetID, read: n.Read}
+		if n.Actors, err = db.notificationActors(userID, &row); err != nil {
+			return nil, err
+		}
+		n.Text = n.describe()
+	}
+	return notifications, nil
+}
+
+// notificationActors returns the latest distinct actors of a notification group
+func (db *Database) notificationActors(userID int, row *storedNotification) ([]string, error) {
+	where, args := row.groupFilter()
+	rows, err := db.DB.Query(`
+		SELECT u.nickname
+		FROM notifications n
+		JOIN users u ON u.id = n.actor_id
+		WHERE n.user_id = ?`+where+`
+		GROUP BY n.actor_id
+		ORDER BY MAX(n.id) DESC
+		LIMIT ?
+	`, append(append([]interface{}{userID}, args...), maxListedActors)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification actors: %w", err)
+	}
+	defer rows.Close()
+
+	actors := []string{}
+	for rows.Next() {
+		var nickname string
+		if err := rows.Scan(&nickname); err != nil {
+			return nil, fmt.Errorf("failed to scan notification actor: %w", err)
+		}
+		actors = append(actors, nickname)
+	}
+	return actors, rows.Err()
+}
+
+// describe writes the notification as a sentence, such as
+// "5 people commented on your post"
+func (n *Notification) describe() string {
+	who := "Someone"
+	switch {
+	case n.ActorCount > 2:
+		who = strconv.Itoa(n.ActorCount) + " people"
+	case n.ActorCount == 2 && len(n.Actors) == 2:
+		who = n.Actors[0] + " and " + n.Actors[1]
+	case len(n.Actors) > 0:
+		who = n.Actors[0]
+	}
+
+	var text string
+	switch n.Kind {
+	case NotifyMention:
+		text = who + " mentioned you in a " + n.TargetType
+	case NotifyPostReply:
+		text = who + " commented on your post"
+	case NotifyCommentReply:
+		text = who + " replied to your comment"
+	case NotifyThreadReply:
+		text = who + " commented on a thread you watch"
+	case NotifyReaction:
+		text = who + " reacted to your post"
+	case NotifyFollowedPost:
+		text = who + " published a new post"
+	case NotifyModeration:
+		text = describeModeration(n.Action, n.TargetType)
+	default:
+		text = who + " interacted with you"
+	}
+	if n.Note != "" {
+		text += ": " + n.Note
+	}
+	return text
+}
+
+func describeModeration(action, targetType string) string {
+	switch action {
+	case ModHide:
+		return "A moderator hid your " + targetType
+	case ModAutoHide:
+		return "Your " + targetType + " was hidden for review after being reported"
+	case ModWarn:
+		if targetType == TargetUser {
+			return "A moderator warned you"
+		}
+		return "A moderator warned you about your " + targetType
+	case ModMute:
+		return "You have been muted"
+	case ModSuspend:
+		return "Your account has been suspended"
+	case ModLift:
+		return "A sanction on your account was lifted"
+	}
+	return "A moderator acted on your " + targetType
+}
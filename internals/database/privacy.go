@@ -0,0 +1,133 @@
+package database
+
+import "fmt"
+
+// Audiences of the privacy settings, relative to the owner of the setting
+const (
+	AudienceEveryone  = "everyone"
+	AudienceFollowing = "following" // only the people the owner follows
+	AudienceNobody    = "nobody"
+)
+
+// audienceSetting validates a setting that names an audience
+func audienceSetting(key string) func(string) error {
+	return oneOfSetting(key, AudienceEveryone, AudienceFollowing, AudienceNobody)
+}
+
+// privacySettings are the settings a PrivacyView needs
+var privacySettings = []string{
+	UserSettingShowRealName,
+	UserSettingShowAge,
+	UserSettingShowGender,
+	UserSettingShowLastSeen,
+	UserSettingAppearOnline,
+	UserSettingAllowMessages,
+}
+
+// PrivacyView answers what one viewer may see of, or do to, a set of users.
+// The viewer is 0 for anonymous requests.
+type PrivacyView struct {
+	viewerID  int
+	settings  map[int]map[string]string
+	followers map[int]bool // owners who follow the viewer
+}
+
+// PrivacyView loads the privacy settings of some users, or of everyone when
+// ownerIDs is nil, as seen by a viewer
+func (db *Database) PrivacyView(viewerID int, ownerIDs []int) (*PrivacyView, error) {
+	v := &PrivacyView{
+		viewerID:  viewerID,
+		settings:  make(map[int]map[string]string),
+		followers: make(map[int]bool),
+	}
+	if ownerIDs != nil && len(ownerIDs) == 0 {
+		return v, nil
+	}
+
+	query := "SELECT user_id, key, value FROM user_settings WHERE key IN (" + placeholders(len(privacySettings)) + ")"
+	var args []interface{}
+	for _, key := range privacySettings {
+		args = append(args, key)
+	}
+	if ownerIDs != nil {
+		query += " AND user_id IN (" + placeholders(len(ownerIDs)) + ")"
+		for _, id := range ownerIDs {
+			args = append(args, id)
+		}
+	}
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query privacy settings: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int
+		var key, value string
+		if err := rows.Scan(&userID, &key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan privacy setting: %w", err)
+		}
+		if v.settings[userID] == nil {
+			v.settings[userID] = make(map[string]string)
+		}
+		v.settings[userID][key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during privacy setting iteration: %w", err)
+	}
+
+	if viewerID == 0 {
+		return v, nil
+	}
+	followers, err := db.DB.Query("SELECT follower_id FROM follows WHERE followee_id = ?", viewerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query followers: %w", err)
+	}
+	defer followers.Close()
+	for followers.Next() {
+		var id int
+		if err := followers.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan follower: %w", err)
+		}
+		v.followers[id] = true
+	}
+	return v, followers.Err()
+}
+
+// Allows tells whether the viewer passes a privacy setting of a user. Users
+// always pass their own settings.
+func (v *PrivacyView) Allows(ownerID int, key string) bool {
+	if ownerID == v.viewerID {
+		return true
+	}
+	value, ok := v.settings[ownerID][key]
+	if !ok {
+		value = userSettingDefaults[key]
+	}
+	switch value {
+	case "true", AudienceEveryone:
+		return true
+	case AudienceFollowing:
+		return v.viewerID != 0 && v.followers[ownerID]
+	}
+	return false
+}
+
+// Online tells whether the viewer may see a user as online. Users who appear
+// offline hide their last seen time too, as it would give them away.
+func (v *PrivacyView) Online(ownerID int) bool {
+	return v.Allows(ownerID, UserSettingAppearOnline)
+}
+
+// LastSeen tells whether the viewer may see when a user was last online
+func (v *PrivacyView) LastSeen(ownerID int) bool {
+	return v.Online(ownerID) && v.Allows(ownerID, UserSettingShowLastSeen)
+}
+
+// PrivacyAllows tells whether a viewer passes a privacy setting of one user
+func (db *Database) PrivacyAllows(ownerID, viewerID int, key string) (bool, error) {
+	v, err := db.PrivacyView(viewerID, []int{ownerID})
+	if err != nil {
+		return false, err
+	}
+	return v.Allows(ownerID, key), nil
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrNicknameTaken  = errors.New("nickname already taken")
+	ErrInvalidProfile = errors.New("invalid profile")
+)
+
+// maxNicknameLength caps nicknames, in characters
+const maxNicknameLength = 30
+
+// nicknamePattern keeps new nicknames usable in @mentions: letters, digits
+// and underscores, with dots and dashes inside
+var nicknamePattern = regexp.MustCompile(`^[\p{L}\p{N}_]([\p{L}\p{N}_.-]*[\p{L}\p{N}_])?$`)
+
+// Profile is everything shown on a user's profile page. The handlers decide
+// which of the personal and presence fields a viewer may see.
+type Profile struct {
+	ID             int        `json:"id"`
+	Nickname       string     `json:"nickname"`
+	FirstName      string     `json:"firstName,omitempty"`
+	LastName       string     `json:"lastName,omitempty"`
+	Age            int        `json:"age,omitempty"`
+	Gender         string     `json:"gender,omitempty"`
+	Role           string     `json:"role"`
+	JoinedAt       time.Time  `json:"joinedAt"`
+	PostCount      int        `json:"postCount"`
+	CommentCount   int        `json:"commentCount"`
+	FollowerCount  int        `json:"followerCount"`
+	FollowingCount int        `json:"followingCount"`
+	Followed       bool       `json:"followed"` // whether the viewer follows the user
+	Online         bool       `json:"online"`
+	LastSeen       *time.Time `json:"lastSeen,omitempty"`
+	Avatar         string     `json:"-"` // the key of the uploaded avatar, if any
+	AvatarURL      string     `json:"avatarUrl"`
+}
+
+// ProfileUpdate holds the profile fields to change; nil fields are left alone
+type ProfileUpdate struct {
+	Nickname  *string `json:"nickname"`
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	Age       *int    `json:"age"`
+	Gender    *string `json:"gender"`
+}
+
+// UserComment is a comment listed on its author's profile
+type UserComment struct {
+	Comment
+	PostTitle string
+}
+
+// GetProfile returns the profile of the user with a nickname, preferring an
+// exact match over a case-insensitive one. Counts only include visible content.
+func (db *Database) GetProfile(nickname string) (*Profile, error) {
+	var p Profile
+	var joinedAt, lastSeen sql.NullTime
+	err := db.DB.QueryRow(`
+		SELECT u.id, u.nickname, u.first_name, u.last_name, u.age, u.gender, u.role, u.avatar, u.created_at,
+		       (SELECT COUNT(*) FROM posts WHERE user_id = u.id AND hidden = FALSE),
+		       (SELECT COUNT(*) FROM comments c JOIN posts p ON p.id = c.post_id
+		        WHERE c.user_id = u.id AND c.hidden = FALSE AND p.hidden = FALSE),
+		       (SELECT COUNT(*) FROM follows WHERE followee_id = u.id),
+		       (SELECT COUNT(*) FROM follows WHERE follower_id = u.id),
+		       COALESCE(us.online, FALSE), us.last_seen
+		FROM users u
+		LEFT JOIN user_status us ON us.user_id = u.id
+		WHERE u.nickname = ? COLLATE NOCASE
+		ORDER BY u.nickname = ? DESC
+		LIMIT 1
+	`, nickname, nickname).Scan(&p.ID, &p.Nickname, &p.FirstName, &p.LastName, &p.Age, &p.Gender, &p.Role, &p.Avatar,
+		&joinedAt, &p.PostCount, &p.CommentCount, &p.FollowerCount, &p.FollowingCount, &p.Online, &lastSeen)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+	p.JoinedAt = joinedAt.Time
+	if lastSeen.Valid {
+		p.LastSeen = &lastSeen.Time
+	}
+	return &p, nil
+}
+
+// UpdateProfile changes the profile fields set in update
+func (db *Database) UpdateProfile(userID int, update ProfileUpdate) error {
+	var sets []string
+	var args []interface{}
+
+	if update.Nickname != nil {
+		nickname := strings.TrimSpace(*update.Nickname)
+		if utf8.RuneCountInString(nickname) > maxNicknameLength || !nicknamePattern.MatchString(nickname) {
+			return fmt.Errorf("%w: nicknames are up to %d letters, digits, underscores, dots and dashes", ErrInvalidProfile, maxNicknameLength)
+		}
+		var taken int
+		err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE nickname = ? COLLATE NOCASE AND id != ?", nickname, userID).Scan(&taken)
+		if err != nil {
+			return fmt.Errorf("failed to check nickname: %w", err)
+		}
+		if taken > 0 {
+			return ErrNicknameTaken
+		}
+		sets = append(sets, "nickname = ?")
+		args = append(args, nickname)
+	}
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{
+		{"first_name", update.FirstName},
+		{"last_name", update.LastName},
+		{"gender", update.Gender},
+	} {
+		if field.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*field.value)
+		if value == "" {
+			return fmt.Errorf("%w: %s cannot be empty", ErrInvalidProfile, field.column)
+		}
+		sets = append(sets, field.column+" = ?")
+		args = append(args, value)
+	}
+	if update.Age != nil {
+		if *update.Age < 1 || *update.Age > 150 {
+			return fmt.Errorf("%w: age must be between 1 and 150", ErrInvalidProfile)
+		}
+		sets = append(sets, "age = ?")
+		args = append(args, *update.Age)
+	}
+
+	if len(sets) == 0 {
+		return nil
+	}
+	args = append(args, userID)
+	result, err := db.DB.Exec("UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...)
+	if err != nil {
+		return fmt.Errorf("failed to update profile: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// GetAvatar returns the ID and avatar key of a user by nickname, matched like
+// GetProfile. The key is empty when the user has not uploaded an avatar.
+func (db *Database) GetAvatar(nickname string) (int, string, error) {
+	var userID int
+	var key string
+	err := db.DB.QueryRow(`
+		SELECT id, avatar FROM users
+		WHERE nickname = ? COLLATE NOCASE
+		ORDER BY nickname = ? DESC
+		LIMIT 1
+	`, nickname, nickname).Scan(&userID, &key)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrUserNotFound
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to get avatar: %w", err)
+	}
+	return userID, key, nil
+}
+
+// SetAvatar changes the avatar key of a user, "" for none, and returns the
+// key it replaced
+func (db *Database) SetAvatar(userID int, key string) (string, error) {
+	var previous string
+	err := db.DB.QueryRow("SELECT avatar FROM users WHERE id = ?", userID).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get avatar: %w", err)
+	}
+	if _, err := db.DB.Exec("UPDATE users SET avatar = ? WHERE id = ?", key, userID); err != nil {
+		return "", fmt.Errorf("failed to set avatar: %w", err)
+	}
+	return previous, nil
+}
+
+// AvatarInUse tells whether any user still has an avatar key
+func (db *Database) AvatarInUse(key string) (bool, error) {
+	var count int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE avatar = ?", key).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check avatar: %w", err)
+	}
+	return count > 0, nil
+}
+
+// ListUserComments returns a page of a user's visible comments on visible
+// posts, newest first
+func (db *Database) ListUserComments(userID, limit, offset int) ([]UserComment, error) {
+	rows, err := db.DB.Query(`
+		SELECT c.id, c.post_id, COALESCE(c.parent_id, 0), c.user_id, c.content, u.nickname, c.created_at, p.title
+		FROM comments c
+		JOIN users u ON u.id = c.user_id
+		JOIN posts p ON p.id = c.post_id
+		WHERE c.user_id = ? AND c.hidden = FALSE AND p.hidden = FALSE
+		ORDER BY c.id DESC
+		LIMIT ? OFFSET ?
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user comments: %w", err)
+	}
+	defer rows.Close()
+
+	var comments []UserComment
+	var ids []int
+	for rows.Next() {
+		var c UserComment
+		err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.UserID, &c.Content, &c.Author, &c.CreatedAt, &c.PostTitle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user comment: %w", err)
+		}
+		comments = append(comments, c)
+		ids = append(ids, c.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during comment iteration: %w", err)
+	}
+
+	mentions, err := db.mentionsOf(TargetComment, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range comments {
+		comments[i].Mentions = mentions[comments[i].ID]
+	}
+	attachments, err := db.attachmentsOf(TargetComment, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range comments {
+		comments[i].Attachments = attachments[comments[i].ID]
+	}
+	return comments, nil
+}
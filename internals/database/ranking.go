@@ -0,0 +1,318 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// HotRankConfig controls how activity on a post contributes to its hot score.
+// Every event adds its weight to the score, and that contribution halves
+// every HalfLife, so recent activity outranks old activity.
+type HotRankConfig struct {
+	PostWeight     float64
+	CommentWeight  float64
+	ReactionWeight float64
+	ViewWeight     float64
+	HalfLife       time.Duration
+}
+
+// DefaultHotRankConfig is used unless overridden with HotRankConfigFromEnv
+var DefaultHotRankConfig = HotRankConfig{
+	PostWeight:     1,
+	CommentWeight:  3,
+	ReactionWeight: 2,
+	ViewWeight:     0.1,
+	HalfLife:       12 * time.Hour,
+}
+
+// hotEpoch is the fixed reference point for hot scores. Scores are stored as
+// log2 of the decayed activity relative to this instant, which keeps ordering
+// stable over time without recomputing every row.
+var hotEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// HotRankConfigFromEnv reads FORUM_HOT_* variables, falling back to the defaults
+func HotRankConfigFromEnv() HotRankConfig {
+	cfg := DefaultHotRankConfig
+	readFloat := func(name string, dst *float64) {
+		if v := os.Getenv(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				log.Printf("ignoring invalid %s=%q", name, v)
+				return
+			}
+			*dst = f
+		}
+	}
+	readFloat("FORUM_HOT_POST_WEIGHT", &cfg.PostWeight)
+	readFloat("FORUM_HOT_COMMENT_WEIGHT", &cfg.CommentWeight)
+	readFloat("FORUM_HOT_REACTION_WEIGHT", &cfg.ReactionWeight)
+	readFloat("FORUM_HOT_VIEW_WEIGHT", &cfg.ViewWeight)
+	if v := os.Getenv("FORUM_HOT_HALF_LIFE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("ignoring invalid FORUM_HOT_HALF_LIFE=%q", v)
+		} else {
+			cfg.HalfLife = d
+		}
+	}
+	return cfg
+}
+
+// hotContribution returns the log2 score of a single event of the given weight
+func (c HotRankConfig) hotContribution(weight float64, at time.Time) float64 {
+	if weight <= 0 {
+		return math.Inf(-1)
+	}
+	return math.Log2(weight) + at.Sub(hotEpoch).Hours()/c.HalfLife.Hours()
+}
+
+// logAdd adds two scores stored in log2 space
+func logAdd(a, b float64) float64 {
+	if math.IsInf(a, -1) {
+		return b
+	}
+	if math.IsInf(b, -1) {
+		return a
+	}
+	hi, lo := math.Max(a, b), math.Min(a, b)
+	return hi + math.Log2(1+math.Exp2(lo-hi))
+}
+
+// logSub removes b from a in log2 space, bottoming out at no activity
+func logSub(a, b float64) float64 {
+	if math.IsInf(b, -1) {
+		return a
+	}
+	if b >= a {
+		return math.Inf(-1)
+	}
+	return a + math.Log2(1-math.Exp2(b-a))
+}
+
+// storedScore maps "no activity" to a finite value SQLite can store and sort
+func storedScore(s float64) float64 {
+	if math.IsInf(s, -1) {
+		return -math.MaxFloat64
+	}
+	return s
+}
+
+func loadedScore(s float64) float64 {
+	if s == -math.MaxFloat64 {
+		return math.Inf(-1)
+	}
+	return s
+}
+
+// SetHotRankConfig replaces the ranking weights and rebuilds existing scores
+func (d *Database) SetHotRankConfig(cfg HotRankConfig) error {
+	if cfg.HalfLife <= 0 {
+		return fmt.Errorf("hot rank half-life must be positive")
+	}
+	d.HotRank = cfg
+	return d.RecomputeHotScores()
+}
+
+// post activity counters kept in post_stats
+const (
+	statComments  = "comment_count"
+	statReactions = "reaction_count"
+	statViews     = "view_count"
+)
+
+// recordActivity bumps a post_stats counter by delta and folds the event into
+// the hot score. A negative delta removes a previous event of the same kind,
+// and at must then be the time that event happened: contributions grow with
+// time, so removing one at a later time would take away more than it added.
+func (d *Database) recordActivity(tx *sql.Tx, postID int, counter string, weight float64, delta int, at time.Time) error {
+	var score float64
+	err := tx.QueryRow("SELECT hot_score FROM post_stats WHERE post_id = ?", postID).Scan(&score)
+	if err == sql.ErrNoRows {
+		score = -math.MaxFloat64
+		if _, err := tx.Exec("INSERT INTO post_stats (post_id, hot_score, last_activity_at) VALUES (?, ?, ?)",
+			postID, score, at); err != nil {
+			return fmt.Errorf("failed to create post stats: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to read post stats: %w", err)
+	}
+
+	current := loadedScore(score)
+	event := d.HotRank.hotContribution(weight, at)
+	if delta < 0 {
+		current = logSub(current, event)
+	} else {
+		current = logAdd(current, event)
+	}
+
+	// Removing an old event is not new activity, and neither is adding one
+	// from before the latest, such as a held comment being approved
+	query := fmt.Sprintf(`
+		UPDATE post_stats
+		SET %[1]s = MAX(%[1]s + ?, 0), hot_score = ?,
+			last_activity_at = CASE WHEN ? AND ? > last_activity_at THEN ? ELSE last_activity_at END
+		WHERE post_id = ?`, counter)
+	if _, err := tx.Exec(query, delta, storedScore(current), delta > 0, at, at, postID); err != nil {
+		return fmt.Errorf("failed to update post stats: %w", err)
+	}
+	return nil
+}
+
+// PostViewWindow is how long repeated views of a post by the same viewer
+// count as one
+const PostViewWindow = time.Hour
+
+// RecordPostView counts a view of a post towards its hot score. viewer
+// identifies who is viewing, such as a user ID or an IP address, and their
+// further views within PostViewWindow are ignored.
+func (d *Database) RecordPostView(postID int, viewer string) error {
+	now := d.now()
+	since := now.Add(-PostViewWindow)
+
+	// Most repeated views stop here, without taking the write lock
+	var counted bool
+	err := d.DB.QueryRow("SELECT viewed_at > ? FROM post_views WHERE post_id = ? AND viewer = ?",
+		since, postID, viewer).Scan(&counted)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check post view: %w", err)
+	}
+	if counted {
+		return nil
+	}
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The condition settles concurrent first views by the same viewer
+	result, err := tx.Exec(`
+		INSERT INTO post_views (post_id, viewer, viewed_at) VALUES (?, ?, ?)
+		ON CONFLICT(post_id, viewer) DO UPDATE SET viewed_at = excluded.viewed_at
+		WHERE post_views.viewed_at <= ?
+	`, postID, viewer, now, since)
+	if err != nil {
+		return fmt.Errorf("failed to record post view: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to check post view: %w", err)
+	} else if n == 0 {
+		return nil
+	}
+	if _, err := tx.Exec("DELETE FROM post_views WHERE post_id = ? AND viewed_at <= ?", postID, since); err != nil {
+		return fmt.Errorf("failed to prune post views: %w", err)
+	}
+
+	if err := d.recordActivity(tx, postID, statViews, d.HotRank.ViewWeight, 1, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecomputeHotScores rebuilds post_stats from the stored posts, visible
+// comments and reactions. Views are only kept as a count, so they are credited at the time
+// of the post's last recorded activity.
+func (d *Database) RecomputeHotScores() error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	type postActivity struct {
+		id           int
+		createdAt    time.Time
+		views        int
+		lastActivity sql.NullTime
+		score        float64
+		comments     int
+		reactions    int
+	}
+
+	rows, err := tx.Query(`
+		SELECT p.id, p.created_at, COALESCE(s.view_count, 0), s.last_activity_at
+		FROM posts p
+		LEFT JOIN post_stats s ON s.post_id = p.id
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query posts for ranking: %w", err)
+	}
+	activity := make(map[int]*postActivity)
+	for rows.Next() {
+		a := &postActivity{}
+		if err := rows.Scan(&a.id, &a.createdAt, &a.views, &a.lastActivity); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan post for ranking: %w", err)
+		}
+		a.score = d.HotRank.hotContribution(d.HotRank.PostWeight, a.createdAt)
+		activity[a.id] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error during ranking iteration: %w", err)
+	}
+
+	addEvents := func(query string, weight float64, count func(*postActivity)) error {
+		rows, err := tx.Query(query)
+		if err != nil {
+			return fmt.Errorf("failed to query activity for ranking: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var postID int
+			var at time.Time
+			if err := rows.Scan(&postID, &at); err != nil {
+				return fmt.Errorf("failed to scan activity for ranking: %w", err)
+			}
+			a, ok := activity[postID]
+			if !ok {
+				continue
+			}
+			a.score = logAdd(a.score, d.HotRank.hotContribution(weight, at))
+			count(a)
+			if !a.lastActivity.Valid || at.After(a.lastActivity.Time) {
+				a.lastActivity = sql.NullTime{Time: at, Valid: true}
+			}
+		}
+		return rows.Err()
+	}
+	if err := addEvents("SELECT post_id, created_at FROM comments WHERE hidden = FALSE", d.HotRank.CommentWeight,
+		func(a *postActivity) { a.comments++ }); err != nil {
+		return err
+	}
+	if err := addEvents("SELECT post_id, created_at FROM post_reactions", d.HotRank.ReactionWeight,
+		func(a *postActivity) { a.reactions++ }); err != nil {
+		return err
+	}
+
+	for _, a := range activity {
+		last := a.createdAt
+		if a.lastActivity.Valid {
+			last = a.lastActivity.Time
+		}
+		if a.views > 0 {
+			a.score = logAdd(a.score, d.HotRank.hotContribution(d.HotRank.ViewWeight*float64(a.views), last))
+		}
+		_, err := tx.Exec(`
+			INSERT INTO post_stats (post_id, comment_count, reaction_count, view_count, hot_score, last_activity_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(post_id) DO UPDATE SET
+				comment_count = excluded.comment_count,
+				reaction_count = excluded.reaction_count,
+				view_count = excluded.view_count,
+				hot_score = excluded.hot_score,
+				last_activity_at = excluded.last_activity_at
+		`, a.id, a.comments, a.reactions, a.views, storedScore(a.score), last)
+		if err != nil {
+			return fmt.Errorf("failed to store post stats: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
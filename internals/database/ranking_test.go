@@ -0,0 +1,164 @@
+package database
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestDB opens a fresh database whose clock the test controls
+func newTestDB(t *testing.T) (*Database, *time.Time) {
+	t.Helper()
+	db, err := New(filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	db.clock = func() time.Time { return now }
+	return db, &now
+}
+
+// newTestPost creates a user, a category and a post by that user
+func newTestPost(t *testing.T, db *Database) (userID, postID int) {
+	t.Helper()
+	if err := db.RegisterUser("alice", "alice@example.com", "secret", "A", "Alice", "other", 30); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+	if err := db.DB.QueryRow("SELECT id FROM users WHERE nickname = 'alice'").Scan(&userID); err != nil {
+		t.Fatalf("loading user: %v", err)
+	}
+	categoryID, err := db.CreateCategory("General", "", "", "", 0)
+	if err != nil {
+		t.Fatalf("CreateCategory: %v", err)
+	}
+	postID, err = db.CreatePost(userID, categoryID, "Hello", "First post", nil, nil)
+	if err != nil {
+		t.Fatalf("CreatePost: %v", err)
+	}
+	return userID, postID
+}
+
+func hotScore(t *testing.T, db *Database, postID int) float64 {
+	t.Helper()
+	var score float64
+	if err := db.DB.QueryRow("SELECT hot_score FROM post_stats WHERE post_id = ?", postID).Scan(&score); err != nil {
+		t.Fatalf("loading hot score: %v", err)
+	}
+	return loadedScore(score)
+}
+
+func TestToggleReactionRemovesOriginalContribution(t *testing.T) {
+	db, now := newTestDB(t)
+	userID, postID := newTestPost(t, db)
+	before := hotScore(t, db, postID)
+
+	if reacted, err := db.ToggleReaction(userID, postID, "like"); err != nil || !reacted {
+		t.Fatalf("ToggleReaction = %v, %v; want reacted", reacted, err)
+	}
+	// Later contributions are worth more, so removing the reaction at this
+	// time would take away more than it added
+	*now = now.Add(3 * db.HotRank.HalfLife)
+	if reacted, err := db.ToggleReaction(userID, postID, "like"); err != nil || reacted {
+		t.Fatalf("ToggleReaction = %v, %v; want removed", reacted, err)
+	}
+
+	after := hotScore(t, db, postID)
+	if math.IsInf(after, -1) {
+		t.Fatal("removing the reaction wiped the hot score")
+	}
+	if math.Abs(after-before) > 1e-9 {
+		t.Errorf("hot score = %v after reacting and un-reacting, want %v", after, before)
+	}
+}
+
+func TestRecordPostViewCountsOncePerWindow(t *testing.T) {
+	db, now := newTestDB(t)
+	_, postID := newTestPost(t, db)
+
+	views := func() int {
+		var n int
+		if err := db.DB.QueryRow("SELECT view_count FROM post_stats WHERE post_id = ?", postID).Scan(&n); err != nil {
+			t.Fatalf("loading view count: %v", err)
+		}
+		return n
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := db.RecordPostView(postID, "user:1"); err != nil {
+			t.Fatalf("RecordPostView: %v", err)
+		}
+	}
+	if err := db.RecordPostView(postID, "ip:192.0.2.1"); err != nil {
+		t.Fatalf("RecordPostView: %v", err)
+	}
+	if got := views(); got != 2 {
+		t.Errorf("view count = %d after repeated views, want 2", got)
+	}
+
+	*now = now.Add(PostViewWindow + time.Minute)
+	if err := db.RecordPostView(postID, "user:1"); err != nil {
+		t.Fatalf("RecordPostView: %v", err)
+	}
+	if got := views(); got != 3 {
+		t.Errorf("view count = %d after the window, want 3", got)
+	}
+}
+
+func TestHeldCommentsCountOnceApproved(t *testing.T) {
+	db, now := newTestDB(t)
+	userID, postID := newTestPost(t, db)
+	ruleID, err := db.CreateAutomodRule(AutomodRule{Name: "hold", Kind: AutomodWord, Pattern: "spam", Action: AutomodHold, Enabled: true})
+	if err != nil {
+		t.Fatalf("CreateAutomodRule: %v", err)
+	}
+	before := hotScore(t, db, postID)
+	comments := func() int {
+		var n int
+		if err := db.DB.QueryRow("SELECT comment_count FROM post_stats WHERE post_id = ?", postID).Scan(&n); err != nil {
+			t.Fatalf("loading comment count: %v", err)
+		}
+		return n
+	}
+
+	commentID, err := db.CreateComment(userID, postID, 0, "spam", &AutomodRule{ID: ruleID, Name: "hold"})
+	if err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	if got := comments(); got != 0 {
+		t.Errorf("comment count = %d with the comment held, want 0", got)
+	}
+	if after := hotScore(t, db, postID); after != before {
+		t.Errorf("hot score = %v with the comment held, want %v", after, before)
+	}
+
+	*now = now.Add(time.Hour)
+	if err := db.ModerateTarget(userID, TargetComment, commentID, ModDismiss, "", 0); err != nil {
+		t.Fatalf("approving the comment: %v", err)
+	}
+	if got := comments(); got != 1 {
+		t.Errorf("comment count = %d once approved, want 1", got)
+	}
+	approved := hotScore(t, db, postID)
+	if approved <= before {
+		t.Errorf("hot score = %v once approved, want more than %v", approved, before)
+	}
+	if err := db.RecomputeHotScores(); err != nil {
+		t.Fatalf("RecomputeHotScores: %v", err)
+	}
+	if got := hotScore(t, db, postID); math.Abs(got-approved) > 1e-9 {
+		t.Errorf("recomputed hot score = %v, want %v", got, approved)
+	}
+
+	if err := db.ModerateTarget(userID, TargetComment, commentID, ModHide, "", 0); err != nil {
+		t.Fatalf("hiding the comment: %v", err)
+	}
+	if got := comments(); got != 0 {
+		t.Errorf("comment count = %d once hidden, want 0", got)
+	}
+	if got := hotScore(t, db, postID); math.Abs(got-before) > 1e-9 {
+		t.Errorf("hot score = %v once hidden, want %v", got, before)
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ReactionCount is the number of reactions of one kind on a post
+type ReactionCount struct {
+	Reaction string `json:"reaction"`
+	Count    int    `json:"count"`
+}
+
+// ToggleReaction adds the user's reaction to a post, or removes it if it is
+// already there. It reports whether the reaction is present afterwards.
+func (d *Database) ToggleReaction(userID, postID int, reaction string) (bool, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The reaction's contribution is taken back as it was when added
+	at := d.now()
+	delta := 1
+	err = tx.QueryRow("SELECT created_at FROM post_reactions WHERE post_id = ? AND user_id = ? AND reaction = ?",
+		postID, userID, reaction).Scan(&at)
+	switch {
+	case err == nil:
+		delta = -1
+		if _, err := tx.Exec(
+			"DELETE FROM post_reactions WHERE post_id = ? AND user_id = ? AND reaction = ?",
+			postID, userID, reaction,
+		); err != nil {
+			return false, fmt.Errorf("failed to remove reaction: %w", err)
+		}
+	case err == sql.ErrNoRows:
+		if _, err := tx.Exec(
+			"INSERT INTO post_reactions (post_id, user_id, reaction, created_at) VALUES (?, ?, ?, ?)",
+			postID, userID, reaction, at,
+		); err != nil {
+			return false, fmt.Errorf("failed to add reaction: %w", err)
+		}
+	default:
+		return false, fmt.Errorf("failed to check reaction: %w", err)
+	}
+
+	if err := d.recordActivity(tx, postID, statReactions, d.HotRank.ReactionWeight, delta, at); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit reaction: %w", err)
+	}
+	return delta > 0, nil
+}
+
+// GetReactionCounts returns the reaction totals for a post
+func (d *Database) GetReactionCounts(postID int) ([]ReactionCount, error) {
+	rows, err := d.DB.Query(`
+		SELECT reaction, COUNT(*)
+		FROM post_reactions
+		WHERE post_id = ?
+		GROUP BY reaction
+		ORDER BY COUNT(*) DESC, reaction ASC
+	`, postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reactions: %w", err)
+	}
+	defer rows.Close()
+
+	counts := []ReactionCount{}
+	for rows.Next() {
+		var rc ReactionCount
+		if err := rows.Scan(&rc.Reaction, &rc.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan reaction row: %w", err)
+		}
+		counts = append(counts, rc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during reaction iteration: %w", err)
+	}
+	return counts, nil
+}
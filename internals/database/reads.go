@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// A user has read a comment when its ID is at most the highest of three
+// markers: the last comment seen in its post, and the watermarks left by
+// marking its category or the whole forum as read. Comment IDs only grow, so
+// a watermark is the highest comment ID at the time of marking and covers
+// any number of posts with a single row.
+
+// readMarkJoins joins the read markers of a user, bound three times, to posts p
+const readMarkJoins = `
+		LEFT JOIN post_reads pr ON pr.user_id = ? AND pr.post_id = p.id
+		LEFT JOIN read_marks rc ON rc.user_id = ? AND rc.category_id = p.category_id
+		LEFT JOIN read_marks rf ON rf.user_id = ? AND rf.category_id = 0`
+
+// lastReadComment is the ID of the last comment of post p the user has read
+const lastReadComment = `MAX(COALESCE(pr.last_comment_id, 0), COALESCE(rc.last_comment_id, 0), COALESCE(rf.last_comment_id, 0))`
+
+// MarkPostRead records that a user has seen the comments of a post up to
+// lastCommentID; the marker never moves backwards
+func (db *Database) MarkPostRead(userID, postID, lastCommentID int) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO post_reads (user_id, post_id, last_comment_id) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, post_id) DO UPDATE SET last_comment_id = MAX(last_comment_id, excluded.last_comment_id)
+	`, userID, postID, lastCommentID)
+	if err != nil {
+		return fmt.Errorf("failed to mark post read: %w", err)
+	}
+	return nil
+}
+
+// MarkCategoryRead marks every comment so far in a category and its
+// subcategories as read, with one watermark per category
+func (db *Database) MarkCategoryRead(userID, categoryID int) error {
+	if _, err := db.GetCategoryByID(categoryID); err != nil {
+		return err
+	}
+	watermark, err := db.commentWatermark()
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(`
+		INSERT INTO read_marks (user_id, category_id, last_comment_id)
+		SELECT ?, id, ? FROM categories WHERE id IN (`+subtreeQuery+`)
+		ON CONFLICT(user_id, category_id) DO UPDATE SET last_comment_id = MAX(last_comment_id, excluded.last_comment_id)
+	`, userID, watermark, categoryID)
+	if err != nil {
+		return fmt.Errorf("failed to mark category read: %w", err)
+	}
+	return nil
+}
+
+// MarkAllRead marks every comment so far in the forum as read
+func (db *Database) MarkAllRead(userID int) error {
+	watermark, err := db.commentWatermark()
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(`
+		INSERT INTO read_marks (user_id, category_id, last_comment_id) VALUES (?, 0, ?)
+		ON CONFLICT(user_id, category_id) DO UPDATE SET last_comment_id = MAX(last_comment_id, excluded.last_comment_id)
+	`, userID, watermark)
+	if err != nil {
+		return fmt.Errorf("failed to mark forum read: %w", err)
+	}
+	return nil
+}
+
+// LastReadComment returns the ID of the last comment of a post a user has read
+func (db *Database) LastReadComment(userID, postID int) (int, error) {
+	var lastRead int
+	err := db.DB.QueryRow(`
+		SELECT `+lastReadComment+`
+		FROM posts p`+readMarkJoins+`
+		WHERE p.id = ?
+	`, userID, userID, userID, postID).Scan(&lastRead)
+	if err == sql.ErrNoRows {
+		return 0, ErrPostNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get read marker: %w", err)
+	}
+	return lastRead, nil
+}
+
+// commentWatermark returns the highest comment ID so far
+func (db *Database) commentWatermark() (int, error) {
+	var watermark int
+	if err := db.DB.QueryRow("SELECT COALESCE(MAX(id), 0) FROM comments").Scan(&watermark); err != nil {
+		return 0, fmt.Errorf("failed to get comment watermark: %w", err)
+	}
+	return watermark, nil
+}
+
+// attachUnread fills in the unread comments of each post for a user,
+// ignoring the user's own comments
+func (db *Database) attachUnread(userID int, posts []Post) error {
+	type unread struct{ count, first int }
+	byPost := make(map[int]unread)
+	err := inChunks(postIDs(posts), func(ids []int) error {
+		args := []interface{}{userID, userID, userID}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		args = append(args, userID)
+
+		rows, err := db.DB.Query(`
+			SELECT c.post_id, COUNT(*), MIN(c.id)
+			FROM comments c
+			JOIN posts p ON p.id = c.post_id`+readMarkJoins+`
+			WHERE c.post_id IN (`+placeholders(len(ids))+`) AND c.hidden = FALSE AND c.user_id != ?
+			  AND c.id > `+lastReadComment+`
+			GROUP BY c.post_id
+		`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query unread comments: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var postID int
+			var u unread
+			if err := rows.Scan(&postID, &u.count, &u.first); err != nil {
+				return fmt.Errorf("failed to scan unread comments: %w", err)
+			}
+			byPost[postID] = u
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error during unread iteration: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range posts {
+		u := byPost[posts[i].ID]
+		posts[i].UnreadComments, posts[i].FirstUnreadCommentID = u.count, u.first
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Global user roles stored in users.role
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions checked by the handlers
+const (
+	PermPostDeleteAny    = "post.delete.any"
+	PermCategoryManage   = "category.manage"
+	PermCategoryModerate = "category.moderate" // post where posting is limited to moderators
+	PermReportReview     = "report.review"
+	PermUserBan          = "user.ban"
+	PermRoleManage       = "role.manage"
+	PermAutomodManage    = "automod.manage"
+)
+
+// globalPermissions lists what each global role may do anywhere
+var globalPermissions = map[string][]string{
+	RoleUser: {},
+	RoleModerator: {
+		PermPostDeleteAny,
+		PermCategoryModerate,
+		PermReportReview,
+		PermUserBan,
+	},
+	RoleAdmin: {
+		PermPostDeleteAny,
+		PermCategoryManage,
+		PermCategoryModerate,
+		PermReportReview,
+		PermUserBan,
+		PermRoleManage,
+		PermAutomodManage,
+	},
+}
+
+// categoryModeratorPermissions applies within the categories (and their
+// subcategories) a user moderates
+var categoryModeratorPermissions = []string{
+	PermPostDeleteAny,
+	PermCategoryModerate,
+	PermReportReview,
+}
+
+// roleRank orders global roles; sanctions only apply to users of lower rank
+var roleRank = map[string]int{RoleUser: 0, RoleModerator: 1, RoleAdmin: 2}
+
+var (
+	ErrUnknownRole  = errors.New("unknown role")
+	ErrUserNotFound = errors.New("user not found")
+	ErrNotModerator = errors.New("user does not moderate this category")
+	ErrOutranked    = errors.New("cannot act on a user of equal or higher role")
+)
+
+// RoleAuditEntry records a change to someone's role
+type RoleAuditEntry struct {
+	ID           int       `json:"id"`
+	ActorID      int       `json:"actorId"`
+	Actor        string    `json:"actor"`
+	TargetUserID int       `json:"targetUserId"`
+	Target       string    `json:"target"`
+	Action       string    `json:"action"`
+	Role         string    `json:"role"`
+	CategoryID   int       `json:"categoryId,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Actions recorded in the role audit trail
+const (
+	AuditSetRole                 = "set_role"
+	AuditGrantCategoryModerator  = "grant_category_moderator"
+	AuditRevokeCategoryModerator = "revoke_category_moderator"
+)
+
+// GetUserRole returns the global role of a user
+func (db *Database) GetUserRole(userID int) (string, error) {
+	var role string
+	err := db.DB.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get user role: %w", err)
+	}
+	return role, nil
+}
+
+// HasPermission reports whether a user holds a permission. A categoryID of 0
+// checks global permissions only; otherwise moderator assignments on the
+// category or any of its ancestors also count.
+func (db *Database) HasPermission(userID int, permission string, categoryID int) (bool, error) {
+	role, err := db.GetUserRole(userID)
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(globalPermissions[role], permission) {
+		return true, nil
+	}
+
+	if categoryID == 0 || !slices.Contains(categoryModeratorPermissions, permission) {
+		return false, nil
+	}
+
+	path, err := categoryAncestors(db.DB, categoryID)
+	if err != nil {
+		return false, err
+	}
+	args := append([]interface{}{userID}, categoryIDs(path)...)
+	var count int
+	err = db.DB.QueryRow(`
+		SELECT COUNT(*) FROM category_moderators
+		WHERE user_id = ? AND category_id IN (`+placeholders(len(path))+`)
+	`, args...).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check category moderator: %w", err)
+	}
+	return count > 0, nil
+}
+
+// checkOutranks returns ErrOutranked unless the actor's global role is above
+// the user's, and ErrUserNotFound if the user does not exist
+func checkOutranks(q rowQuerier, actorID, userID int) error {
+	roles := make(map[int]string, 2)
+	for _, id := range []int{actorID, userID} {
+		var role string
+		err := q.QueryRow("SELECT role FROM users WHERE id = ?", id).Scan(&role)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get user role: %w", err)
+		}
+		roles[id] = role
+	}
+	if roleRank[roles[actorID]] <= roleRank[roles[userID]] {
+		return ErrOutranked
+	}
+	return nil
+}
+
+// SetUserRole changes a user's global role and records who did it
+func (db *Database) SetUserRole(actorID, userID int, role string) error {
+	if _, ok := globalPermissions[role]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownRole, role)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set user role: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	if err := recordRoleAudit(tx, actorID, userID, AuditSetRole, role, 0); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GrantCategoryModerator makes a user a moderator of a category and its subcategories
+func (db *Database) GrantCategoryModerator(actorID, userID, categoryID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", userID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check user: %w", err)
+	}
+	if exists == 0 {
+		return ErrUserNotFound
+	}
+	if err := tx.QueryRow("SELECT COUNT(*) FROM categories WHERE id = ?", categoryID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check category: %w", err)
+	}
+	if exists == 0 {
+		return ErrCategoryNotFound
+	}
+
+	if _, err := tx.Exec(`
+		INSERT OR IGNORE INTO category_moderators (user_id, category_id, granted_by, created_at)
+		VALUES (?, ?, ?, ?)
+	`, userID, categoryID, actorID, time.Now()); err != nil {
+		return fmt.Errorf("failed to grant category moderator: %w", err)
+	}
+	if err := recordRoleAudit(tx, actorID, userID, AuditGrantCategoryModerator, RoleModerator, categoryID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeCategoryModerator removes a user's moderator assignment on a category
+func (db *Database) RevokeCategoryModerator(actorID, userID, categoryID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM category_moderators WHERE user_id = ? AND category_id = ?", userID, categoryID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke category moderator: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotModerator
+	}
+	if err := recordRoleAudit(tx, actorID, userID, AuditRevokeCategoryModerator, RoleModerator, categoryID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func recordRoleAudit(tx *sql.Tx, actorID, userID int, action, role string, categoryID int) error {
+	var category interface{}
+	if categoryID != 0 {
+		category = categoryID
+	}
+	_, err := tx.Exec(`
+		INSERT INTO role_audit (actor_id, target_user_id, action, role, category_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, actorID, userID, action, role, category, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record role audit: %w", err)
+	}
+	return nil
+}
+
+// GetRoleAudit returns the most recent role changes, newest first
+func (db *Database) GetRoleAudit(limit int) ([]RoleAuditEntry, error) {
+	rows, err := db.DB.Query(`
+		SELECT ra.id, ra.actor_id, COALESCE(a.nickname, ''), ra.target_user_id, COALESCE(t.nickname, ''),
+		       ra.action, ra.role, COALESCE(ra.category_id, 0), ra.created_at
+		FROM role_audit ra
+		LEFT JOIN users a ON a.id = ra.actor_id
+		LEFT JOIN users t ON t.id = ra.target_user_id
+		ORDER BY ra.id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query role audit: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []RoleAuditEntry{}
+	for rows.Next() {
+		var e RoleAuditEntry
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Actor, &e.TargetUserID, &e.Target,
+			&e.Action, &e.Role, &e.CategoryID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan role audit row: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during role audit iteration: %w", err)
+	}
+	return entries, nil
+}
+
+// PromoteAdmins gives the admin role to the users with the given nicknames.
+// It is used at startup to bootstrap administrators.
+func (db *Database) PromoteAdmins(nicknames []string) error {
+	for _, nickname := range nicknames {
+		if _, err := db.DB.Exec("UPDATE users SET role = ? WHERE nickname = ?", RoleAdmin, nickname); err != nil {
+			return fmt.Errorf("failed to promote %s: %w", nickname, err)
+		}
+	}
+	return nil
+}
+
+// IsCategoryModerator reports whether the user moderates at least one category
+func (db *Database) IsCategoryModerator(userID int) (bool, error) {
+	var count int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM category_moderators WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check category moderator: %w", err)
+	}
+	return count > 0, nil
+}
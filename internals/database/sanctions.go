@@ -0,0 +1,218 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds of sanction. A suspension keeps the user from logging in; a mute
+// still allows reading but blocks posting, commenting and chat.
+const (
+	SanctionSuspension = "suspension"
+	SanctionMute       = "mute"
+)
+
+var (
+	ErrUserSuspended    = errors.New("account is suspended")
+	ErrUserMuted        = errors.New("you are muted")
+	ErrUnknownSanction  = errors.New("unknown sanction kind")
+	ErrSanctionNotFound = errors.New("sanction not found")
+)
+
+// Sanction is a suspension or mute placed on a user
+type Sanction struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"userId"`
+	Kind      string     `json:"kind"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expiresAt"` // nil for permanent sanctions
+	CreatedBy int        `json:"createdBy,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	LiftedAt  *time.Time `json:"liftedAt,omitempty"`
+	Active    bool       `json:"active"`
+}
+
+// terms describes how long the sanction lasts and why, for the sanctioned user
+func (s *Sanction) terms() string {
+	terms := "permanently"
+	if s.ExpiresAt != nil {
+		terms = "until " + s.ExpiresAt.UTC().Format(time.RFC1123)
+	}
+	if s.Reason != "" {
+		terms += ": " + s.Reason
+	}
+	return terms
+}
+
+const sanctionColumns = `id, user_id, kind, reason, expires_at, COALESCE(created_by, 0), created_at, lifted_at`
+
+func scanSanction(scan func(...interface{}) error) (*Sanction, error) {
+	var s Sanction
+	var expiresAt, liftedAt sql.NullTime
+	if err := scan(&s.ID, &s.UserID, &s.Kind, &s.Reason, &expiresAt, &s.CreatedBy, &s.CreatedAt, &liftedAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		s.ExpiresAt = &expiresAt.Time
+	}
+	if liftedAt.Valid {
+		s.LiftedAt = &liftedAt.Time
+	}
+	s.Active = !liftedAt.Valid && (s.ExpiresAt == nil || s.ExpiresAt.After(time.Now()))
+	return &s, nil
+}
+
+// addSanction records a sanction; suspensions also end the user's sessions.
+// Only users of a lower role than the actor can be sanctioned.
+func addSanction(tx *sql.Tx, actorID, userID int, kind, reason string, duration time.Duration) (int, error) {
+	if kind != SanctionSuspension && kind != SanctionMute {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownSanction, kind)
+	}
+	if err := checkOutranks(tx, actorID, userID); err != nil {
+		return 0, err
+	}
+	var expiresAt interface{}
+	if duration > 0 {
+		expiresAt = time.Now().Add(duration)
+	}
+	result, err := tx.Exec(`
+		INSERT INTO user_sanctions (user_id, kind, reason, expires_at, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, userID, kind, reason, expiresAt, actorID, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to add sanction: %w", err)
+	}
+	if kind == SanctionSuspension {
+		if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+			return 0, fmt.Errorf("failed to end sessions: %w", err)
+		}
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get sanction ID: %w", err)
+	}
+	return int(id), nil
+}
+
+// SanctionUser suspends or mutes a user for duration (0 is permanent) and
+// records it in the moderation log
+func (db *Database) SanctionUser(actorID, userID int, kind, reason string, duration time.Duration) (int, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	id, err := addSanction(tx, actorID, userID, kind, reason, duration)
+	if err != nil {
+		return 0, err
+	}
+	action := ModSuspend
+	if kind == SanctionMute {
+		action = ModMute
+	}
+	target := &ReportTarget{Type: TargetUser, ID: userID, AuthorID: userID}
+	if err := recordModeration(tx, actorID, action, target, reason); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit sanction: %w", err)
+	}
+	return id, nil
+}
+
+// LiftSanction ends a sanction of the given user before it expires
+func (db *Database) LiftSanction(actorID, userID, sanctionID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE user_sanctions SET lifted_at = ?, lifted_by = ?
+		WHERE id = ? AND user_id = ? AND lifted_at IS NULL
+	`, time.Now(), actorID, sanctionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to lift sanction: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrSanctionNotFound
+	}
+	target := &ReportTarget{Type: TargetUser, ID: userID, AuthorID: userID}
+	if err := recordModeration(tx, actorID, ModLift, target, fmt.Sprintf("sanction %d", sanctionID)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetUserSanctions returns every sanction placed on a user, newest first
+func (db *Database) GetUserSanctions(userID int) ([]Sanction, error) {
+	rows, err := db.DB.Query(`
+		SELECT `+sanctionColumns+` FROM user_sanctions
+		WHERE user_id = ?
+		ORDER BY id DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sanctions: %w", err)
+	}
+	defer rows.Close()
+
+	sanctions := []Sanction{}
+	for rows.Next() {
+		s, err := scanSanction(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sanction row: %w", err)
+		}
+		sanctions = append(sanctions, *s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during sanction iteration: %w", err)
+	}
+	return sanctions, nil
+}
+
+// ActiveSanction returns the longest running active sanction of a kind, or
+// nil. Expired sanctions simply stop matching, so they lift on their own.
+func (db *Database) ActiveSanction(userID int, kind string) (*Sanction, error) {
+	row := db.DB.QueryRow(`
+		SELECT `+sanctionColumns+` FROM user_sanctions
+		WHERE user_id = ? AND kind = ? AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY expires_at IS NULL DESC, expires_at DESC
+		LIMIT 1
+	`, userID, kind, time.Now())
+	s, err := scanSanction(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check %s: %w", kind, err)
+	}
+	return s, nil
+}
+
+// CheckNotSuspended returns ErrUserSuspended, with its terms, while the user is suspended
+func (db *Database) CheckNotSuspended(userID int) error {
+	s, err := db.ActiveSanction(userID, SanctionSuspension)
+	if err != nil {
+		return err
+	}
+	if s != nil {
+		return fmt.Errorf("%w %s", ErrUserSuspended, s.terms())
+	}
+	return nil
+}
+
+// CheckNotMuted returns ErrUserMuted, with its terms, while the user is muted
+func (db *Database) CheckNotMuted(userID int) error {
+	s, err := db.ActiveSanction(userID, SanctionMute)
+	if err != nil {
+		return err
+	}
+	if s != nil {
+		return fmt.Errorf("%w %s", ErrUserMuted, s.terms())
+	}
+	return nil
+}
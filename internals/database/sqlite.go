@@ -17,7 +17,23 @@ import (
 var schemaFS embed.FS
 
 type Database struct {
-	DB *sql.DB
+	DB      *sql.DB
+	HotRank HotRankConfig
+
+	// ReportThreshold is the number of open reports that hides content; 0 disables it
+	ReportThreshold int
+
+	automod automodCache
+
+	// clock replaces time.Now for post activity, so tests can move it
+	clock func() time.Time
+}
+
+func (d *Database) now() time.Time {
+	if d.clock != nil {
+		return d.clock()
+	}
+	return time.Now()
 }
 type User struct {
 	ID       int
@@ -35,6 +51,12 @@ type Post struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Author    string // Added field to store the author's nickname
+	Tags      []string
+	Hidden    bool // hidden by moderators or by reports pending review
+	Mentions  []Mention
+	Attachments []Attachment
+	UnreadComments       int // only filled in when listing posts for a reader
+	FirstUnreadCommentID int
 }
 
 // Category represents a forum category
@@ -43,6 +65,12 @@ type Category struct {
 	Name        string
 	Description string
 	PostCount   int       // Count of posts in this category
+	TotalPostCount int    // PostCount plus the posts of all subcategories
+	ParentID    int       // 0 for top level categories
+	Position    int       // Display order, lowest first
+	Icon        string
+	Color       string
+	Archived    bool      // Archived categories accept no new posts
 	CreatedAt   time.Time
 }
 
@@ -50,10 +78,13 @@ type Category struct {
 type Comment struct {
 	ID        int       `json:"id"`
 	PostID    int       `json:"postId"`
+	ParentID  int       `json:"parentId,omitempty"` // the comment this one replies to
 	UserID    int       `json:"authorId"`
 	Content   string    `json:"content"`
 	CreatedAt time.Time `json:"createdAt"`
 	Author    string    `json:"author"` // The nickname of the author
+	Mentions  []Mention `json:"mentions,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
 }
 
 
@@ -64,7 +95,15 @@ func New(dbPath string) (*Database, error) {
 		return nil, errors.New("database path cannot be empty")
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	// Foreign keys are enabled per connection, so the DSN turns them on for
+	// every connection in the pool rather than whichever one a PRAGMA hits
+	dsn := dbPath
+	if strings.Contains(dsn, "?") {
+		dsn += "&_foreign_keys=on"
+	} else {
+		dsn += "?_foreign_keys=on"
+	}
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -80,12 +119,6 @@ func New(dbPath string) (*Database, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// Enable foreign keys
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("error enaling foreign keys: %w", err)
-	}
-
 	// Initialize schema
 	schema, err := schemaFS.ReadFile("schema.sql")
 	if err != nil {
@@ -98,8 +131,13 @@ func New(dbPath string) (*Database, error) {
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
 	log.Println("Database initialized successfully!")
-	return &Database{DB: db}, nil
+	return &Database{DB: db, HotRank: DefaultHotRankConfig, ReportThreshold: DefaultReportThreshold}, nil
 }
 
 func (d *Database) Close() error {
@@ -204,6 +242,10 @@ func (d *Database) AuthenticateUser(nickname, email, password string) (*User, er
 		return nil, fmt.Errorf("invalid password")
 	}
 
+	if err := d.CheckNotSuspended(user.ID); err != nil {
+		return nil, err
+	}
+
 	fmt.Println("Password validation successful")
 
 	// Return user data without the password for security
@@ -220,7 +262,7 @@ func (db *Database) GetPostByID(postID int) (*Post, error) {
 	var createdAtStr, updatedAtStr string
 
 	err := db.DB.QueryRow(`
-		SELECT p.id, p.title, p.content, p.user_id, p.category_id, c.name, u.nickname, p.created_at, p.updated_at
+		SELECT p.id, p.title, p.content, p.user_id, p.category_id, c.name, u.nickname, p.created_at, p.updated_at, p.hidden
 		FROM posts p
 		JOIN users u ON p.user_id = u.id
 		JOIN categories c ON p.category_id = c.id
@@ -235,6 +277,7 @@ func (db *Database) GetPostByID(postID int) (*Post, error) {
 		&post.Author,
 		&createdAtStr,
 		&updatedAtStr,
+		&post.Hidden,
 	)
 
 	if err != nil {
@@ -253,87 +296,72 @@ func (db *Database) GetPostByID(postID int) (*Post, error) {
 		post.UpdatedAt = time.Now()
 	}
 
-	return &post, nil
+	posts := []Post{post}
+	if err := db.attachTags(posts); err != nil {
+		return nil, err
+	}
+	if err := db.attachPostMentions(posts); err != nil {
+		return nil, err
+	}
+	if err := db.attachPostAttachments(posts); err != nil {
+		return nil, err
+	}
+
+	return &posts[0], nil
 }
 
-// CreatePost creates a new post with a category
-func (d *Database) CreatePost(userID int, categoryID int, title, content string) error {
-	_, err := d.DB.Exec(
-		"INSERT INTO posts (user_id, category_id, title, content, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
-		userID, categoryID, title, content, time.Now(), time.Now(),
+// CreatePost creates a new post with a category and normalized tags and returns its ID.
+// With a hold rule the post is stored hidden and queued for review.
+func (d *Database) CreatePost(userID int, categoryID int, title, content string, tags []string, hold *AutomodRule) (int, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := d.now()
+	result, err := tx.Exec(
+		"INSERT INTO posts (user_id, category_id, title, content, hidden, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		userID, categoryID, title, content, hold != nil, now, now,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create post: %w", err)
+		return 0, fmt.Errorf("failed to create post: %w", err)
 	}
-	return nil
-}
-// GetAllPosts retrieves all posts with category information
-func (db *Database) GetAllPosts() ([]Post, error) {
-	rows, err := db.DB.Query(`
-		SELECT p.id, p.title, p.content, p.user_id, p.category_id, c.name, u.nickname, p.created_at, p.updated_at
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		JOIN categories c ON p.category_id = c.id
-		ORDER BY p.created_at DESC
-	`)
+	id, err := result.LastInsertId()
 	if err != nil {
-		return nil, fmt.Errorf("failed to query all posts: %w", err)
+		return 0, fmt.Errorf("failed to get post ID: %w", err)
 	}
-	defer rows.Close()
 
-	var posts []Post
-	for rows.Next() {
-		var post Post
-		var createdAtStr string
-		var updatedAtStr string
-		err := rows.Scan(
-			&post.ID,
-			&post.Title,
-			&post.Content,
-			&post.UserID,
-			&post.CategoryID,
-			&post.Category, // Scan the category name
-			&post.Author,   // Scan the nickname into the Author field
-			&createdAtStr,
-			&updatedAtStr,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan post row: %w", err)
-		}
-
-		// Parse the timestamp strings into time.Time
-		createdAt, err := time.Parse(time.RFC3339, createdAtStr)
-		if err != nil {
-			log.Printf("failed to parse created_at timestamp: %v", err)
-			createdAt = time.Now() // Fallback to current time on parse error
-		}
-		post.CreatedAt = createdAt
+	score := storedScore(d.HotRank.hotContribution(d.HotRank.PostWeight, now))
+	if _, err := tx.Exec("INSERT INTO post_stats (post_id, hot_score, last_activity_at) VALUES (?, ?, ?)",
+		id, score, now); err != nil {
+		return 0, fmt.Errorf("failed to create post stats: %w", err)
+	}
 
-		updatedAt, err := time.Parse(time.RFC3339, updatedAtStr)
-		if err != nil {
-			log.Printf("failed to parse updated_at timestamp: %v", err)
-			updatedAt = time.Now() // Fallback to current time on parse error
+	if err := setPostTags(tx, int(id), tags); err != nil {
+		return 0, err
+	}
+	if hold != nil {
+		if err := holdContent(tx, TargetPost, int(id), hold); err != nil {
+			return 0, err
 		}
-		post.UpdatedAt = updatedAt
-
-		posts = append(posts, post)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit post: %w", err)
 	}
-
-	return posts, nil
+	return int(id), nil
 }
 
 // GetAllCategories retrieves all categories with post counts
 func (db *Database) GetAllCategories() ([]Category, error) {
 	rows, err := db.DB.Query(`
-		SELECT c.id, c.name, c.description, COUNT(p.id) as post_count, c.created_at
+		SELECT c.id, c.name, c.description, COUNT(p.id) as post_count,
+		       COALESCE(c.parent_id, 0), c.position, c.icon, c.color, c.archived, c.created_at
 		FROM categories c
 		LEFT JOIN posts p ON c.id = p.category_id
 		GROUP BY c.id
-		ORDER BY c.name ASC
+		ORDER BY c.position ASC, c.name ASC
 	`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query categories: %w", err)
@@ -351,6 +379,11 @@ func (db *Database) GetAllCategories() ([]Category, error) {
 			&category.Name,
 			&description,
 			&category.PostCount,
+			&category.ParentID,
+			&category.Position,
+			&category.Icon,
+			&category.Color,
+			&category.Archived,
 			&createdAtStr,
 		)
 		if err != nil {
@@ -376,6 +409,8 @@ func (db *Database) GetAllCategories() ([]Category, error) {
 		return nil, fmt.Errorf("error during categories iteration: %w", err)
 	}
 
+	withSubtreeCounts(categories)
+
 	return categories, nil
 }
 
@@ -386,13 +421,18 @@ func (db *Database) GetCategoryByName(name string) (*Category, error) {
 	var description sql.NullString
 
 	err := db.DB.QueryRow(`
-		SELECT id, name, description, created_at
+		SELECT id, name, description, COALESCE(parent_id, 0), position, icon, color, archived, created_at
 		FROM categories
 		WHERE name = ?
 	`, name).Scan(
 		&category.ID,
 		&category.Name,
 		&description,
+		&category.ParentID,
+		&category.Position,
+		&category.Icon,
+		&category.Color,
+		&category.Archived,
 		&createdAtStr,
 	)
 
@@ -418,67 +458,6 @@ func (db *Database) GetCategoryByName(name string) (*Category, error) {
 	return &category, nil
 }
 
-// GetPostsByCategory retrieves all posts for a specific category
-func (db *Database) GetPostsByCategory(categoryID int) ([]Post, error) {
-	rows, err := db.DB.Query(`
-		SELECT p.id, p.title, p.content, p.user_id, p.category_id, c.name, u.nickname, p.created_at, p.updated_at
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		JOIN categories c ON p.category_id = c.id
-		WHERE p.category_id = ?
-		ORDER BY p.created_at DESC
-	`, categoryID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query posts by category: %w", err)
-	}
-	defer rows.Close()
-
-	var posts []Post
-	for rows.Next() {
-		var post Post
-		var createdAtStr string
-		var updatedAtStr string
-		err := rows.Scan(
-			&post.ID,
-			&post.Title,
-			&post.Content,
-			&post.UserID,
-			&post.CategoryID,
-			&post.Category,
-			&post.Author,
-			&createdAtStr,
-			&updatedAtStr,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan post row: %w", err)
-		}
-
-		// Parse the timestamp strings into time.Time
-		createdAt, err := time.Parse(time.RFC3339, createdAtStr)
-		if err != nil {
-			log.Printf("failed to parse created_at timestamp: %v", err)
-			createdAt = time.Now() // Fallback to current time on parse error
-		}
-		post.CreatedAt = createdAt
-
-		updatedAt, err := time.Parse(time.RFC3339, updatedAtStr)
-		if err != nil {
-			log.Printf("failed to parse updated_at timestamp: %v", err)
-			updatedAt = time.Now() // Fallback to current time on parse error
-		}
-		post.UpdatedAt = updatedAt
-
-		posts = append(posts, post)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during rows iteration: %w", err)
-	}
-
-	return posts, nil
-}
-
-
 // Update user online status
 func (d *Database) UpdateUserStatus(userID int, online bool) error {
     _, err := d.DB.Exec(`
@@ -561,12 +540,24 @@ func(db *Database)PostExists(PostID int)(bool,error){
 
 }
 
-// CreateComment adds a new comment to a post
+// CreateComment adds a new comment to a post, optionally as a reply to
+// another comment (parentID 0 for none). With a hold rule the comment is
+// stored hidden and queued for review.
+func (db *Database) CreateComment(userID, postID, parentID int, content string, hold *AutomodRule) (int, error){
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-func (db *Database) CreateComment(userID, postID int, content string) (int, error){
-	result, err := db.DB.Exec(
-		"INSERT INTO comments (user_id, post_id, content, created_at) VALUES (?, ?, ?, ?)",
-		userID, postID, content, time.Now(),
+	now := db.now()
+	var parent interface{}
+	if parentID != 0 {
+		parent = parentID
+	}
+	result, err := tx.Exec(
+		"INSERT INTO comments (user_id, post_id, parent_id, content, hidden, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, postID, parent, content, hold != nil, now,
 	)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create comment: %w", err)
@@ -577,6 +568,18 @@ func (db *Database) CreateComment(userID, postID int, content string) (int, erro
 	if err != nil {
 		return 0, fmt.Errorf("failed to get comment ID: %w", err)
 	}
+
+	// Held comments count once a moderator approves them
+	if hold != nil {
+		if err := holdContent(tx, TargetComment, int(id), hold); err != nil {
+			return 0, err
+		}
+	} else if err := db.recordActivity(tx, postID, statComments, db.HotRank.CommentWeight, 1, now); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit comment: %w", err)
+	}
 	return int(id), nil
 
 }
@@ -584,10 +587,10 @@ func (db *Database) CreateComment(userID, postID int, content string) (int, erro
 // GetCommentsByPostID retrieves all comments for a specific post
 func (db *Database) GetCommentsByPostID(postID int) ([]Comment, error) {
 	rows, err := db.DB.Query(`
-		SELECT c.id, c.post_id, c.user_id, c.content, u.nickname, c.created_at
+		SELECT c.id, c.post_id, COALESCE(c.parent_id, 0), c.user_id, c.content, u.nickname, c.created_at
 		FROM comments c
 		JOIN users u ON c.user_id = u.id
-		WHERE c.post_id = ?
+		WHERE c.post_id = ? AND c.hidden = 0
 		ORDER BY c.created_at ASC
 	`, postID)
 	if err != nil {
@@ -602,6 +605,7 @@ func (db *Database) GetCommentsByPostID(postID int) ([]Comment, error) {
 		err := rows.Scan(
 			&comment.ID,
 			&comment.PostID,
+			&comment.ParentID,
 			&comment.UserID,
 			&comment.Content,
 			&comment.Author,
@@ -625,6 +629,21 @@ func (db *Database) GetCommentsByPostID(postID int) ([]Comment, error) {
 		return nil, fmt.Errorf("error during comment iteration: %w", err)
 	}
 
+	ids := make([]int, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.ID
+	}
+	mentions, err := db.mentionsOf(TargetComment, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range comments {
+		comments[i].Mentions = mentions[comments[i].ID]
+	}
+	if err := db.attachCommentAttachments(comments); err != nil {
+		return nil, err
+	}
+
 	return comments, nil
 }
 
@@ -676,3 +695,52 @@ func (db *Database) IsTokenValid(token string) (bool, error) {
 	
 
 
+
+// DeletePost removes a post; its comments, reactions, tags and stats cascade.
+// Mentions are keyed by source rather than by a foreign key, so they are
+// removed here, while attachments are left for the attachment collector to
+// delete along with their files.
+func (db *Database) DeletePost(postID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM mentions
+		WHERE (source_type = ? AND source_id = ?)
+		   OR (source_type = ? AND source_id IN (SELECT id FROM comments WHERE post_id = ?))
+	`, TargetPost, postID, TargetComment, postID)
+	if err != nil {
+		return fmt.Errorf("failed to delete post mentions: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM posts WHERE id = ?", postID)
+	if err != nil {
+		return fmt.Errorf("failed to delete post: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("post not found")
+	}
+	return tx.Commit()
+}
+
+var ErrCommentNotFound = errors.New("comment not found")
+
+// GetCommentByID retrieves a visible comment without its mentions
+func (db *Database) GetCommentByID(commentID int) (*Comment, error) {
+	var comment Comment
+	err := db.DB.QueryRow(`
+		SELECT c.id, c.post_id, COALESCE(c.parent_id, 0), c.user_id, c.content, u.nickname, c.created_at
+		FROM comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.id = ? AND c.hidden = 0
+	`, commentID).Scan(&comment.ID, &comment.PostID, &comment.ParentID, &comment.UserID, &comment.Content, &comment.Author, &comment.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comment: %w", err)
+	}
+	return &comment, nil
+}
@@ -0,0 +1,256 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidSubscription  = errors.New("invalid subscription")
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrPostNotFound         = errors.New("post not found")
+)
+
+// TargetCategory is a category as a subscription target, next to TargetPost
+const TargetCategory = "category"
+
+// Subscription levels. A subscription on a post overrides one on its
+// category, which overrides one on a parent category.
+const (
+	SubscriptionWatch = "watch" // notify of every new comment
+	SubscriptionMute  = "mute"  // never notify of activity in the thread
+)
+
+// watchedCategoriesQuery selects the categories a user watches and their
+// subcategories, leaving out subcategories the user mutes. It takes the user
+// ID twice.
+const watchedCategoriesQuery = `
+	WITH RECURSIVE watched(id) AS (
+		SELECT target_id FROM subscriptions
+		WHERE user_id = ? AND target_type = 'category' AND level = 'watch'
+		UNION
+		SELECT c.id FROM categories c JOIN watched w ON c.parent_id = w.id
+		WHERE NOT EXISTS (
+			SELECT 1 FROM subscriptions m
+			WHERE m.user_id = ? AND m.target_type = 'category' AND m.target_id = c.id AND m.level = 'mute')
+	)
+	SELECT id FROM watched`
+
+// Subscription is a watch or mute on a post or category
+type Subscription struct {
+	TargetType string    `json:"targetType"`
+	TargetID   int       `json:"targetId"`
+	Title      string    `json:"title"` // the post title or category name
+	Level      string    `json:"level"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// WatchedThread is a watched post with the number of comments by others the
+// user has not seen yet
+type WatchedThread struct {
+	PostID         int       `json:"postId"`
+	Title          string    `json:"title"`
+	Category       string    `json:"category"`
+	CommentCount   int       `json:"commentCount"`
+	UnreadComments int       `json:"unreadComments"`
+	LastActivityAt time.Time `json:"lastActivityAt"`
+}
+
+// SetSubscription watches or mutes a post or category, replacing any
+// previous level
+func (db *Database) SetSubscription(userID int, targetType string, targetID int, level string) error {
+	if level != SubscriptionWatch && level != SubscriptionMute {
+		return fmt.Errorf("%w: level must be %s or %s", ErrInvalidSubscription, SubscriptionWatch, SubscriptionMute)
+	}
+
+	var count int
+	switch targetType {
+	case TargetPost:
+		// Authors may watch their posts while they are held for review
+		err := db.DB.QueryRow("SELECT COUNT(*) FROM posts WHERE id = ? AND (hidden = FALSE OR user_id = ?)",
+			targetID, userID).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to check post: %w", err)
+		}
+		if count == 0 {
+			return ErrPostNotFound
+		}
+	case TargetCategory:
+		err := db.DB.QueryRow("SELECT COUNT(*) FROM categories WHERE id = ?", targetID).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to check category: %w", err)
+		}
+		if count == 0 {
+			return ErrCategoryNotFound
+		}
+	default:
+		return fmt.Errorf("%w: unknown target type %q", ErrInvalidSubscription, targetType)
+	}
+
+	_, err := db.DB.Exec(`
+		INSERT INTO subscriptions (user_id, target_type, target_id, level, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, target_type, target_id) DO UPDATE SET level = excluded.level
+	`, userID, targetType, targetID, level, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to store subscription: %w", err)
+	}
+	return nil
+}
+
+// DeleteSubscription removes a watch or mute, so the target falls back to
+// the level of its category, if any
+func (db *Database) DeleteSubscription(userID int, targetType string, targetID int) error {
+	result, err := db.DB.Exec(
+		"DELETE FROM subscriptions WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, targetType, targetID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete subscription: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+// GetSubscriptions lists every watch and mute of a user, newest first
+func (db *Database) GetSubscriptions(userID int) ([]Subscription, error) {
+	rows, err := db.DB.Query(`
+		SELECT s.target_type, s.target_id, COALESCE(p.title, c.name, ''), s.level, s.created_at
+		FROM subscriptions s
+		LEFT JOIN posts p ON s.target_type = 'post' AND p.id = s.target_id
+		LEFT JOIN categories c ON s.target_type = 'category' AND c.id = s.target_id
+		WHERE s.user_id = ?
+		ORDER BY s.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []Subscription{}
+	for rows.Next() {
+		var s Subscription
+		if err := rows.Scan(&s.TargetType, &s.TargetID, &s.Title, &s.Level, &s.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// ThreadSubscriptions returns the effective subscription level of every user
+// who watches or mutes a post, directly or through its categories
+func (db *Database) ThreadSubscriptions(postID int) (map[int]string, error) {
+	return db.threadSubscriptions(postID, 0)
+}
+
+// ThreadSubscription returns a user's effective subscription level on a
+// post, or "" when they neither watch nor mute it
+func (db *Database) ThreadSubscription(userID, postID int) (string, error) {
+	levels, err := db.threadSubscriptions(postID, userID)
+	if err != nil {
+		return "", err
+	}
+	return levels[userID], nil
+}
+
+// threadSubscriptions resolves subscription levels on a post, for a single
+// user or for everyone when userID is 0. The most specific subscription
+// wins: the post itself, then the closest category.
+func (db *Database) threadSubscriptions(postID, userID int) (map[int]string, error) {
+	var categoryID int
+	err := db.DB.QueryRow("SELECT category_id FROM posts WHERE id = ?", postID).Scan(&categoryID)
+	if err == sql.ErrNoRows {
+		return nil, ErrPostNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post category: %w", err)
+	}
+	path, err := categoryAncestors(db.DB, categoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rank targets by specificity: the root category is 0, the post is len(path)
+	rank := make(map[int]int, len(path))
+	for i, c := range path {
+		rank[c.ID] = i
+	}
+
+	query := `
+		SELECT user_id, target_type, target_id, level FROM subscriptions
+		WHERE ((target_type = 'post' AND target_id = ?)
+		   OR (target_type = 'category' AND target_id IN (` + placeholders(len(path)) + `)))`
+	args := append([]interface{}{postID}, categoryIDs(path)...)
+	if userID != 0 {
+		query += " AND user_id = ?"
+		args = append(args, userID)
+	}
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query thread subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	levels := make(map[int]string)
+	specificity := make(map[int]int)
+	for rows.Next() {
+		var user, targetID int
+		var targetType, level string
+		if err := rows.Scan(&user, &targetType, &targetID, &level); err != nil {
+			return nil, fmt.Errorf("failed to scan thread subscription: %w", err)
+		}
+		r := len(path)
+		if targetType == TargetCategory {
+			r = rank[targetID]
+		}
+		if current, ok := specificity[user]; !ok || r > current {
+			specificity[user] = r
+			levels[user] = level
+		}
+	}
+	return levels, rows.Err()
+}
+
+// GetWatchedThreads lists the posts a user watches directly, most recently
+// active first
+func (db *Database) GetWatchedThreads(userID, limit int) ([]WatchedThread, error) {
+	rows, err := db.DB.Query(`
+		SELECT p.id, p.title, c.name, COALESCE(ps.comment_count, 0),
+		       (SELECT COUNT(*) FROM comments cm
+		        WHERE cm.post_id = p.id AND cm.hidden = FALSE AND cm.user_id != s.user_id
+		          AND cm.id > `+lastReadComment+`),
+		       p.created_at, ps.last_activity_at
+		FROM subscriptions s
+		JOIN posts p ON p.id = s.target_id AND p.hidden = FALSE
+		JOIN categories c ON c.id = p.category_id
+		LEFT JOIN post_stats ps ON ps.post_id = p.id`+readMarkJoins+`
+		WHERE s.user_id = ? AND s.target_type = 'post' AND s.level = 'watch'
+		ORDER BY COALESCE(ps.last_activity_at, p.created_at) DESC
+		LIMIT ?
+	`, userID, userID, userID, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query watched threads: %w", err)
+	}
+	defer rows.Close()
+
+	threads := []WatchedThread{}
+	for rows.Next() {
+		var t WatchedThread
+		var lastActivity sql.NullTime
+		err := rows.Scan(&t.PostID, &t.Title, &t.Category, &t.CommentCount, &t.UnreadComments,
+			&t.LastActivityAt, &lastActivity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan watched thread: %w", err)
+		}
+		if lastActivity.Valid {
+			t.LastActivityAt = lastActivity.Time
+		}
+		threads = append(threads, t)
+	}
+	return threads, rows.Err()
+}
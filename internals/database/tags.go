@@ -0,0 +1,177 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Limits applied to tags attached to a post
+const (
+	MaxTagsPerPost = 5
+	MaxTagLength   = 32
+)
+
+// ErrInvalidTag is returned when a tag is empty after normalization or too long
+var ErrInvalidTag = errors.New("invalid tag")
+
+// Tag is a free-form label attached to posts
+type Tag struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	PostCount int    `json:"postCount"`
+}
+
+// NormalizeTag lowercases a tag and reduces it to letters, digits and dashes
+func NormalizeTag(raw string) (string, error) {
+	var b strings.Builder
+	lastDash := true
+	for _, r := range strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(raw), "#"))) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			lastDash = false
+		case !lastDash:
+			b.WriteRune('-')
+			lastDash = true
+		}
+	}
+	tag := strings.TrimSuffix(b.String(), "-")
+	if tag == "" || len([]rune(tag)) > MaxTagLength {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTag, raw)
+	}
+	return tag, nil
+}
+
+// NormalizeTags normalizes and de-duplicates a list of tags, keeping their order
+func NormalizeTags(raw []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, r := range raw {
+		tag, err := NormalizeTag(r)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > MaxTagsPerPost {
+		return nil, fmt.Errorf("%w: at most %d tags per post", ErrInvalidTag, MaxTagsPerPost)
+	}
+	return tags, nil
+}
+
+// setPostTags links already normalized tags to a post, creating missing tags
+func setPostTags(tx *sql.Tx, postID int, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM post_tags WHERE post_id = ?", postID); err != nil {
+		return fmt.Errorf("failed to clear post tags: %w", err)
+	}
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO tags (name) VALUES (?)", tag); err != nil {
+			return fmt.Errorf("failed to create tag: %w", err)
+		}
+		if _, err := tx.Exec(`
+			INSERT OR IGNORE INTO post_tags (post_id, tag_id)
+			SELECT ?, id FROM tags WHERE name = ?
+		`, postID, tag); err != nil {
+			return fmt.Errorf("failed to tag post: %w", err)
+		}
+	}
+	return nil
+}
+
+// attachTags fills in the Tags field of each post
+func (db *Database) attachTags(posts []Post) error {
+	index := make(map[int]int, len(posts))
+	for i, post := range posts {
+		index[post.ID] = i
+	}
+
+	return inChunks(postIDs(posts), func(ids []int) error {
+		args := make([]interface{}, len(ids))
+		for i, id := range ids {
+			args[i] = id
+		}
+		rows, err := db.DB.Query(`
+			SELECT pt.post_id, t.name
+			FROM post_tags pt
+			JOIN tags t ON t.id = pt.tag_id
+			WHERE pt.post_id IN (`+placeholders(len(ids))+`)
+			ORDER BY t.name ASC
+		`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query post tags: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var postID int
+			var name string
+			if err := rows.Scan(&postID, &name); err != nil {
+				return fmt.Errorf("failed to scan post tag: %w", err)
+			}
+			if i, ok := index[postID]; ok {
+				posts[i].Tags = append(posts[i].Tags, name)
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// SearchTags returns tags starting with prefix, most used first
+func (db *Database) SearchTags(prefix string, limit int) ([]Tag, error) {
+	prefix = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(prefix, "#")))
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	return db.queryTags(`
+		SELECT t.id, t.name, COUNT(pt.post_id) AS post_count
+		FROM tags t
+		LEFT JOIN post_tags pt ON pt.tag_id = t.id
+		WHERE t.name LIKE ? ESCAPE '\'
+		GROUP BY t.id
+		ORDER BY post_count DESC, t.name ASC
+		LIMIT ?
+	`, escaped+"%", limit)
+}
+
+// GetTagByName retrieves a tag with its post count
+func (db *Database) GetTagByName(name string) (*Tag, error) {
+	tags, err := db.queryTags(`
+		SELECT t.id, t.name, COUNT(pt.post_id)
+		FROM tags t
+		LEFT JOIN post_tags pt ON pt.tag_id = t.id
+		WHERE t.name = ?
+		GROUP BY t.id
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("tag not found: %s", name)
+	}
+	return &tags[0], nil
+}
+
+func (db *Database) queryTags(query string, args ...interface{}) ([]Tag, error) {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.PostCount); err != nil {
+			return nil, fmt.Errorf("failed to scan tag row: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during tag iteration: %w", err)
+	}
+	return tags, nil
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnknownUserSetting = errors.New("unknown user setting")
+	ErrInvalidUserSetting = errors.New("invalid user setting value")
+)
+
+// User settings and their values
+const (
+	UserSettingAllowMentions       = "allow_mentions"        // "true" or "false"
+	UserSettingMutedThreadMentions = "muted_thread_mentions" // "false" silences mentions in muted threads too
+	UserSettingDigest              = "digest"                // DigestOff, DigestDaily or DigestWeekly
+	UserSettingFollowedPosts       = "followed_posts"        // "false" stops notifications of new posts by followed users
+
+	// Privacy settings, each an audience unless noted
+	UserSettingShowRealName  = "show_real_name"
+	UserSettingShowAge       = "show_age"
+	UserSettingShowGender    = "show_gender"
+	UserSettingShowLastSeen  = "show_last_seen"
+	UserSettingAppearOnline  = "appear_online"  // "false" shows the user as offline to everyone
+	UserSettingAllowMessages = "allow_messages" // who may start or continue a conversation
+)
+
+// userSettingDefaults apply to users who never changed a setting
+var userSettingDefaults = map[string]string{
+	UserSettingAllowMentions:       "true",
+	UserSettingMutedThreadMentions: "true",
+	UserSettingDigest:              DigestWeekly,
+	UserSettingFollowedPosts:       "true",
+	UserSettingShowRealName:        AudienceNobody,
+	UserSettingShowAge:             AudienceNobody,
+	UserSettingShowGender:          AudienceNobody,
+	UserSettingShowLastSeen:        AudienceEveryone,
+	UserSettingAppearOnline:        "true",
+	UserSettingAllowMessages:       AudienceEveryone,
+}
+
+// userSettingValidators lists the known settings and checks their values
+var userSettingValidators = map[string]func(string) error{
+	UserSettingAllowMentions:       boolSetting(UserSettingAllowMentions),
+	UserSettingMutedThreadMentions: boolSetting(UserSettingMutedThreadMentions),
+	UserSettingDigest:              oneOfSetting(UserSettingDigest, DigestOff, DigestDaily, DigestWeekly),
+	UserSettingFollowedPosts:       boolSetting(UserSettingFollowedPosts),
+	UserSettingShowRealName:        audienceSetting(UserSettingShowRealName),
+	UserSettingShowAge:             audienceSetting(UserSettingShowAge),
+	UserSettingShowGender:          audienceSetting(UserSettingShowGender),
+	UserSettingShowLastSeen:        audienceSetting(UserSettingShowLastSeen),
+	UserSettingAppearOnline:        boolSetting(UserSettingAppearOnline),
+	UserSettingAllowMessages:       audienceSetting(UserSettingAllowMessages),
+}
+
+func boolSetting(key string) func(string) error {
+	return func(v string) error {
+		if v != "true" && v != "false" {
+			return fmt.Errorf("%w: %s must be true or false", ErrInvalidUserSetting, key)
+		}
+		return nil
+	}
+}
+
+func oneOfSetting(key string, values ...string) func(string) error {
+	return func(v string) error {
+		if !slices.Contains(values, v) {
+			return fmt.Errorf("%w: %s must be one of %s", ErrInvalidUserSetting, key, strings.Join(values, ", "))
+		}
+		return nil
+	}
+}
+
+// SetUserSetting changes a setting of a user; an empty value restores the default
+func (db *Database) SetUserSetting(userID int, key, value string) error {
+	validate, ok := userSettingValidators[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownUserSetting, key)
+	}
+
+	if value == "" {
+		_, err := db.DB.Exec("DELETE FROM user_settings WHERE user_id = ? AND key = ?", userID, key)
+		if err != nil {
+			return fmt.Errorf("failed to clear user setting: %w", err)
+		}
+		return nil
+	}
+
+	if err := validate(value); err != nil {
+		return err
+	}
+	_, err := db.DB.Exec(`
+		INSERT INTO user_settings (user_id, key, value) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, key) DO UPDATE SET value = excluded.value
+	`, userID, key, value)
+	if err != nil {
+		return fmt.Errorf("failed to store user setting: %w", err)
+	}
+	return nil
+}
+
+// GetUserSettings returns every setting of a user, filling in defaults
+func (db *Database) GetUserSettings(userID int) (map[string]string, error) {
+	settings := make(map[string]string, len(userSettingDefaults))
+	for key, value := range userSettingDefaults {
+		settings[key] = value
+	}
+
+	rows, err := db.DB.Query("SELECT key, value FROM user_settings WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user settings: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan user setting: %w", err)
+		}
+		if _, known := userSettingValidators[key]; known {
+			settings[key] = value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during user setting iteration: %w", err)
+	}
+	return settings, nil
+}
+
+// GetUserSetting returns a single setting of a user
+func (db *Database) GetUserSetting(userID int, key string) (string, error) {
+	settings, err := db.GetUserSettings(userID)
+	if err != nil {
+		return "", err
+	}
+	value, ok := settings[key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownUserSetting, key)
+	}
+	return value, nil
+}
@@ -0,0 +1,242 @@
+// Package digest emails users who stay away a summary of what they missed:
+// popular posts in the categories they watch, unread mentions and messages.
+package digest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"embed"
+	htmltemplate "html/template"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/mail"
+)
+
+// Limits on the size of a digest
+const (
+	maxPosts    = 5
+	maxMentions = 5
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	htmlTemplate = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/digest.html"))
+	textTemplate = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/digest.txt"))
+)
+
+// Config controls when digests go out and where their links point
+type Config struct {
+	Interval time.Duration // how often to look for due digests
+	BaseURL  string        // the public address of the forum, without a trailing slash
+	Secret   []byte        // signs unsubscribe links
+}
+
+// ConfigFromEnv reads FORUM_DIGEST_INTERVAL (1h by default), FORUM_BASE_URL
+// and FORUM_DIGEST_SECRET. Without a secret a random one is generated, so
+// unsubscribe links stop working after a restart.
+func ConfigFromEnv() Config {
+	cfg := Config{Interval: time.Hour, BaseURL: "http://localhost:8080"}
+	if v := os.Getenv("FORUM_DIGEST_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("ignoring invalid FORUM_DIGEST_INTERVAL=%q", v)
+		} else {
+			cfg.Interval = d
+		}
+	}
+	if v := os.Getenv("FORUM_BASE_URL"); v != "" {
+		cfg.BaseURL = strings.TrimSuffix(v, "/")
+	}
+	if v := os.Getenv("FORUM_DIGEST_SECRET"); v != "" {
+		cfg.Secret = []byte(v)
+	} else {
+		log.Printf("FORUM_DIGEST_SECRET is not set, unsubscribe links will not survive a restart")
+		cfg.Secret = make([]byte, 32)
+		rand.Read(cfg.Secret)
+	}
+	return cfg
+}
+
+type Digester struct {
+	db     *database.Database
+	mailer mail.Mailer
+	config Config
+}
+
+func New(db *database.Database, mailer mail.Mailer, config Config) *Digester {
+	return &Digester{db: db, mailer: mailer, config: config}
+}
+
+// Run sends due digests every interval, forever
+func (d *Digester) Run() {
+	ticker := time.NewTicker(d.config.Interval)
+	defer ticker.Stop()
+	for {
+		if sent, err := d.SendDue(time.Now()); err != nil {
+			log.Printf("Error sending digests: %v", err)
+		} else if sent > 0 {
+			log.Printf("Sent %d email digests", sent)
+		}
+		<-ticker.C
+	}
+}
+
+// SendDue emails a digest to every user who has been away for a whole
+// period of their chosen frequency and was not sent one during that period.
+// Users with nothing new get no email. It returns how many were sent.
+func (d *Digester) SendDue(now time.Time) (int, error) {
+	recipients, err := d.db.GetDigestRecipients()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, r := range recipients {
+		period, ok := database.DigestPeriods[r.Frequency]
+		if !ok || r.Email == "" || now.Sub(r.LastActiveAt) < period {
+			continue
+		}
+		if !r.LastSentAt.IsZero() && now.Sub(r.LastSentAt) < period {
+			continue
+		}
+
+		msg, err := d.build(r, now.Add(-period))
+		if err != nil {
+			log.Printf("Error building digest for user %d: %v", r.UserID, err)
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+		if err := d.mailer.Send(*msg); err != nil {
+			log.Printf("Error sending digest to user %d: %v", r.UserID, err)
+			continue
+		}
+		if err := d.db.RecordDigestSent(r.UserID, now); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+type postLink struct {
+	database.DigestPost
+	URL string
+}
+
+type mentionLink struct {
+	Actor string
+	Where string
+	URL   string
+}
+
+type conversationLink struct {
+	database.DigestConversation
+	URL string
+}
+
+// digestData is what the templates render
+type digestData struct {
+	Nickname       string
+	Frequency      string
+	Period         string
+	Posts          []postLink
+	Mentions       []mentionLink
+	MentionCount   int
+	Conversations  []conversationLink
+	SettingsURL    string
+	UnsubscribeURL string
+}
+
+// build renders the digest of a user covering activity since a time, or
+// returns nil when there is nothing to tell
+func (d *Digester) build(r database.DigestRecipient, since time.Time) (*mail.Message, error) {
+	if r.LastSentAt.After(since) {
+		since = r.LastSentAt
+	}
+	base := d.config.BaseURL
+
+	data := digestData{
+		Nickname:       r.Nickname,
+		Frequency:      r.Frequency,
+		Period:         "week",
+		SettingsURL:    base + "/settings",
+		UnsubscribeURL: UnsubscribeURL(base, d.config.Secret, r.UserID),
+	}
+	if r.Frequency == database.DigestDaily {
+		data.Period = "day"
+	}
+
+	posts, err := d.db.DigestTopPosts(r.UserID, since, maxPosts)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range posts {
+		data.Posts = append(data.Posts, postLink{DigestPost: p, URL: base + "/posts/" + strconv.Itoa(p.ID)})
+	}
+
+	mentions, total, err := d.db.DigestMentions(r.UserID, maxMentions)
+	if err != nil {
+		return nil, err
+	}
+	data.MentionCount = total
+	for _, m := range mentions {
+		link := mentionLink{Actor: m.Actor, Where: m.TargetType}
+		switch m.TargetType {
+		case database.TargetMessage:
+			link.URL = base + "/chat"
+		case database.TargetComment:
+			link.URL = base + "/posts/" + strconv.Itoa(m.PostID) + "#comment-" + strconv.Itoa(m.TargetID)
+		default:
+			link.URL = base + "/posts/" + strconv.Itoa(m.PostID)
+		}
+		if link.Actor == "" {
+			link.Actor = "Someone"
+		}
+		data.Mentions = append(data.Mentions, link)
+	}
+
+	conversations, err := d.db.DigestConversations(r.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range conversations {
+		data.Conversations = append(data.Conversations, conversationLink{
+			DigestConversation: c,
+			URL:                base + "/chat/" + strconv.Itoa(c.ID),
+		})
+	}
+
+	if len(data.Posts) == 0 && len(data.Mentions) == 0 && len(data.Conversations) == 0 {
+		return nil, nil
+	}
+
+	var html, text bytes.Buffer
+	if err := htmlTemplate.Execute(&html, data); err != nil {
+		return nil, err
+	}
+	if err := textTemplate.Execute(&text, data); err != nil {
+		return nil, err
+	}
+
+	unsubscribe := data.UnsubscribeURL
+	return &mail.Message{
+		To:      r.Email,
+		Subject: "What you missed on the forum this " + data.Period,
+		Text:    text.String(),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}, nil
+}
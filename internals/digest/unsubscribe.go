@@ -0,0 +1,32 @@
+package digest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+)
+
+// unsubscribeSignature signs a user ID for one-click unsubscribe links
+func unsubscribeSignature(secret []byte, userID int) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("digest-unsubscribe:" + strconv.Itoa(userID)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// UnsubscribeURL returns a link that turns digests off for a user without
+// signing in
+func UnsubscribeURL(baseURL string, secret []byte, userID int) string {
+	query := url.Values{
+		"user": {strconv.Itoa(userID)},
+		"sig":  {unsubscribeSignature(secret, userID)},
+	}
+	return baseURL + "/api/digest/unsubscribe?" + query.Encode()
+}
+
+// VerifyUnsubscribe checks the signature of an unsubscribe link
+func VerifyUnsubscribe(secret []byte, userID int, signature string) bool {
+	expected := unsubscribeSignature(secret, userID)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
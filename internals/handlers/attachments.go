@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"real-time-forum/internals/attachments"
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/storage"
+)
+
+// maxFilenameLength caps stored attachment file names, in bytes
+const maxFilenameLength = 200
+
+// downloadLinkTTL is how long a presigned download link stays valid. The
+// link is handed out only after the access check, so it is kept short.
+const downloadLinkTTL = 5 * time.Minute
+
+// attachmentError maps database errors from attachments to HTTP responses
+func attachmentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrAttachmentNotFound):
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrInvalidAttachment):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error managing attachment: %v", err)
+		http.Error(w, "Failed to process attachment", http.StatusInternalServerError)
+	}
+}
+
+func quotaExceeded(w http.ResponseWriter, quota int64) {
+	http.Error(w, fmt.Sprintf("Attachment quota of %d bytes reached", quota), http.StatusForbidden)
+}
+
+// attachmentFilename keeps the base name of an uploaded file without
+// control characters, so it is safe to echo in headers
+func attachmentFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// newStorageKey picks an unguessable key for a new upload
+func newStorageKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "attachments/" + hex.EncodeToString(b)
+}
+
+// UploadAttachment stores the "file" of a multipart form for the current user
+// to attach to a post, comment or message they create next
+func (h *Handler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if h.rejectMuted(w, userID) {
+		return
+	}
+
+	limits := h.Attachments
+	r.Body = http.MaxBytesReader(w, r.Body, limits.MaxBytes+multipartOverheadBytes)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart form", http.StatusBadRequest)
+		return
+	}
+	var filename string
+	var data []byte
+	for data == nil {
+		part, err := reader.NextPart()
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, "Missing file", http.StatusBadRequest)
+			}
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		filename = attachmentFilename(part.FileName())
+		data, err = io.ReadAll(io.LimitReader(part, limits.MaxBytes+1))
+		if err != nil {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	if len(data) == 0 {
+		http.Error(w, "The file is empty", http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > limits.MaxBytes {
+		http.Error(w, fmt.Sprintf("Files are limited to %d bytes", limits.MaxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	contentType, ok := attachments.Sniff(data)
+	if !ok {
+		http.Error(w, "Unsupported file type: images, PDF, ZIP and plain text are accepted", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Checked again when the attachment is recorded, but most uploads over
+	// quota are turned away here before their files are stored
+	used, err := h.DB.AttachmentUsage(userID)
+	if err != nil {
+		attachmentError(w, err)
+		return
+	}
+	if used+int64(len(data)) > limits.Quota {
+		quotaExceeded(w, limits.Quota)
+		return
+	}
+
+	a := database.Attachment{
+		UserID:      userID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		StorageKey:  newStorageKey(),
+	}
+	if err := h.Storage.Put(a.StorageKey, bytes.NewReader(data), a.Size, contentType); err != nil {
+		attachmentError(w, err)
+		return
+	}
+	if attachments.IsImage(contentType) {
+		if thumb, width, height, ok := attachments.Thumbnail(data, limits.MaxDimension); ok {
+			a.ThumbnailKey, a.Width, a.Height = a.StorageKey+"-thumb", width, height
+			err := h.Storage.Put(a.ThumbnailKey, bytes.NewReader(thumb), int64(len(thumb)), attachments.ThumbnailType(contentType))
+			if err != nil {
+				attachmentError(w, err)
+				return
+			}
+		}
+	}
+	if err := h.DB.CreateAttachment(&a, limits.Quota); err != nil {
+		// Without a record the collector would never find the files
+		for _, key := range []string{a.StorageKey, a.ThumbnailKey} {
+			if key == "" {
+				continue
+			}
+			if err := h.Storage.Delete(key); err != nil {
+				log.Printf("Error deleting unrecorded upload %s: %v", key, err)
+			}
+		}
+		if errors.Is(err, database.ErrQuotaExceeded) {
+			quotaExceeded(w, limits.Quota)
+		} else {
+			attachmentError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// checkAttachments validates the uploads a request wants to attach, writing
+// the error response when they cannot be
+func (h *Handler) checkAttachments(w http.ResponseWriter, userID int, ids []int) ([]int, bool) {
+	ids, err := h.DB.CheckAttachments(userID, ids)
+	if err != nil {
+		attachmentError(w, err)
+		return nil, false
+	}
+	return ids, true
+}
+
+// linkAttachments attaches checked uploads to new content. The content is
+// already saved, so failures are only logged.
+func (h *Handler) linkAttachments(userID int, ids []int, targetType string, targetID int) {
+	if err := h.DB.LinkAttachments(userID, ids, targetType, targetID); err != nil {
+		log.Printf("Error linking attachments to %s %d: %v", targetType, targetID, err)
+	}
+}
+
+// canAccessAttachment tells whether the requester may download an
+// attachment: the uploader always, anyone who can see the post or comment
+// it is attached to, and only the members of the conversation for messages
+func (h *Handler) canAccessAttachment(r *http.Request, a *database.Attachment) bool {
+	viewerID, _ := h.authenticate(r)
+	if viewerID != 0 && viewerID == a.UserID {
+		return true
+	}
+
+	postID := a.TargetID
+	switch a.TargetType {
+	case database.TargetMessage:
+		message, err := h.DB.GetMessageByID(a.TargetID)
+		// Held or hidden messages stay visible to the moderators reviewing them
+		if err != nil || viewerID == 0 || message.Deleted || (message.Hidden && !h.canReview(r, 0)) {
+			return false
+		}
+		member, err := h.DB.IsConversationMember(message.ConversationID, viewerID)
+		return err == nil && member
+	case database.TargetComment:
+		// Hidden comments stay visible to the moderators reviewing them
+		comment, err := h.DB.GetReportTarget(database.TargetComment, a.TargetID)
+		if err != nil || (comment.Hidden && !h.canReview(r, comment.CategoryID)) {
+			return false
+		}
+		postID = comment.PostID
+	case database.TargetPost:
+	default:
+		return false
+	}
+	post, err := h.DB.GetPostByID(postID)
+	if err != nil {
+		return false
+	}
+	return !post.Hidden || h.canReview(r, post.CategoryID)
+}
+
+// ServeAttachment downloads an attachment at /attachments/{id}, or its
+// thumbnail at /attachments/{id}/thumbnail
+func (h *Handler) ServeAttachment(w http.ResponseWriter, r *http.Request, idStr string, thumbnail bool) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+	a, err := h.DB.GetAttachment(id)
+	if err != nil {
+		attachmentError(w, err)
+		return
+	}
+	// Attachments the requester may not see do not exist for them
+	if !h.canAccessAttachment(r, a) {
+		attachmentError(w, database.ErrAttachmentNotFound)
+		return
+	}
+
+	key, contentType := a.StorageKey, a.ContentType
+	if thumbnail {
+		if a.ThumbnailKey == "" {
+			attachmentError(w, database.ErrAttachmentNotFound)
+			return
+		}
+		key, contentType = a.ThumbnailKey, attachments.ThumbnailType(a.ContentType)
+	}
+	disposition := "attachment"
+	if attachments.IsImage(contentType) {
+		disposition = "inline"
+	}
+	disposition = mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename})
+
+	// Let the browser fetch the file from the store itself when it can
+	if presigner, ok := h.Storage.(storage.Presigner); ok {
+		link, err := presigner.PresignGet(key, downloadLinkTTL, contentType, disposition)
+		if err != nil {
+			attachmentError(w, err)
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+		http.Redirect(w, r, link, http.StatusFound)
+		return
+	}
+
+	f, err := h.Storage.Open(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		attachmentError(w, database.ErrAttachmentNotFound)
+		return
+	}
+	if err != nil {
+		attachmentError(w, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	// Access depends on who asks, so only the browser may cache
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if !thumbnail {
+		w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	}
+	io.Copy(w, f)
+}
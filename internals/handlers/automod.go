@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real-time-forum/internals/database"
+)
+
+// automodLogLimit caps the number of rule matches returned at once
+const automodLogLimit = 100
+
+// heldMessage tells authors their content is waiting for a moderator
+const heldMessage = "Submitted and held for review by a moderator"
+
+type AutomodRuleResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	RuleID  int    `json:"ruleId,omitempty"`
+}
+
+// applyAutomod runs the content rules on new content. It writes the error
+// response itself when the content is rejected and reports whether to continue.
+func (h *Handler) applyAutomod(w http.ResponseWriter, in database.AutomodInput) (*database.AutomodVerdict, bool) {
+	verdict, err := h.DB.CheckContent(in)
+	if err != nil {
+		log.Printf("Error checking content rules: %v", err)
+		http.Error(w, "Failed to check content", http.StatusInternalServerError)
+		return nil, false
+	}
+	if verdict.Action == database.AutomodReject {
+		http.Error(w, "Your content was rejected by the content filter", http.StatusBadRequest)
+		return nil, false
+	}
+	return verdict, true
+}
+
+// automodError maps database errors from rule management to HTTP responses
+func automodError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrInvalidAutomodRule):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrAutomodRuleNotFound):
+		http.Error(w, "Rule not found", http.StatusNotFound)
+	default:
+		log.Printf("Error managing automod rules: %v", err)
+		http.Error(w, "Failed to update rules", http.StatusInternalServerError)
+	}
+}
+
+// GetAutomodRules lists every content rule (requires automod.manage)
+func (h *Handler) GetAutomodRules(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requirePermission(w, r, database.PermAutomodManage, 0); !ok {
+		return
+	}
+
+	rules, err := h.DB.ListAutomodRules()
+	if err != nil {
+		automodError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// CreateAutomodRule adds a content rule, effective immediately (requires automod.manage)
+func (h *Handler) CreateAutomodRule(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requirePermission(w, r, database.PermAutomodManage, 0); !ok {
+		return
+	}
+
+	rule := database.AutomodRule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ruleID, err := h.DB.CreateAutomodRule(rule)
+	if err != nil {
+		automodError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(AutomodRuleResponse{Success: true, Message: "Rule created", RuleID: ruleID})
+}
+
+// UpdateAutomodRule replaces a content rule, effective immediately (requires automod.manage)
+func (h *Handler) UpdateAutomodRule(w http.ResponseWriter, r *http.Request, ruleIDStr string) {
+	if _, ok := h.requirePermission(w, r, database.PermAutomodManage, 0); !ok {
+		return
+	}
+
+	ruleID, err := strconv.Atoi(ruleIDStr)
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	var rule database.AutomodRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.UpdateAutomodRule(ruleID, rule); err != nil {
+		automodError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AutomodRuleResponse{Success: true, Message: "Rule updated", RuleID: ruleID})
+}
+
+// DeleteAutomodRule removes a content rule (requires automod.manage)
+func (h *Handler) DeleteAutomodRule(w http.ResponseWriter, r *http.Request, ruleIDStr string) {
+	if _, ok := h.requirePermission(w, r, database.PermAutomodManage, 0); !ok {
+		return
+	}
+
+	ruleID, err := strconv.Atoi(ruleIDStr)
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.DeleteAutomodRule(ruleID); err != nil {
+		automodError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AutomodRuleResponse{Success: true, Message: "Rule deleted"})
+}
+
+// GetAutomodLog lists recent rule matches, including dry runs (requires automod.manage)
+func (h *Handler) GetAutomodLog(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requirePermission(w, r, database.PermAutomodManage, 0); !ok {
+		return
+	}
+
+	entries, err := h.DB.GetAutomodLog(automodLogLimit)
+	if err != nil {
+		automodError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
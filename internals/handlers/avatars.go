@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"real-time-forum/internals/avatar"
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/imaging"
+)
+
+// Cache lifetimes of avatar responses. Stored avatars never change under
+// their URL; a user's current avatar may change at any time.
+const (
+	avatarCacheControl     = "public, max-age=31536000, immutable"
+	userAvatarCacheControl = "public, max-age=300"
+	multipartOverheadBytes = 64 << 10
+)
+
+type AvatarResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	AvatarURL string `json:"avatarUrl"`
+}
+
+// avatarURL is where a user's avatar is served: the uploaded one when there
+// is one, or else their identicon
+func avatarURL(nickname, key string) string {
+	if key != "" {
+		return fmt.Sprintf("/api/avatars/%s/%d", key, avatar.DefaultSize)
+	}
+	return fmt.Sprintf("/api/users/%s/avatar?size=%d", url.PathEscape(nickname), avatar.DefaultSize)
+}
+
+// avatarSize reads ?size=, which must be one of the thumbnail sizes
+func avatarSize(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("size")
+	if v == "" {
+		return avatar.DefaultSize, nil
+	}
+	size, err := strconv.Atoi(v)
+	if err != nil || !slices.Contains(avatar.Sizes, size) {
+		return 0, fmt.Errorf("Invalid size, must be one of %v", avatar.Sizes)
+	}
+	return size, nil
+}
+
+// UploadAvatar replaces the current user's avatar with the "avatar" file of a
+// multipart form
+func (h *Handler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.Avatars.Config().MaxBytes+multipartOverheadBytes)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart form", http.StatusBadRequest)
+		return
+	}
+	var key string
+	for key == "" {
+		part, err := reader.NextPart()
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Image too large", http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, "Missing avatar file", http.StatusBadRequest)
+			}
+			return
+		}
+		if part.FormName() != "avatar" {
+			continue
+		}
+		key, err = h.Avatars.Save(part)
+		switch {
+		case errors.Is(err, imaging.ErrTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case errors.Is(err, imaging.ErrUnsupported):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		case err != nil:
+			log.Printf("Error saving avatar: %v", err)
+			http.Error(w, "Failed to save avatar", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if !h.setAvatar(w, userID, key) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AvatarResponse{Success: true, Message: "Avatar updated", AvatarURL: avatarURL("", key)})
+}
+
+// DeleteAvatar brings back the current user's identicon
+func (h *Handler) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		profileError(w, database.ErrUserNotFound)
+		return
+	}
+
+	if !h.setAvatar(w, userID, "") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AvatarResponse{Success: true, Message: "Avatar removed", AvatarURL: avatarURL(user.Nickname, "")})
+}
+
+// setAvatar stores a user's new avatar key and removes the files of the old
+// one once nobody uses them
+func (h *Handler) setAvatar(w http.ResponseWriter, userID int, key string) bool {
+	previous, err := h.DB.SetAvatar(userID, key)
+	if err != nil {
+		profileError(w, err)
+		return false
+	}
+	if previous == "" || previous == key {
+		return true
+	}
+	inUse, err := h.DB.AvatarInUse(previous)
+	if err != nil {
+		log.Printf("Error checking avatar: %v", err)
+	} else if !inUse {
+		if err := h.Avatars.Remove(previous); err != nil {
+			log.Printf("Error removing avatar: %v", err)
+		}
+	}
+	return true
+}
+
+// GetUserAvatar redirects to a user's current avatar at ?size=, or draws
+// their identicon when they have not uploaded one
+func (h *Handler) GetUserAvatar(w http.ResponseWriter, r *http.Request, nickname string) {
+	size, err := avatarSize(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID, key, err := h.DB.GetAvatar(nickname)
+	if err != nil {
+		profileError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", userAvatarCacheControl)
+	if key != "" {
+		http.Redirect(w, r, fmt.Sprintf("/api/avatars/%s/%d", key, size), http.StatusFound)
+		return
+	}
+	// Identicons follow the account, not the nickname, which may change
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(avatar.Identicon("user:"+strconv.Itoa(userID), size))
+}
+
+// ServeAvatar serves a stored avatar thumbnail at /avatars/{key}/{size}
+func (h *Handler) ServeAvatar(w http.ResponseWriter, r *http.Request, path string) {
+	key, sizeStr, _ := strings.Cut(path, "/")
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+	etag := fmt.Sprintf(`"%s-%d"`, key, size)
+	if r.Header.Get("If-None-Match") == etag {
+		w.Header().Set("Cache-Control", avatarCacheControl)
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	f, err := h.Avatars.Open(key, size)
+	if errors.Is(err, avatar.ErrNotFound) {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening avatar: %v", err)
+		http.Error(w, "Failed to load avatar", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", avatarCacheControl)
+	w.Header().Set("ETag", etag)
+	io.Copy(w, f)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/realtime"
+)
+
+type BlockResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// blockError maps database errors from blocks to HTTP responses
+func blockError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrBlockSelf):
+		http.Error(w, "You cannot block or mute yourself", http.StatusBadRequest)
+	case errors.Is(err, database.ErrBlockNotFound):
+		http.Error(w, "Block not found", http.StatusNotFound)
+	default:
+		log.Printf("Error managing blocks: %v", err)
+		http.Error(w, "Failed to update block", http.StatusInternalServerError)
+	}
+}
+
+// SetBlock blocks or mutes a user by nickname for the current user,
+// replacing a mute with a block and the other way around
+func (h *Handler) SetBlock(w http.ResponseWriter, r *http.Request, nickname, level string) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	profile, err := h.DB.GetProfile(nickname)
+	if err != nil {
+		blockError(w, err)
+		return
+	}
+	if err := h.DB.SetBlock(userID, profile.ID, level); err != nil {
+		blockError(w, err)
+		return
+	}
+
+	message := "Blocked " + profile.Nickname
+	if level == database.BlockLevelMute {
+		message = "Muted " + profile.Nickname
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BlockResponse{Success: true, Message: message})
+}
+
+// DeleteBlock lifts a block or mute of a user by nickname
+func (h *Handler) DeleteBlock(w http.ResponseWriter, r *http.Request, nickname, level string) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	profile, err := h.DB.GetProfile(nickname)
+	if err != nil {
+		blockError(w, err)
+		return
+	}
+	if err := h.DB.DeleteBlock(userID, profile.ID, level); err != nil {
+		blockError(w, err)
+		return
+	}
+
+	message := "Unblocked " + profile.Nickname
+	if level == database.BlockLevelMute {
+		message = "Unmuted " + profile.Nickname
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BlockResponse{Success: true, Message: message})
+}
+
+// GetBlocks lists the users the current user blocked or muted
+func (h *Handler) GetBlocks(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	blocks, err := h.DB.GetBlocks(userID)
+	if err != nil {
+		blockError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blocks)
+}
+
+// publishFrom pushes an event caused by a user to another user's sessions,
+// unless either of them blocked the other
+func (h *Handler) publishFrom(actorID, userID int, event realtime.Event) {
+	blocked, err := h.DB.Blocked(actorID, userID)
+	if err != nil {
+		log.Printf("Error checking blocks of user %d: %v", userID, err)
+		return
+	}
+	if !blocked {
+		h.Hub.Publish(userID, event)
+	}
+}
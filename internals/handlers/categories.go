@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real-time-forum/internals/database"
+)
+
+type NewCategoryRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Icon        string `json:"icon"`
+	Color       string `json:"color"`
+	ParentID    int    `json:"parentId"`
+}
+
+type ReorderCategoriesRequest struct {
+	IDs []int `json:"ids"`
+}
+
+type MergeCategoriesRequest struct {
+	TargetID int `json:"targetId"`
+}
+
+type CategoryAdminResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	CategoryID int    `json:"categoryId,omitempty"`
+	MovedPosts int    `json:"movedPosts,omitempty"`
+}
+
+func newCategoryResponse(cat database.Category) CategoryResponse {
+	return CategoryResponse{
+		ID:             cat.ID,
+		Name:           cat.Name,
+		Description:    cat.Description,
+		PostCount:      cat.PostCount,
+		TotalPostCount: cat.TotalPostCount,
+		ParentID:       cat.ParentID,
+		Position:       cat.Position,
+		Icon:           cat.Icon,
+		Color:          cat.Color,
+		Archived:       cat.Archived,
+	}
+}
+
+func sendCategoryAdminResponse(w http.ResponseWriter, statusCode int, response CategoryAdminResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// categoryAdminError maps database errors to HTTP responses
+func categoryAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrCategoryNotFound):
+		http.Error(w, "Category not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrCategoryExists):
+		http.Error(w, "Category name already exists", http.StatusConflict)
+	case errors.Is(err, database.ErrCategoryCycle),
+		errors.Is(err, database.ErrDuplicateOrder),
+		errors.Is(err, database.ErrUnknownSetting),
+		errors.Is(err, database.ErrInvalidSetting):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error managing category: %v", err)
+		http.Error(w, "Failed to update category", http.StatusInternalServerError)
+	}
+}
+
+// CreateCategory adds a new category (requires category.manage)
+func (h *Handler) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requirePermission(w, r, database.PermCategoryManage, 0); !ok {
+		return
+	}
+
+	var req NewCategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Category name is required", http.StatusBadRequest)
+		return
+	}
+
+	id, err := h.DB.CreateCategory(req.Name, req.Description, req.Icon, req.Color, req.ParentID)
+	if err != nil {
+		categoryAdminError(w, err)
+		return
+	}
+
+	sendCategoryAdminResponse(w, http.StatusCreated, CategoryAdminResponse{Success: true, Message: "Category created", CategoryID: id})
+}
+
+// UpdateCategory renames, describes, restyles or archives a category (requires category.manage)
+func (h *Handler) UpdateCategory(w http.ResponseWriter, r *http.Request, categoryIDStr string) {
+	if _, ok := h.requirePermission(w, r, database.PermCategoryManage, 0); !ok {
+		return
+	}
+
+	categoryID, err := strconv.Atoi(categoryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var update database.CategoryUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if name == "" {
+			http.Error(w, "Category name cannot be empty", http.StatusBadRequest)
+			return
+		}
+		update.Name = &name
+	}
+
+	if err := h.DB.UpdateCategory(categoryID, update); err != nil {
+		categoryAdminError(w, err)
+		return
+	}
+
+	sendCategoryAdminResponse(w, http.StatusOK, CategoryAdminResponse{Success: true, Message: "Category updated", CategoryID: categoryID})
+}
+
+// ReorderCategories sets the display order of categories (requires category.manage)
+func (h *Handler) ReorderCategories(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requirePermission(w, r, database.PermCategoryManage, 0); !ok {
+		return
+	}
+
+	var req ReorderCategoriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.IDs) == 0 {
+		http.Error(w, "A list of category IDs is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.ReorderCategories(req.IDs); err != nil {
+		categoryAdminError(w, err)
+		return
+	}
+
+	sendCategoryAdminResponse(w, http.StatusOK, CategoryAdminResponse{Success: true, Message: "Categories reordered"})
+}
+
+// MergeCategory moves all posts of a category into another one and deletes it (requires category.manage)
+func (h *Handler) MergeCategory(w http.ResponseWriter, r *http.Request, categoryIDStr string) {
+	if _, ok := h.requirePermission(w, r, database.PermCategoryManage, 0); !ok {
+		return
+	}
+
+	sourceID, err := strconv.Atoi(categoryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var req MergeCategoriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TargetID == 0 {
+		http.Error(w, "Target category is required", http.StatusBadRequest)
+		return
+	}
+	if req.TargetID == sourceID {
+		http.Error(w, "Cannot merge a category into itself", http.StatusBadRequest)
+		return
+	}
+
+	moved, err := h.DB.MergeCategories(sourceID, req.TargetID)
+	if err != nil {
+		categoryAdminError(w, err)
+		return
+	}
+
+	sendCategoryAdminResponse(w, http.StatusOK, CategoryAdminResponse{
+		Success:    true,
+		Message:    "Categories merged",
+		CategoryID: req.TargetID,
+		MovedPosts: moved,
+	})
+}
+
+// GetCategorySettings returns the effective settings of a category, noting
+// which ones are inherited from a parent
+func (h *Handler) GetCategorySettings(w http.ResponseWriter, r *http.Request, categoryIDStr string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	categoryID, err := strconv.Atoi(categoryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := h.DB.GetEffectiveCategorySettings(categoryID)
+	if err != nil {
+		categoryAdminError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// UpdateCategorySettings overrides settings on a category (requires category.manage).
+// The body maps setting names to values; an empty value restores inheritance.
+func (h *Handler) UpdateCategorySettings(w http.ResponseWriter, r *http.Request, categoryIDStr string) {
+	if _, ok := h.requirePermission(w, r, database.PermCategoryManage, 0); !ok {
+		return
+	}
+
+	categoryID, err := strconv.Atoi(categoryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.DB.GetCategoryByID(categoryID); err != nil {
+		categoryAdminError(w, err)
+		return
+	}
+
+	var settings map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.SetCategorySettings(categoryID, settings); err != nil {
+		categoryAdminError(w, err)
+		return
+	}
+
+	sendCategoryAdminResponse(w, http.StatusOK, CategoryAdminResponse{Success: true, Message: "Category settings updated", CategoryID: categoryID})
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/ratelimit"
+	"real-time-forum/internals/realtime"
+)
+
+// SendMessageRequest is the chat payload; the frontend may send recipientId
+// as a number or a numeric string
+type SendMessageRequest struct {
+	RecipientID json.Number `json:"recipientId"`
+	Content     string      `json:"content"`
+
+	Attachments []int `json:"attachments"` // IDs of the user's uploads to attach
+}
+
+type SendMessageResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	MessageID int    `json:"messageId,omitempty"`
+}
+
+// GetChatMessages returns the direct conversation with ?recipientId=
+func (h *Handler) GetChatMessages(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	recipientID, err := strconv.Atoi(r.URL.Query().Get("recipientId"))
+	if err != nil {
+		http.Error(w, "Invalid recipient ID", http.StatusBadRequest)
+		return
+	}
+
+	messages := []database.Message{}
+	conversationID, err := h.DB.DirectConversation(userID, recipientID, false)
+	if err == nil {
+		messages, err = h.DB.GetMessages(conversationID, userID, 0, 0)
+	}
+	if err != nil && !errors.Is(err, database.ErrConversationNotFound) {
+		log.Printf("Error retrieving messages: %v", err)
+		http.Error(w, "Failed to retrieve messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// SendChatMessage stores a private message to another user, starting their
+// direct conversation if needed
+func (h *Handler) SendChatMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if h.rejectMuted(w, userID) {
+		return
+	}
+
+	var req SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	recipientID, err := strconv.Atoi(req.RecipientID.String())
+	if err != nil || recipientID == userID {
+		http.Error(w, "Invalid recipient ID", http.StatusBadRequest)
+		return
+	}
+	content := strings.TrimSpace(req.Content)
+	// A message may be only attachments
+	if content == "" && len(req.Attachments) == 0 {
+		http.Error(w, "Message content is required", http.StatusBadRequest)
+		return
+	}
+	attachmentIDs, ok := h.checkAttachments(w, userID, req.Attachments)
+	if !ok {
+		return
+	}
+	if !h.canMessage(w, userID, recipientID) {
+		return
+	}
+
+	conversationID, err := h.DB.DirectConversation(userID, recipientID, true)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "Recipient not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error starting conversation: %v", err)
+		http.Error(w, "Failed to send message", http.StatusInternalServerError)
+		return
+	}
+	h.postMessage(w, r, userID, conversationID, database.ConversationDirect, content, attachmentIDs)
+}
+
+// canMessage checks that a user may write to another directly: neither
+// blocked the other and the recipient accepts their messages
+func (h *Handler) canMessage(w http.ResponseWriter, userID, recipientID int) bool {
+	blocked, err := h.DB.Blocked(userID, recipientID)
+	if err != nil {
+		log.Printf("Error checking blocks: %v", err)
+		http.Error(w, "Failed to send message", http.StatusInternalServerError)
+		return false
+	}
+	if blocked {
+		http.Error(w, "You cannot message this user", http.StatusForbidden)
+		return false
+	}
+	allowed, err := h.DB.PrivacyAllows(recipientID, userID, database.UserSettingAllowMessages)
+	if err != nil {
+		log.Printf("Error checking message settings: %v", err)
+		http.Error(w, "Failed to send message", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		http.Error(w, "This user does not accept messages from you", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// postMessage stores a message from a member of a conversation and pushes
+// it to the other members. Everything but the sender's membership has been
+// checked by the caller.
+func (h *Handler) postMessage(w http.ResponseWriter, r *http.Request, userID, conversationID int, kind, content string, attachmentIDs []int) {
+	if !h.rateLimit(w, r, ratelimit.ActionMessage, userID) {
+		return
+	}
+
+	verdict, ok := h.applyAutomod(w, database.AutomodInput{
+		TargetType: database.TargetMessage,
+		AuthorID:   userID,
+		Content:    content,
+	})
+	if !ok {
+		return
+	}
+
+	messageID, err := h.DB.CreateMessage(conversationID, userID, verdict.Content, verdict.HoldRule())
+	if errors.Is(err, database.ErrConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error sending message: %v", err)
+		http.Error(w, "Failed to send message", http.StatusInternalServerError)
+		return
+	}
+
+	h.linkAttachments(userID, attachmentIDs, database.TargetMessage, messageID)
+	response := SendMessageResponse{Success: true, Message: "Message sent", MessageID: messageID}
+	held := verdict.HoldRule() != nil
+	members, err := h.DB.ConversationMemberIDs(conversationID)
+	if err != nil {
+		log.Printf("Error loading members of conversation %d: %v", conversationID, err)
+	}
+	// Only members can read the message, so nobody else hears of mentions in it
+	h.recordMentions(database.TargetMessage, messageID, userID, verdict.Content, held, func(m database.Mention) bool {
+		return slices.Contains(members, m.UserID)
+	})
+	if held {
+		response.Message = heldMessage
+	} else if message, err := h.DB.GetMessageByID(messageID); err == nil {
+		h.publishToMembers(userID, kind, members, realtime.Event{Type: EventMessage, Data: message})
+	} else {
+		log.Printf("Error loading sent message: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// publishToMembers pushes an event caused by a member of a conversation to
+// the other members. Outside direct conversations, members who muted the
+// actor do not see what they say and are skipped too.
+func (h *Handler) publishToMembers(actorID int, kind string, members []int, event realtime.Event) {
+	ignoring := map[int]bool{}
+	if kind != database.ConversationDirect {
+		var err error
+		if ignoring, err = h.DB.IgnoringUsers(actorID); err != nil {
+			log.Printf("Error loading users ignoring user %d: %v", actorID, err)
+		}
+	}
+	for _, memberID := range members {
+		if memberID != actorID && !ignoring[memberID] {
+			h.publishFrom(actorID, memberID, event)
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/realtime"
+)
+
+// Pages of conversation history
+const (
+	defaultMessagePageSize = 50
+	maxMessagePageSize     = 200
+)
+
+// CreateConversationRequest starts a direct conversation with UserID, or a
+// group with a name and members
+type CreateConversationRequest struct {
+	UserID  int    `json:"userId"`
+	Name    string `json:"name"`
+	Members []int  `json:"members"`
+}
+
+type ConversationRequest struct {
+	Name string `json:"name"`
+}
+
+type ConversationMemberRequest struct {
+	UserID int    `json:"userId"`
+	Role   string `json:"role"`
+}
+
+type ConversationMessageRequest struct {
+	Content     string `json:"content"`
+	Attachments []int  `json:"attachments"` // IDs of the user's uploads to attach
+}
+
+type MarkReadRequest struct {
+	MessageID int `json:"messageId"` // 0 marks everything read
+}
+
+type ConversationMessagesResponse struct {
+	Messages []database.Message `json:"messages"`
+	HasMore  bool               `json:"hasMore"`
+}
+
+type ConversationResponse struct {
+	Success      bool                   `json:"success"`
+	Message      string                 `json:"message"`
+	Conversation *database.Conversation `json:"conversation,omitempty"`
+}
+
+type UnreadMessagesResponse struct {
+	Count int `json:"count"`
+}
+
+// conversationError maps database errors from conversations to HTTP responses
+func conversationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrConversationNotFound):
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrInvalidConversation):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrChatForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, database.ErrAlreadyMember):
+		http.Error(w, "Already a member of this conversation", http.StatusConflict)
+	case errors.Is(err, database.ErrNotMember):
+		http.Error(w, "Not a member of this conversation", http.StatusNotFound)
+	case errors.Is(err, database.ErrNotBanned):
+		http.Error(w, "Not banned from this conversation", http.StatusNotFound)
+	case errors.Is(err, database.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrCategoryNotFound):
+		http.Error(w, "Category not found", http.StatusNotFound)
+	default:
+		log.Printf("Error managing conversations: %v", err)
+		http.Error(w, "Failed to process conversation", http.StatusInternalServerError)
+	}
+}
+
+// conversationFor authenticates the request and loads the conversation with
+// the given ID as the current user sees it
+func (h *Handler) conversationFor(w http.ResponseWriter, r *http.Request, conversationIDStr string) (int, *database.Conversation, bool) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, nil, false
+	}
+	conversationID, err := strconv.Atoi(conversationIDStr)
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return 0, nil, false
+	}
+	conversation, err := h.DB.GetConversation(conversationID, userID)
+	if err != nil {
+		conversationError(w, err)
+		return 0, nil, false
+	}
+	return userID, conversation, true
+}
+
+// requireMember rejects users who have not joined a room
+func requireMember(w http.ResponseWriter, conversation *database.Conversation) bool {
+	if conversation.Role == "" {
+		http.Error(w, "Join the room first", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ListConversations lists the current user's conversations, most recently
+// active first
+func (h *Handler) ListConversations(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversations, err := h.DB.ListConversations(userID)
+	if err != nil {
+		conversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversations)
+}
+
+// CreateConversation opens the direct conversation with a user, or starts a
+// group owned by the current user
+func (h *Handler) CreateConversation(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var conversationID int
+	if req.UserID != 0 {
+		if req.UserID == userID {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if !h.canMessage(w, userID, req.UserID) {
+			return
+		}
+		conversationID, err = h.DB.DirectConversation(userID, req.UserID, true)
+	} else {
+		for _, memberID := range req.Members {
+			if memberID != userID && !h.canMessage(w, userID, memberID) {
+				return
+			}
+		}
+		conversationID, err = h.DB.CreateGroup(userID, req.Name, req.Members)
+	}
+	if err != nil {
+		conversationError(w, err)
+		return
+	}
+
+	conversation, err := h.DB.GetConversation(conversationID, userID)
+	if err != nil {
+		conversationError(w, err)
+		return
+	}
+	h.publishConversation(userID, conversation.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ConversationResponse{Success: true, Message: "Conversation created", Conversation: conversation})
+}
+
+// GetConversation returns a conversation with its members
+func (h *Handler) GetConversation(w http.ResponseWriter, r *http.Request, conversationIDStr string) {
+	_, conversation, ok := h.conversationFor(w, r, conversationIDStr)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// RenameConversation renames a group or room
+func (h *Handler) RenameConversation(w http.ResponseWriter, r *http.Request, conversationIDStr string) {
+	userID, conversation, ok := h.conversationFor(w, r, conversationIDStr)
+	if !ok {
+		return
+	}
+
+	var req ConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := h.DB.RenameConversation(userID, conversation.ID, req.Name); err != nil {
+		conversationError(w, err)
+		return
+	}
+	h.publishConversation(userID, conversation.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConversationResponse{Success: true, Message: "Conversation renamed"})
+}
+
+// GetConversationMessages returns a page of a conversation's history, newest
+// last, before ?before= when given. Reading the latest page marks the
+// conversation read.
+func (h *Handler) GetConversationMessages(w http.ResponseWriter, r *http.Request, conversationIDStr string) {
+	userID, conversation, ok := h.conversationFor(w, r, conversationIDStr)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	beforeID, limit := 0, defaultMessagePageSize
+	var err error
+	if v := query.Get("before"); v != "" {
+		if beforeID, err = strconv.Atoi(v); err != nil || beforeID < 1 {
+			http.Error(w, "Invalid message ID", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxMessagePageSize {
+			http.Error(w, "Invalid limit, must be between 1 and "+strconv.Itoa(maxMessagePageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// One extra message tells whether there is more history
+	messages, err := h.DB.GetMessages(conversation.ID, userID, beforeID, limit+1)
+	if err != nil {
+		conversationError(w, err)
+		return
+	}
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[1:]
+	}
+	if beforeID == 0 && conversation.Role != "" {
+		if err := h.DB.MarkConversationRead(userID, conversation.ID, 0); err != nil {
+			log.Printf("Error marking conversation %d read: %v", conversation.ID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConversationMessagesResponse{Messages: messages, HasMore: hasMore})
+}
+
+// SendConversationMessage posts a message to a conversation the current
+// user is a member of
+func (h *Handler) SendConversationMessage(w http.ResponseWriter, r *http.Request, conversationIDStr string) {
+	userID, conversation, ok := h.conversationFor(w, r, conversationIDStr)
+	if !ok || !requireMember(w, conversation) || h.rejectMuted(w, userID) {
+		return
+	}
+
+	var req ConversationMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	content := strings.TrimSpace(req.Content)
+	// A message may be only attachments
+	if content == "" && len(req.Attachments) == 0 {
+		http.Error(w, "Message content is required", http.StatusBadRequest)
+		return
+	}
+	attachmentIDs, ok := h.checkAttachments(w, userID, req.Attachments)
+	if !ok {
+		return
+	}
+	if conversation.Kind == database.ConversationDirect {
+		for _, m := range conversation.Members {
+			if m.UserID != userID && !h.canMessage(w, userID, m.UserID) {
+				return
+			}
+		}
+	}
+	h.postMessage(w, r, userID, conversation.ID, conversation.Kind, content, attachmentIDs)
+}
+
+// MarkConversationRead moves the current user's read marker in a
+// conversation up to a message, or to the latest one
+func (h *Handler) MarkConversationRead(w http.ResponseWriter, r *http.Request, conversationIDStr string) {
+	userID, conversation, ok := h.conversationFor(w, r, conversationIDStr)
+	if !ok || !requireMember(w, conversation) {
+		return
+	}
+
+	var req MarkReadRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID < 0 {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := h.DB.MarkConversationRead(userID, conversation.ID, req.MessageID); err != nil {
+		conversationError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SendTyping tells the other members of a conversation the current user is
+// typing
+func (h *Handler) SendTyping(w http.ResponseWriter, r *http.Request, conversationIDStr string) {
+	userID, conversation, ok := h.conversationFor(w, r, conversationIDStr)
+	if !ok || !requireMember(w, conversation) {
+		return
+	}
+
+	members, err := h.DB.ConversationMemberIDs(conversation.ID)
+	if err != nil {
+		conversationError(w, err)
+		return
+	}
+	h.publishToMembers(userID, conversation.Kind, members, realtime.Event{
+		Type:      EventTyping,
+		Data:      map[string]int{"conversationId": conversation.ID, "userId": userID},
+		Transient: true,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// JoinRoom makes the current user a member of a room
+func (h *Handler) JoinRoom(w http.ResponseWriter, r *http.Request, conversationIDStr string) {
+	userID, conversation, ok := h.conversationFor(w, r, conversationIDStr)
+	if !ok {
+		return
+	}
+	if err := h.DB.JoinRoom(userID, conversation.ID); err != nil {
+		conversationError(w, err)
+		return
+	}
+	h.publishConversation(userID, conversation.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConversationResponse{Success: true, Message: "Joined " + conversation.Name})
+}
+
+// AddConversationMember invites a user into a group
+func (h *Handler) AddConversationMember(w http.ResponseWriter, r *http.Request, conversationIDStr string) {
+	userID, conversation, ok := h.conversationFor(w, r, conversationIDStr)
+	if !ok {
+		return
+	}
+
+	var req ConversationMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID != userID && !h.canMessage(w, userID, req.UserID) {
+		return
+	}
+	if err := h.DB.AddMember(userID, conversation.ID, req.UserID); err != nil {
+		conversationError(w, err)
+		return
+	}
+	h.publishConversation(userID, conversation.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ConversationResponse{Success: true, Message: "Member added"})
+}
+
+// RemoveConversationMember takes a member out of a group or room. Removing
+// oneself leaves the conversation.
+func (h *Handler) RemoveConversationMember(w http.ResponseWriter, r *http.Request, conversationIDStr, memberIDStr string) {
+	userID, conversation, ok := h.conversationFor(w, r, conversationIDStr)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.Atoi(memberIDStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.RemoveMember(userID, conversation.ID, memberID); err != nil {
+		conversationError(w, err)
+		return
+	}
+	// The removed member is no longer in the conversation but should hear of it
+	h.publishConversation(userID, conversation.ID, memberID)
+
+	message := "Member removed"
+	if memberID == userID {
+		message = "Left " + conversation.Name
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConversationResponse{Success: true, Message: message})
+}
+
+// UnbanConversationMember lets a user an admin removed from a room join it again
+func (h *Handler) UnbanConversationMember(w http.ResponseWriter, r *http.Request, conversationIDStr, memberIDStr string) {
+	userID, conversation, ok := h.conversationFor(w, r, conversationIDStr)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.Atoi(memberIDStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.UnbanMember(userID, conversation.ID, memberID); err != nil {
+		conversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConversationResponse{Success: true, Message: "Ban lifted"})
+}
+
+// SetConversationMemberRole changes the role of a member of a group or room
+func (h *Handler) SetConversationMemberRole(w http.ResponseWriter, r *http.Request, conversationIDStr, memberIDStr string) {
+	userID, conversation, ok := h.conversationFor(w, r, conversationIDStr)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.Atoi(memberIDStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ConversationMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := h.DB.SetMemberRole(userID, conversation.ID, memberID, req.Role); err != nil {
+		conversationError(w, err)
+		return
+	}
+	h.publishConversation(userID, conversation.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConversationResponse{Success: true, Message: "Role updated"})
+}
+
+// GetUnreadMessageCount counts the current user's unread messages across
+// their conversations
+func (h *Handler) GetUnreadMessageCount(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	count, err := h.DB.CountUnreadMessages(userID)
+	if err != nil {
+		conversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UnreadMessagesResponse{Count: count})
+}
+
+// ListRooms lists the chat rooms of a category
+func (h *Handler) ListRooms(w http.ResponseWriter, r *http.Request, categoryIDStr string) {
+	categoryID, err := strconv.Atoi(categoryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	viewerID, _ := h.authenticate(r)
+
+	rooms, err := h.DB.ListRooms(categoryID, viewerID)
+	if err != nil {
+		conversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rooms)
+}
+
+// CreateRoom opens a chat room in a category. It takes the right to manage
+// the category.
+func (h *Handler) CreateRoom(w http.ResponseWriter, r *http.Request, categoryIDStr string) {
+	categoryID, err := strconv.Atoi(categoryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	userID, ok := h.requirePermission(w, r, database.PermCategoryManage, categoryID)
+	if !ok {
+		return
+	}
+
+	var req ConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	conversationID, err := h.DB.CreateRoom(userID, categoryID, req.Name)
+	if err != nil {
+		conversationError(w, err)
+		return
+	}
+	room, err := h.DB.GetConversation(conversationID, userID)
+	if err != nil {
+		conversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ConversationResponse{Success: true, Message: "Room created", Conversation: room})
+}
+
+// publishConversation tells the members of a conversation, and any users
+// who just left it, that its name or membership changed
+func (h *Handler) publishConversation(actorID, conversationID int, formerMembers ...int) {
+	members, err := h.DB.ConversationMemberIDs(conversationID)
+	if err != nil {
+		log.Printf("Error loading members of conversation %d: %v", conversationID, err)
+		return
+	}
+	event := realtime.Event{Type: EventConversation, Data: map[string]int{"conversationId": conversationID}}
+	for _, userID := range append(members, formerMembers...) {
+		if userID != actorID {
+			h.publishFrom(actorID, userID, event)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/digest"
+)
+
+// unsubscribePage asks for confirmation before turning digests off, so link
+// scanners and prefetchers following the link do not unsubscribe anyone
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe from digests</title></head>
+<body>
+<p>Stop receiving email digests? You can turn them back on in your settings.</p>
+<form method="post" action="{{.}}">
+<button type="submit">Unsubscribe</button>
+</form>
+</body>
+</html>
+`))
+
+// verifiedUnsubscriber returns the user a signed unsubscribe link is for. It
+// writes the error response itself and reports whether to continue.
+func (h *Handler) verifiedUnsubscriber(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if len(h.DigestSecret) == 0 {
+		http.NotFound(w, r)
+		return 0, false
+	}
+
+	query := r.URL.Query()
+	userID, err := strconv.Atoi(query.Get("user"))
+	if err != nil || !digest.VerifyUnsubscribe(h.DigestSecret, userID, query.Get("sig")) {
+		http.Error(w, "Invalid unsubscribe link", http.StatusBadRequest)
+		return 0, false
+	}
+	return userID, true
+}
+
+// ConfirmUnsubscribeDigest shows the page behind the unsubscribe link in a
+// digest, whose button posts back to UnsubscribeDigest
+func (h *Handler) ConfirmUnsubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.verifiedUnsubscriber(w, r); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := unsubscribePage.Execute(w, r.URL.RequestURI()); err != nil {
+		log.Printf("Error rendering unsubscribe page: %v", err)
+	}
+}
+
+// UnsubscribeDigest turns email digests off for the user of a signed
+// unsubscribe link. It needs no session, so mail clients can use it for
+// one-click unsubscribe (RFC 8058).
+func (h *Handler) UnsubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.verifiedUnsubscriber(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.SetUserSetting(userID, database.UserSettingDigest, database.DigestOff); err != nil {
+		log.Printf("Error unsubscribing user %d from digests: %v", userID, err)
+		http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("You will no longer receive email digests. You can turn them back on in your settings.\n"))
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/realtime"
+)
+
+// heartbeatInterval keeps idle event streams from being closed by proxies
+const heartbeatInterval = 25 * time.Second
+
+// Event types pushed over the event stream
+const (
+	EventReady          = "ready"
+	EventMessage        = database.EventMessage
+	EventMessageUpdated = database.EventMessageUpdated // edited, deleted for everyone or reacted to
+	EventMessageDeleted = "message_deleted"            // deleted by the user for themselves
+	EventTyping         = "typing"                     // a member is typing in a conversation
+	EventConversation   = "conversation"               // a conversation was renamed or its members changed
+	EventDisconnected   = "disconnected"
+	EventResync         = "resync" // missed events can no longer be replayed
+)
+
+// ReadyData is the payload of ready and resync events: the sequence number
+// the client is caught up to, or has to reload its state as of
+type ReadyData struct {
+	Seq int64 `json:"seq"`
+}
+
+// StreamEvents holds a Server-Sent Events connection open and pushes the
+// user's real-time events. EventSource cannot set headers, so the token may
+// also be given as ?token=.
+//
+// Events carry their sequence number as the SSE id. A client reconnecting
+// with the last one it saw, as Last-Event-ID (which EventSource sends by
+// itself) or ?lastSeq=, first gets what it missed replayed, or a resync event
+// when that is no longer possible. The ready event then marks it caught up.
+func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+r.URL.Query().Get("token"))
+	}
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	lastSeen := r.Header.Get("Last-Event-ID")
+	if lastSeen == "" {
+		lastSeen = r.URL.Query().Get("lastSeq")
+	}
+	var lastSeq int64
+	if lastSeen != "" {
+		if lastSeq, err = strconv.ParseInt(lastSeen, 10, 64); err != nil || lastSeq < 0 {
+			http.Error(w, "Invalid last event ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Registering first means every later event is queued for the client, so
+	// nothing falls between the replay and the live stream
+	client := h.Hub.Register(userID)
+	defer h.Hub.Unregister(client)
+
+	sent := client.Seq
+	if lastSeen != "" {
+		events, ok, err := h.Hub.Replay(userID, lastSeq)
+		if err != nil {
+			log.Printf("Error replaying events of user %d: %v", userID, err)
+		}
+		if ok {
+			for _, event := range events {
+				writeEvent(w, event)
+			}
+			if len(events) > 0 {
+				sent = max(sent, events[len(events)-1].Seq)
+			}
+		} else {
+			writeEvent(w, realtime.Event{Type: EventResync, Data: ReadyData{Seq: sent}})
+		}
+	}
+	writeEvent(w, realtime.Event{Type: EventReady, Data: ReadyData{Seq: sent}})
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.Done():
+			// Deliver whatever was queued before the hub let go, such as the
+			// reason for the disconnect
+			for {
+				select {
+				case event := <-client.Events:
+					writeEvent(w, event)
+				default:
+					flusher.Flush()
+					return
+				}
+			}
+		case event := <-client.Events:
+			// Skip what the replay already covered
+			if event.Seq != 0 && event.Seq <= sent {
+				continue
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event realtime.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", event.Type, err)
+		return
+	}
+	if event.Seq != 0 {
+		fmt.Fprintf(w, "id: %d\n", event.Seq)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
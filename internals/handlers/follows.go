@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"real-time-forum/internals/database"
+)
+
+type FollowResponse struct {
+	Success       bool   `json:"success"`
+	Message       string `json:"message"`
+	FollowerCount int    `json:"followerCount"`
+}
+
+type FollowListResponse struct {
+	Users   []database.FollowUser `json:"users"`
+	Page    int                   `json:"page"`
+	HasMore bool                  `json:"hasMore"`
+}
+
+// followError maps database errors from follows to HTTP responses
+func followError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrFollowSelf):
+		http.Error(w, "You cannot follow yourself", http.StatusBadRequest)
+	case errors.Is(err, database.ErrNotFollowing):
+		http.Error(w, "You do not follow this user", http.StatusNotFound)
+	default:
+		log.Printf("Error managing follows: %v", err)
+		http.Error(w, "Failed to update follow", http.StatusInternalServerError)
+	}
+}
+
+// FollowUser makes the current user follow a user by nickname
+func (h *Handler) FollowUser(w http.ResponseWriter, r *http.Request, nickname string) {
+	h.changeFollow(w, r, nickname, true)
+}
+
+// UnfollowUser stops the current user from following a user by nickname
+func (h *Handler) UnfollowUser(w http.ResponseWriter, r *http.Request, nickname string) {
+	h.changeFollow(w, r, nickname, false)
+}
+
+func (h *Handler) changeFollow(w http.ResponseWriter, r *http.Request, nickname string, follow bool) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	profile, err := h.DB.GetProfile(nickname)
+	if err != nil {
+		followError(w, err)
+		return
+	}
+
+	message := "Following " + profile.Nickname
+	if follow {
+		var blocked bool
+		if blocked, err = h.DB.Blocked(userID, profile.ID); err != nil {
+			followError(w, err)
+			return
+		}
+		if blocked {
+			http.Error(w, "You cannot follow this user", http.StatusForbidden)
+			return
+		}
+		err = h.DB.Follow(userID, profile.ID)
+	} else {
+		err = h.DB.Unfollow(userID, profile.ID)
+		message = "Unfollowed " + profile.Nickname
+	}
+	if err != nil {
+		followError(w, err)
+		return
+	}
+
+	profile, err = h.DB.GetProfile(profile.Nickname)
+	if err != nil {
+		followError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FollowResponse{Success: true, Message: message, FollowerCount: profile.FollowerCount})
+}
+
+// GetFollowers lists a page of the users following a user
+func (h *Handler) GetFollowers(w http.ResponseWriter, r *http.Request, nickname string) {
+	h.listFollows(w, r, nickname, h.DB.ListFollowers)
+}
+
+// GetFollowing lists a page of the users a user follows
+func (h *Handler) GetFollowing(w http.ResponseWriter, r *http.Request, nickname string) {
+	h.listFollows(w, r, nickname, h.DB.ListFollowing)
+}
+
+func (h *Handler) listFollows(w http.ResponseWriter, r *http.Request, nickname string,
+	list func(userID, limit, offset int) ([]database.FollowUser, error)) {
+	page, limit, offset, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	profile, err := h.DB.GetProfile(nickname)
+	if err != nil {
+		followError(w, err)
+		return
+	}
+
+	// One extra user tells whether there is a next page
+	users, err := list(profile.ID, limit+1, offset)
+	if err != nil {
+		followError(w, err)
+		return
+	}
+	hasMore := len(users) > limit
+	if hasMore {
+		users = users[:limit]
+	}
+	for i := range users {
+		users[i].AvatarURL = avatarURL(users[i].Nickname, users[i].Avatar)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FollowListResponse{Users: users, Page: page, HasMore: hasMore})
+}
+
+// notifyFollowers tells the followers of an author about their new post,
+// leaving out followers who turned these notifications off or muted the
+// post's category. The post is already saved, so failures are only logged.
+func (h *Handler) notifyFollowers(authorID, postID int) {
+	followers, err := h.DB.FollowersToNotify(authorID)
+	if err != nil {
+		log.Printf("Error loading followers of user %d: %v", authorID, err)
+		return
+	}
+	if len(followers) == 0 {
+		return
+	}
+	levels, err := h.DB.ThreadSubscriptions(postID)
+	if err != nil {
+		log.Printf("Error loading subscriptions of post %d: %v", postID, err)
+	}
+
+	for _, userID := range followers {
+		if levels[userID] == database.SubscriptionMute {
+			continue
+		}
+		h.Notifier.Send(database.NotificationEvent{
+			UserID:     userID,
+			Kind:       database.NotifyFollowedPost,
+			ActorID:    authorID,
+			TargetType: database.TargetPost,
+			TargetID:   postID,
+		})
+	}
+}
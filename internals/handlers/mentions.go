@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/markdown"
+)
+
+// userAutocompleteLimit caps the number of nickname suggestions
+const userAutocompleteLimit = 10
+
+// renderContent renders Markdown content, linking its mentions to the
+// mentioned users under their current nicknames
+func renderContent(content string, mentions []database.Mention) string {
+	if len(mentions) == 0 {
+		return markdown.Render(content)
+	}
+	return markdown.RenderMentions(content, func(handle string) (int, string, bool) {
+		for _, m := range mentions {
+			if strings.EqualFold(m.Handle, handle) {
+				return m.UserID, m.Nickname, true
+			}
+		}
+		return 0, "", false
+	})
+}
+
+// recordMentions stores the @mentions of new content and notifies the
+// mentioned users for which include returns true, unless they turned mentions
+// off. Content held for review notifies nobody. The content is already
+// saved, so failures are only logged.
+func (h *Handler) recordMentions(sourceType string, sourceID, authorID int, content string, held bool, include func(database.Mention) bool) {
+	handles := markdown.Mentions(content)
+	if len(handles) == 0 {
+		return
+	}
+	mentions, err := h.DB.RecordMentions(sourceType, sourceID, handles)
+	if err != nil {
+		log.Printf("Error recording mentions of %s %d: %v", sourceType, sourceID, err)
+		return
+	}
+	if held {
+		return
+	}
+
+	for _, m := range mentions {
+		if include != nil && !include(m) {
+			continue
+		}
+		allowed, err := h.DB.AllowsMentions(m.UserID)
+		if err != nil {
+			log.Printf("Error checking mention settings: %v", err)
+			continue
+		}
+		if allowed {
+			h.Notifier.Send(database.NotificationEvent{
+				UserID:     m.UserID,
+				Kind:       database.NotifyMention,
+				ActorID:    authorID,
+				TargetType: sourceType,
+				TargetID:   sourceID,
+			})
+		}
+	}
+}
+
+// AutocompleteUsers suggests nicknames starting with ?q= for @mentions
+func (h *Handler) AutocompleteUsers(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.authenticate(r); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	users, err := h.DB.SearchUsers(r.URL.Query().Get("q"), userAutocompleteLimit)
+	if err != nil {
+		log.Printf("Error searching users: %v", err)
+		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/ratelimit"
+	"real-time-forum/internals/realtime"
+)
+
+// allowedMessageReactions lists the emoji a message can receive
+var allowedMessageReactions = map[string]bool{
+	"👍":  true,
+	"❤️": true,
+	"😂":  true,
+	"😮":  true,
+	"😢":  true,
+	"🙏":  true,
+}
+
+type EditMessageRequest struct {
+	Content string `json:"content"`
+}
+
+type MessageReactionResponse struct {
+	Success   bool                       `json:"success"`
+	Reacted   bool                       `json:"reacted"`
+	Reactions []database.MessageReaction `json:"reactions"`
+}
+
+// MessageHistoryResponse shows moderators a message with its earlier versions
+type MessageHistoryResponse struct {
+	Message *database.Message      `json:"message"`
+	Hidden  bool                   `json:"hidden"`
+	Edits   []database.MessageEdit `json:"edits"`
+}
+
+// messageError maps database errors from message changes to HTTP responses
+func messageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrMessageNotFound):
+		http.Error(w, "Message not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrMessageDeleted):
+		http.Error(w, "Message was deleted", http.StatusGone)
+	case errors.Is(err, database.ErrEditWindowExpired):
+		http.Error(w, "Messages can only be edited for "+strconv.Itoa(int(database.MessageEditWindow.Minutes()))+
+			" minutes after sending", http.StatusForbidden)
+	default:
+		conversationError(w, err)
+	}
+}
+
+// messageFor authenticates the request and loads a visible message from a
+// conversation the current user is a member of, with that conversation
+func (h *Handler) messageFor(w http.ResponseWriter, r *http.Request, messageIDStr string) (int, *database.Message, *database.Conversation, bool) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, nil, nil, false
+	}
+	messageID, err := strconv.Atoi(messageIDStr)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return 0, nil, nil, false
+	}
+
+	message, err := h.DB.GetMessageByID(messageID)
+	if err == nil && message.Hidden {
+		err = database.ErrMessageNotFound
+	}
+	if err != nil {
+		messageError(w, err)
+		return 0, nil, nil, false
+	}
+	conversation, err := h.DB.GetConversation(message.ConversationID, userID)
+	if errors.Is(err, database.ErrConversationNotFound) {
+		err = database.ErrMessageNotFound
+	}
+	if err != nil {
+		messageError(w, err)
+		return 0, nil, nil, false
+	}
+	if !requireMember(w, conversation) {
+		return 0, nil, nil, false
+	}
+	return userID, message, conversation, true
+}
+
+// publishMessageUpdate pushes the current state of a changed message to the
+// other members of its conversation
+func (h *Handler) publishMessageUpdate(actorID, messageID int, conversation *database.Conversation) {
+	message, err := h.DB.GetMessageByID(messageID)
+	if err != nil {
+		log.Printf("Error loading changed message %d: %v", messageID, err)
+		return
+	}
+	members, err := h.DB.ConversationMemberIDs(conversation.ID)
+	if err != nil {
+		log.Printf("Error loading members of conversation %d: %v", conversation.ID, err)
+		return
+	}
+	h.publishToMembers(actorID, conversation.Kind, members, realtime.Event{Type: EventMessageUpdated, Data: message})
+}
+
+// EditMessage replaces the content of one of the current user's recent
+// messages
+func (h *Handler) EditMessage(w http.ResponseWriter, r *http.Request, messageIDStr string) {
+	userID, message, conversation, ok := h.messageFor(w, r, messageIDStr)
+	if !ok || h.rejectMuted(w, userID) {
+		return
+	}
+
+	var req EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	content := strings.TrimSpace(req.Content)
+	if content == "" && len(message.Attachments) == 0 {
+		http.Error(w, "Message content is required", http.StatusBadRequest)
+		return
+	}
+	// Check what can be checked before spending the sender's rate limit
+	switch {
+	case message.SenderID != userID:
+		http.Error(w, "Only the sender can edit a message", http.StatusForbidden)
+		return
+	case message.Deleted:
+		messageError(w, database.ErrMessageDeleted)
+		return
+	case time.Since(message.CreatedAt) > database.MessageEditWindow:
+		messageError(w, database.ErrEditWindowExpired)
+		return
+	}
+	if !h.rateLimit(w, r, ratelimit.ActionMessage, userID) {
+		return
+	}
+
+	verdict, ok := h.applyAutomod(w, database.AutomodInput{
+		TargetType: database.TargetMessage,
+		AuthorID:   userID,
+		Content:    content,
+	})
+	if !ok {
+		return
+	}
+	if err := h.DB.EditMessage(userID, message.ID, verdict.Content, verdict.HoldRule()); err != nil {
+		messageError(w, err)
+		return
+	}
+
+	response := SendMessageResponse{Success: true, Message: "Message edited", MessageID: message.ID}
+	held := verdict.HoldRule() != nil
+	members, err := h.DB.ConversationMemberIDs(conversation.ID)
+	if err != nil {
+		log.Printf("Error loading members of conversation %d: %v", conversation.ID, err)
+	}
+	// Only users mentioned for the first time hear of it
+	h.recordMentions(database.TargetMessage, message.ID, userID, verdict.Content, held, func(m database.Mention) bool {
+		return slices.Contains(members, m.UserID)
+	})
+	if held {
+		response.Message = heldMessage
+	} else {
+		h.publishMessageUpdate(userID, message.ID, conversation)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteMessage deletes a message for everyone in its conversation, or with
+// ?for=me only from the current user's history
+func (h *Handler) DeleteMessage(w http.ResponseWriter, r *http.Request, messageIDStr string) {
+	userID, message, conversation, ok := h.messageFor(w, r, messageIDStr)
+	if !ok {
+		return
+	}
+
+	switch r.URL.Query().Get("for") {
+	case "me":
+		if err := h.DB.DeleteMessageForUser(userID, message.ID); err != nil {
+			messageError(w, err)
+			return
+		}
+		// The user's other sessions drop it too
+		h.Hub.Publish(userID, realtime.Event{
+			Type: EventMessageDeleted,
+			Data: map[string]int{"conversationId": conversation.ID, "messageId": message.ID},
+		})
+	case "", "everyone":
+		if err := h.DB.DeleteMessage(userID, message.ID); err != nil {
+			messageError(w, err)
+			return
+		}
+		h.publishMessageUpdate(userID, message.ID, conversation)
+	default:
+		http.Error(w, "Invalid deletion, must be for me or everyone", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: "Message deleted", MessageID: message.ID})
+}
+
+// ToggleMessageReaction adds or removes the current user's emoji on a message
+func (h *Handler) ToggleMessageReaction(w http.ResponseWriter, r *http.Request, messageIDStr string) {
+	userID, message, conversation, ok := h.messageFor(w, r, messageIDStr)
+	if !ok {
+		return
+	}
+
+	var req ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !allowedMessageReactions[req.Reaction] {
+		http.Error(w, "Invalid reaction", http.StatusBadRequest)
+		return
+	}
+	if message.Deleted {
+		messageError(w, database.ErrMessageDeleted)
+		return
+	}
+
+	reacted, err := h.DB.ToggleMessageReaction(userID, message.ID, req.Reaction)
+	if err != nil {
+		messageError(w, err)
+		return
+	}
+	updated, err := h.DB.GetMessageByID(message.ID)
+	if err != nil {
+		messageError(w, err)
+		return
+	}
+	h.publishMessageUpdate(userID, message.ID, conversation)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MessageReactionResponse{Success: true, Reacted: reacted, Reactions: updated.Reactions})
+}
+
+// GetMessageHistory shows a message with the versions it had before it was
+// edited or deleted (requires report.review)
+func (h *Handler) GetMessageHistory(w http.ResponseWriter, r *http.Request, messageIDStr string) {
+	if _, ok := h.requirePermission(w, r, database.PermReportReview, 0); !ok {
+		return
+	}
+	messageID, err := strconv.Atoi(messageIDStr)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	message, err := h.DB.GetMessageByID(messageID)
+	if err != nil {
+		messageError(w, err)
+		return
+	}
+	edits, err := h.DB.GetMessageEdits(messageID)
+	if err != nil {
+		messageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MessageHistoryResponse{Message: message, Hidden: message.Hidden, Edits: edits})
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"real-time-forum/internals/database"
+)
+
+// moderationLogLimit caps the number of log entries returned at once
+const moderationLogLimit = 100
+
+type ReportRequest struct {
+	TargetType string `json:"targetType"`
+	TargetID   int    `json:"targetId"`
+	Reason     string `json:"reason"`
+	Details    string `json:"details"`
+}
+
+type ReportResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Hidden  bool   `json:"hidden"` // the report pushed the content over the threshold
+}
+
+type ModerationResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+type ModerationActionRequest struct {
+	TargetType    string `json:"targetType"`
+	TargetID      int    `json:"targetId"`
+	Action        string `json:"action"`
+	Note          string `json:"note"`
+	DurationHours int    `json:"durationHours"` // suspend and mute only; 0 is permanent
+}
+
+// canReview reports whether the request comes from someone allowed to review
+// reports in the category (0 for global-only content such as messages)
+func (h *Handler) canReview(r *http.Request, categoryID int) bool {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		return false
+	}
+	allowed, err := h.DB.HasPermission(userID, database.PermReportReview, categoryID)
+	if err != nil {
+		log.Printf("Error checking permission %s: %v", database.PermReportReview, err)
+	}
+	return allowed
+}
+
+// moderationError maps database errors from reports and moderation to HTTP responses
+func moderationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrContentNotFound):
+		http.Error(w, "Content not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrAlreadyReported):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, database.ErrUnknownTarget),
+		errors.Is(err, database.ErrInvalidReason),
+		errors.Is(err, database.ErrCannotReportOwn),
+		errors.Is(err, database.ErrUnknownModeration):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrOutranked):
+		http.Error(w, "You cannot sanction a user of equal or higher role", http.StatusForbidden)
+	default:
+		log.Printf("Error handling moderation request: %v", err)
+		http.Error(w, "Failed to process request", http.StatusInternalServerError)
+	}
+}
+
+// ReportContent flags a post, comment or message for moderators
+func (h *Handler) ReportContent(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TargetID == 0 {
+		http.Error(w, "Target is required", http.StatusBadRequest)
+		return
+	}
+
+	hidden, err := h.DB.ReportContent(userID, req.TargetType, req.TargetID, req.Reason, req.Details)
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+	if hidden {
+		if target, err := h.DB.GetReportTarget(req.TargetType, req.TargetID); err == nil {
+			h.Notifier.Moderation(target.AuthorID, database.ModAutoHide, target.Type, target.ID, "")
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ReportResponse{Success: true, Message: "Report submitted", Hidden: hidden})
+}
+
+// GetModerationQueue lists open reports grouped by target. Category
+// moderators only see targets in the categories they moderate.
+func (h *Handler) GetModerationQueue(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	global, err := h.DB.HasPermission(userID, database.PermReportReview, 0)
+	if err != nil {
+		log.Printf("Error checking permission %s: %v", database.PermReportReview, err)
+	}
+	scoped, err := h.DB.IsCategoryModerator(userID)
+	if err != nil {
+		log.Printf("Error checking category moderator: %v", err)
+	}
+	if !global && !scoped {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	queue, err := h.DB.GetOpenReports()
+	if err != nil {
+		log.Printf("Error retrieving reports: %v", err)
+		http.Error(w, "Failed to retrieve reports", http.StatusInternalServerError)
+		return
+	}
+
+	visible := queue[:0]
+	for _, group := range queue {
+		if !global {
+			if group.Target.CategoryID == 0 {
+				continue
+			}
+			allowed, err := h.DB.HasPermission(userID, database.PermReportReview, group.Target.CategoryID)
+			if err != nil || !allowed {
+				continue
+			}
+		}
+		visible = append(visible, group)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visible)
+}
+
+// ModerateContent dismisses reports on a target, hides it, warns its author
+// or suspends or mutes its author. Sanctions require user.ban.
+func (h *Handler) ModerateContent(w http.ResponseWriter, r *http.Request) {
+	actorID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ModerationActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TargetID == 0 || req.DurationHours < 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Content the actor cannot review looks the same as missing content
+	target, err := h.DB.GetReportTarget(req.TargetType, req.TargetID)
+	if err == nil && !h.canReview(r, target.CategoryID) {
+		err = database.ErrContentNotFound
+	}
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+
+	sanction := req.Action == database.ModSuspend || req.Action == database.ModMute
+	if sanction {
+		if _, ok := h.requirePermission(w, r, database.PermUserBan, 0); !ok {
+			return
+		}
+	}
+	if sanction && target.AuthorID == actorID {
+		http.Error(w, "You cannot sanction yourself", http.StatusBadRequest)
+		return
+	}
+
+	duration := time.Duration(req.DurationHours) * time.Hour
+	if err := h.DB.ModerateTarget(actorID, req.TargetType, req.TargetID, req.Action, req.Note, duration); err != nil {
+		moderationError(w, err)
+		return
+	}
+	if req.Action == database.ModSuspend {
+		h.kickUser(target.AuthorID, req.Note)
+	}
+	if req.Action != database.ModDismiss {
+		h.Notifier.Moderation(target.AuthorID, req.Action, target.Type, target.ID, req.Note)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ModerationResponse{Success: true, Message: "Moderation action applied"})
+}
+
+// GetModerationLog lists recent moderation actions (requires report.review globally)
+func (h *Handler) GetModerationLog(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requirePermission(w, r, database.PermReportReview, 0); !ok {
+		return
+	}
+
+	entries, err := h.DB.GetModerationLog(moderationLogLimit)
+	if err != nil {
+		log.Printf("Error retrieving moderation log: %v", err)
+		http.Error(w, "Failed to retrieve moderation log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
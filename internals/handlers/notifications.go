@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real-time-forum/internals/database"
+)
+
+// notificationListLimit caps the number of notifications returned at once
+const notificationListLimit = 50
+
+type NotificationResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Unread  int    `json:"unread"`
+}
+
+type UnreadCountResponse struct {
+	Unread int `json:"unread"`
+}
+
+// GetNotifications lists the current user's latest notifications, grouped
+func (h *Handler) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	notifications, err := h.DB.GetNotifications(userID, notificationListLimit)
+	if err != nil {
+		log.Printf("Error retrieving notifications: %v", err)
+		http.Error(w, "Failed to retrieve notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// GetUnreadNotificationCount returns how many notification groups are unread
+func (h *Handler) GetUnreadNotificationCount(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	unread, err := h.DB.CountUnreadNotifications(userID)
+	if err != nil {
+		log.Printf("Error counting notifications: %v", err)
+		http.Error(w, "Failed to count notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UnreadCountResponse{Unread: unread})
+}
+
+// MarkNotificationRead marks a notification, with the rest of its group, as read
+func (h *Handler) MarkNotificationRead(w http.ResponseWriter, r *http.Request, notificationIDStr string) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	notificationID, err := strconv.Atoi(notificationIDStr)
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.DB.MarkNotificationRead(userID, notificationID)
+	if errors.Is(err, database.ErrNotificationNotFound) {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error marking notification read: %v", err)
+		http.Error(w, "Failed to update notification", http.StatusInternalServerError)
+		return
+	}
+	h.writeNotificationsRead(w, userID, "Notification marked as read")
+}
+
+// MarkAllNotificationsRead marks every notification of the current user as read
+func (h *Handler) MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.DB.MarkAllNotificationsRead(userID); err != nil {
+		log.Printf("Error marking notifications read: %v", err)
+		http.Error(w, "Failed to update notifications", http.StatusInternalServerError)
+		return
+	}
+	h.writeNotificationsRead(w, userID, "All notifications marked as read")
+}
+
+// writeNotificationsRead answers with the new unread count and syncs the
+// user's other sessions
+func (h *Handler) writeNotificationsRead(w http.ResponseWriter, userID int, message string) {
+	unread, err := h.DB.CountUnreadNotifications(userID)
+	if err != nil {
+		log.Printf("Error counting notifications: %v", err)
+	}
+	h.Notifier.PushUnread(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NotificationResponse{Success: true, Message: message, Unread: unread})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/internals/database"
+)
+
+// PostItem is the JSON shape of a post in listings and detail responses
+type PostItem struct {
+	ID          int      `json:"id"`
+	Title       string   `json:"title"`
+	Content     string   `json:"content"`
+	ContentHTML string   `json:"contentHtml"` // sanitized Markdown rendering of Content
+	Category    string   `json:"category"`
+	Author      string   `json:"author"`
+	CreatedAt   string   `json:"createdAt"`
+	Tags        []string `json:"tags"`
+
+	Mentions    []database.Mention    `json:"mentions,omitempty"`
+	Attachments []database.Attachment `json:"attachments,omitempty"`
+
+	// Unread comments by others, for signed in readers of post listings
+	UnreadComments       int `json:"unreadComments"`
+	FirstUnreadCommentID int `json:"firstUnreadCommentId,omitempty"`
+
+	// Breadcrumbs is only filled in for single post responses
+	Breadcrumbs []database.CategoryCrumb `json:"breadcrumbs,omitempty"`
+}
+
+func newPostItem(post database.Post) PostItem {
+	tags := post.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return PostItem{
+		ID:          post.ID,
+		Title:       post.Title,
+		Content:     post.Content,
+		ContentHTML: renderContent(post.Content, post.Mentions),
+		Category:    post.Category,
+		Author:      post.Author,
+		CreatedAt:   post.CreatedAt.Format(time.RFC3339),
+		Tags:        tags,
+		Mentions:    post.Mentions,
+		Attachments: post.Attachments,
+
+		UnreadComments:       post.UnreadComments,
+		FirstUnreadCommentID: post.FirstUnreadCommentID,
+	}
+}
+
+func newPostItems(posts []database.Post) []PostItem {
+	items := make([]PostItem, 0, len(posts))
+	for _, post := range posts {
+		items = append(items, newPostItem(post))
+	}
+	return items
+}
+
+// parsePostFilter reads the category, subcategories, tags and sort query
+// parameters. Tags may be given comma separated or as repeated parameters.
+func parsePostFilter(r *http.Request) (database.PostFilter, error) {
+	query := r.URL.Query()
+	var filter database.PostFilter
+
+	if categoryIDStr := query.Get("category"); categoryIDStr != "" {
+		categoryID, err := strconv.Atoi(categoryIDStr)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid category ID")
+		}
+		filter.CategoryID = categoryID
+		filter.IncludeSubcategories = query.Get("subcategories") == "true"
+	}
+
+	var rawTags []string
+	for _, value := range query["tags"] {
+		for _, tag := range strings.Split(value, ",") {
+			if strings.TrimSpace(tag) != "" {
+				rawTags = append(rawTags, tag)
+			}
+		}
+	}
+	if len(rawTags) > 0 {
+		tags, err := database.NormalizeTags(rawTags)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid tags")
+		}
+		filter.Tags = tags
+	}
+
+	switch sortOrder := query.Get("sort"); sortOrder {
+	case "", database.SortNew, database.SortHot:
+		filter.Sort = sortOrder
+	default:
+		return filter, fmt.Errorf("Invalid sort order")
+	}
+
+	return filter, nil
+}
+
+// DeletePost removes a post. Authors may delete their own posts; anyone else
+// needs post.delete.any globally or in the post's category.
+func (h *Handler) DeletePost(w http.ResponseWriter, r *http.Request, postIDStr string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	postID, err := strconv.Atoi(postIDStr)
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	post, err := h.DB.GetPostByID(postID)
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	if post.UserID != userID {
+		if _, ok := h.requirePermission(w, r, database.PermPostDeleteAny, post.CategoryID); !ok {
+			return
+		}
+	}
+
+	if err := h.DB.DeletePost(postID); err != nil {
+		log.Printf("Error deleting post: %v", err)
+		http.Error(w, "Failed to delete post", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PostResponse{Success: true, Message: "Post deleted", PostID: postID})
+}
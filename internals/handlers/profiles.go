@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real-time-forum/internals/database"
+)
+
+// Page sizes of paginated listings, set with ?limit=
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// ProfileComment is a comment listed on its author's profile
+type ProfileComment struct {
+	Comment
+	PostTitle string `json:"postTitle"`
+}
+
+type ProfilePostsResponse struct {
+	Posts   []PostItem `json:"posts"`
+	Page    int        `json:"page"`
+	HasMore bool       `json:"hasMore"`
+}
+
+type ProfileCommentsResponse struct {
+	Comments []ProfileComment `json:"comments"`
+	Page     int              `json:"page"`
+	HasMore  bool             `json:"hasMore"`
+}
+
+// parsePage reads ?page= (counting from 1) and ?limit= and returns the page
+// with the matching limit and offset
+func parsePage(r *http.Request) (page, limit, offset int, err error) {
+	query := r.URL.Query()
+	page, limit = 1, defaultPageSize
+	if v := query.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, 0, fmt.Errorf("Invalid page")
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxPageSize {
+			return 0, 0, 0, fmt.Errorf("Invalid limit, must be between 1 and %d", maxPageSize)
+		}
+	}
+	return page, limit, (page - 1) * limit, nil
+}
+
+// profileError maps database errors from profiles to HTTP responses
+func profileError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrInvalidProfile):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrNicknameTaken):
+		http.Error(w, "Nickname already taken", http.StatusConflict)
+	default:
+		log.Printf("Error managing profile: %v", err)
+		http.Error(w, "Failed to process profile", http.StatusInternalServerError)
+	}
+}
+
+// visibleProfile removes the personal and presence fields of a profile that
+// its owner's privacy settings hide from the viewer
+func visibleProfile(p *database.Profile, privacy *database.PrivacyView) *database.Profile {
+	visible := *p
+	if !privacy.Allows(p.ID, database.UserSettingShowRealName) {
+		visible.FirstName, visible.LastName = "", ""
+	}
+	if !privacy.Allows(p.ID, database.UserSettingShowAge) {
+		visible.Age = 0
+	}
+	if !privacy.Allows(p.ID, database.UserSettingShowGender) {
+		visible.Gender = ""
+	}
+	if !privacy.Online(p.ID) {
+		visible.Online = false
+	}
+	if !privacy.LastSeen(p.ID) {
+		visible.LastSeen = nil
+	}
+	return &visible
+}
+
+// GetProfile returns the public profile of a user by nickname
+func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request, nickname string) {
+	profile, err := h.DB.GetProfile(nickname)
+	if err != nil {
+		profileError(w, err)
+		return
+	}
+	profile.AvatarURL = avatarURL(profile.Nickname, profile.Avatar)
+	viewerID, _ := h.authenticate(r)
+	privacy, err := h.DB.PrivacyView(viewerID, []int{profile.ID})
+	if err != nil {
+		profileError(w, err)
+		return
+	}
+	if viewerID != 0 {
+		if profile.Followed, err = h.DB.IsFollowing(viewerID, profile.ID); err != nil {
+			profileError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visibleProfile(profile, privacy))
+}
+
+// GetUserPosts lists a page of a user's posts, newest first
+func (h *Handler) GetUserPosts(w http.ResponseWriter, r *http.Request, nickname string) {
+	page, limit, offset, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	profile, err := h.DB.GetProfile(nickname)
+	if err != nil {
+		profileError(w, err)
+		return
+	}
+
+	// One extra post tells whether there is a next page
+	posts, err := h.DB.ListPosts(database.PostFilter{AuthorID: profile.ID, Limit: limit + 1, Offset: offset})
+	if err != nil {
+		log.Printf("Error retrieving user posts: %v", err)
+		http.Error(w, "Failed to retrieve posts", http.StatusInternalServerError)
+		return
+	}
+	hasMore := len(posts) > limit
+	if hasMore {
+		posts = posts[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProfilePostsResponse{Posts: newPostItems(posts), Page: page, HasMore: hasMore})
+}
+
+// GetUserComments lists a page of a user's comments, newest first
+func (h *Handler) GetUserComments(w http.ResponseWriter, r *http.Request, nickname string) {
+	page, limit, offset, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	profile, err := h.DB.GetProfile(nickname)
+	if err != nil {
+		profileError(w, err)
+		return
+	}
+
+	comments, err := h.DB.ListUserComments(profile.ID, limit+1, offset)
+	if err != nil {
+		log.Printf("Error retrieving user comments: %v", err)
+		http.Error(w, "Failed to retrieve comments", http.StatusInternalServerError)
+		return
+	}
+	hasMore := len(comments) > limit
+	if hasMore {
+		comments = comments[:limit]
+	}
+
+	items := make([]ProfileComment, 0, len(comments))
+	for _, c := range comments {
+		items = append(items, ProfileComment{Comment: newCommentItem(c.Comment), PostTitle: c.PostTitle})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProfileCommentsResponse{Comments: items, Page: page, HasMore: hasMore})
+}
+
+// GetMyProfile returns the current user's own profile with every field
+func (h *Handler) GetMyProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.writeOwnProfile(w, userID)
+}
+
+// UpdateMyProfile changes the nickname, real name, age or gender of the current user
+func (h *Handler) UpdateMyProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var update database.ProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.UpdateProfile(userID, update); err != nil {
+		profileError(w, err)
+		return
+	}
+	h.writeOwnProfile(w, userID)
+}
+
+func (h *Handler) writeOwnProfile(w http.ResponseWriter, userID int) {
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		profileError(w, database.ErrUserNotFound)
+		return
+	}
+	profile, err := h.DB.GetProfile(user.Nickname)
+	if err != nil {
+		profileError(w, err)
+		return
+	}
+	profile.AvatarURL = avatarURL(profile.Nickname, profile.Avatar)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tooManyRequests rejects a request with the standard Retry-After header,
+// rounded up to whole seconds
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
+// clientIP returns the address of the client. Behind trusted proxies it is
+// taken from X-Forwarded-For, counting from the right: entries further left
+// were sent by the client and could be anything.
+func (h *Handler) clientIP(r *http.Request) string {
+	if h.TrustedProxies > 0 {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(strings.Join(forwarded, ","), ",")
+			return strings.TrimSpace(hops[max(0, len(hops)-h.TrustedProxies)])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimit charges the user and their IP for an action. It writes the 429
+// response itself and reports whether to continue.
+func (h *Handler) rateLimit(w http.ResponseWriter, r *http.Request, action string, userID int) bool {
+	age, err := h.DB.AccountAge(userID)
+	if err != nil {
+		log.Printf("Error checking account age: %v", err)
+		http.Error(w, "Failed to check rate limit", http.StatusInternalServerError)
+		return false
+	}
+	if ok, wait := h.Limiter.Allow(action, userID, h.clientIP(r), age); !ok {
+		tooManyRequests(w, wait, "You are doing that too often, please slow down")
+		return false
+	}
+	return true
+}
+
+// checkSlowMode enforces the slow mode interval of a category. It writes the
+// 429 response itself and reports whether to continue.
+func (h *Handler) checkSlowMode(w http.ResponseWriter, userID, categoryID int) bool {
+	wait, err := h.DB.SlowModeWait(userID, categoryID)
+	if err != nil {
+		log.Printf("Error checking slow mode: %v", err)
+		http.Error(w, "Failed to check slow mode", http.StatusInternalServerError)
+		return false
+	}
+	if wait > 0 {
+		tooManyRequests(w, wait, "Slow mode is on in this category")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real-time-forum/internals/database"
+)
+
+// allowedReactions lists the reaction kinds a post can receive
+var allowedReactions = map[string]bool{
+	"like":  true,
+	"love":  true,
+	"laugh": true,
+	"wow":   true,
+	"sad":   true,
+	"angry": true,
+}
+
+type ReactionRequest struct {
+	Reaction string `json:"reaction"`
+}
+
+type ReactionResponse struct {
+	Success   bool                     `json:"success"`
+	Reacted   bool                     `json:"reacted"`
+	Reactions []database.ReactionCount `json:"reactions"`
+}
+
+// ToggleReaction adds or removes the current user's reaction on a post
+func (h *Handler) ToggleReaction(w http.ResponseWriter, r *http.Request, postIDStr string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	postID, err := strconv.Atoi(postIDStr)
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !allowedReactions[req.Reaction] {
+		http.Error(w, "Invalid reaction", http.StatusBadRequest)
+		return
+	}
+
+	post, err := h.DB.GetPostByID(postID)
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	// Hidden posts stay visible to the moderators reviewing them
+	if post.Hidden && !h.canReview(r, post.CategoryID) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	reacted, err := h.DB.ToggleReaction(userID, postID, req.Reaction)
+	if err != nil {
+		log.Printf("Error toggling reaction: %v", err)
+		http.Error(w, "Failed to update reaction", http.StatusInternalServerError)
+		return
+	}
+	if reacted && !h.threadMuted(post.UserID, post.ID) {
+		h.Notifier.Send(database.NotificationEvent{
+			UserID:     post.UserID,
+			Kind:       database.NotifyReaction,
+			ActorID:    userID,
+			TargetType: database.TargetPost,
+			TargetID:   post.ID,
+		})
+	}
+
+	counts, err := h.DB.GetReactionCounts(postID)
+	if err != nil {
+		log.Printf("Error retrieving reactions: %v", err)
+		http.Error(w, "Failed to retrieve reactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReactionResponse{Success: true, Reacted: reacted, Reactions: counts})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real-time-forum/internals/database"
+)
+
+type MarkReadResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// fromFirstUnread drops the comments before the first unread comment by
+// someone other than the reader
+func fromFirstUnread(comments []database.Comment, readerID, lastRead int) []database.Comment {
+	for i, c := range comments {
+		if c.ID > lastRead && c.UserID != readerID {
+			return comments[i:]
+		}
+	}
+	return nil
+}
+
+// MarkCategoryRead marks every comment so far in a category and its subcategories as read
+func (h *Handler) MarkCategoryRead(w http.ResponseWriter, r *http.Request, categoryIDStr string) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	categoryID, err := strconv.Atoi(categoryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.DB.MarkCategoryRead(userID, categoryID)
+	if errors.Is(err, database.ErrCategoryNotFound) {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error marking category read: %v", err)
+		http.Error(w, "Failed to mark category read", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MarkReadResponse{Success: true, Message: "Category marked as read"})
+}
+
+// MarkAllRead marks every comment so far in the forum as read
+func (h *Handler) MarkAllRead(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.DB.MarkAllRead(userID); err != nil {
+		log.Printf("Error marking forum read: %v", err)
+		http.Error(w, "Failed to mark forum read", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MarkReadResponse{Success: true, Message: "Everything marked as read"})
+}
@@ -5,22 +5,53 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"real-time-forum/internals/attachments"
+	"real-time-forum/internals/avatar"
 	"real-time-forum/internals/database"
+	"real-time-forum/internals/notify"
+	"real-time-forum/internals/ratelimit"
+	"real-time-forum/internals/realtime"
+	"real-time-forum/internals/storage"
 )
 
 type Handler struct {
-	DB *database.Database
+	DB          *database.Database
+	Hub         *realtime.Hub
+	Notifier    *notify.Notifier
+	Limiter     *ratelimit.Limiter
+	Storage     storage.Backend // uploaded files
+	Avatars     *avatar.Store
+	Attachments attachments.Config
+
+	// TrustedProxies is how many reverse proxies in front of the server each
+	// append the address they were reached from to X-Forwarded-For
+	TrustedProxies int
+
+	DigestSecret []byte // signs digest unsubscribe links; nil when email is off
 }
 
 func NewHandler(db *database.Database) *Handler {
-	return &Handler{DB: db}
+	hub := realtime.NewHub(db)
+	uploads := storage.NewLocal(storage.DefaultDir)
+	return &Handler{
+		DB:          db,
+		Hub:         hub,
+		Notifier:    notify.New(db, hub),
+		Limiter:     ratelimit.New(ratelimit.DefaultConfig),
+		Storage:     uploads,
+		Avatars:     avatar.New(uploads, avatar.DefaultConfig),
+		Attachments: attachments.DefaultConfig,
+	}
 }
 
 type UserRegistration struct {
@@ -46,9 +77,12 @@ type AuthResponse struct {
 }
 
 type NewPostRequest struct {
-	Title    string `json:"title"`
-	Content  string `json:"content"`
-	Category string `json:"category"` // Added category field
+	Title    string   `json:"title"`
+	Content  string   `json:"content"`
+	Category string   `json:"category"` // Added category field
+	Tags     []string `json:"tags"`
+
+	Attachments []int `json:"attachments"` // IDs of the user's uploads to attach
 }
 
 type PostResponse struct {
@@ -59,10 +93,16 @@ type PostResponse struct {
 
 // Category response types
 type CategoryResponse struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description,omitempty"`
-	PostCount   int    `json:"postCount"`
+	ID             int    `json:"id"`
+	Name           string `json:"name"`
+	Description    string `json:"description,omitempty"`
+	PostCount      int    `json:"postCount"`
+	TotalPostCount int    `json:"totalPostCount"` // includes posts in subcategories
+	ParentID       int    `json:"parentId,omitempty"`
+	Position       int    `json:"position"`
+	Icon           string `json:"icon,omitempty"`
+	Color          string `json:"color,omitempty"`
+	Archived       bool   `json:"archived"`
 }
 
 // JWT secret key (in production, use environment variable)
@@ -78,16 +118,39 @@ type Claims struct {
 // comments struct
 // Comment types
 type Comment struct {
-	ID        int       `json:"id"`
-	Content   string    `json:"content"`
-	PostID    int       `json:"postId"`
-	AuthorID  int       `json:"authorId"`
-	Author    string    `json:"author"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID          int       `json:"id"`
+	Content     string    `json:"content"`
+	ContentHTML string    `json:"contentHtml"` // sanitized Markdown rendering of Content
+	PostID      int       `json:"postId"`
+	ParentID    int       `json:"parentId,omitempty"`
+	AuthorID    int       `json:"authorId"`
+	Author      string    `json:"author"`
+	CreatedAt   time.Time `json:"createdAt"`
+
+	Mentions    []database.Mention    `json:"mentions,omitempty"`
+	Attachments []database.Attachment `json:"attachments,omitempty"`
+}
+
+func newCommentItem(c database.Comment) Comment {
+	return Comment{
+		ID:          c.ID,
+		Content:     c.Content,
+		ContentHTML: renderContent(c.Content, c.Mentions),
+		PostID:      c.PostID,
+		ParentID:    c.ParentID,
+		AuthorID:    c.UserID,
+		Author:      c.Author,
+		CreatedAt:   c.CreatedAt,
+		Mentions:    c.Mentions,
+		Attachments: c.Attachments,
+	}
 }
 
 type NewCommentRequest struct {
-	Content string `json:"content"`
+	Content  string `json:"content"`
+	ParentID int    `json:"parentId"` // optional comment being replied to
+
+	Attachments []int `json:"attachments"` // IDs of the user's uploads to attach
 }
 
 type CommentResponse struct {
@@ -191,6 +254,8 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 			status = http.StatusNotFound
 		} else if err.Error() == "no credentials provided" {
 			status = http.StatusBadRequest
+		} else if errors.Is(err, database.ErrUserSuspended) {
+			status = http.StatusForbidden
 		}
 		sendAuthResponse(w, false, err.Error(), status, nil, "")
 		return
@@ -289,6 +354,39 @@ func (h *Handler) VerifyJWTToken(tokenString string) (*Claims, error) {
 	return &claims, nil
 }
 
+// authenticate returns the ID of the user behind the request's bearer token
+func (h *Handler) authenticate(r *http.Request) (int, error) {
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tokenString == "" {
+		return 0, fmt.Errorf("missing token")
+	}
+	claims, err := h.VerifyJWTToken(tokenString)
+	if err != nil {
+		return 0, err
+	}
+	return int(claims.UserID), nil
+}
+
+// requirePermission authenticates the request and checks the user holds the
+// permission, globally or within categoryID when it is not 0. It writes the
+// error response itself and reports whether to continue.
+func (h *Handler) requirePermission(w http.ResponseWriter, r *http.Request, permission string, categoryID int) (int, bool) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+	allowed, err := h.DB.HasPermission(userID, permission, categoryID)
+	if err != nil {
+		log.Printf("Error checking permission %s: %v", permission, err)
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	return userID, true
+}
+
 func base64Encode(src []byte) string {
 	return base64.RawURLEncoding.EncodeToString(src)
 }
@@ -340,6 +438,9 @@ func (h *Handler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	userID := int(claims.UserID)
+	if h.rejectMuted(w, userID) {
+		return
+	}
 
 	var newPost NewPostRequest
 	if err := json.NewDecoder(r.Body).Decode(&newPost); err != nil {
@@ -358,6 +459,16 @@ func (h *Handler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tags, err := database.NormalizeTags(newPost.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	attachmentIDs, ok := h.checkAttachments(w, userID, newPost.Attachments)
+	if !ok {
+		return
+	}
+
 	// Get category ID from category name
 	category, err := h.DB.GetCategoryByName(newPost.Category)
 	if err != nil {
@@ -365,19 +476,59 @@ func (h *Handler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid category", http.StatusBadRequest)
 		return
 	}
+	// Archived state and posting rules are inherited from parent categories
+	if err := h.DB.CanPostInCategory(userID, category.ID); err != nil {
+		switch {
+		case errors.Is(err, database.ErrCategoryArchived):
+			http.Error(w, "Category is archived", http.StatusBadRequest)
+		case errors.Is(err, database.ErrPostingRestricted), errors.Is(err, database.ErrAccountTooNew):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			log.Printf("Error checking posting rules: %v", err)
+			http.Error(w, "Failed to create post", http.StatusInternalServerError)
+		}
+		return
+	}
+	if !h.checkSlowMode(w, userID, category.ID) || !h.rateLimit(w, r, ratelimit.ActionPost, userID) {
+		return
+	}
+
+	verdict, ok := h.applyAutomod(w, database.AutomodInput{
+		TargetType: database.TargetPost,
+		AuthorID:   userID,
+		Title:      newPost.Title,
+		Content:    newPost.Content,
+	})
+	if !ok {
+		return
+	}
 
 	// Create the post in the database with category ID
-	err = h.DB.CreatePost(userID, category.ID, newPost.Title, newPost.Content)
+	postID, err := h.DB.CreatePost(userID, category.ID, verdict.Title, verdict.Content, tags, verdict.HoldRule())
 	if err != nil {
 		log.Printf("Error creating post: %v", err)
 		http.Error(w, "Failed to create post", http.StatusInternalServerError)
 		return
 	}
 
+	message := "Post created successfully"
+	held := verdict.HoldRule() != nil
+	if held {
+		message = heldMessage
+	}
+	h.linkAttachments(userID, attachmentIDs, database.TargetPost, postID)
+	if err := h.DB.SetSubscription(userID, database.TargetPost, postID, database.SubscriptionWatch); err != nil {
+		log.Printf("Error watching new post: %v", err)
+	}
+	h.recordMentions(database.TargetPost, postID, userID, verdict.Content, held, h.notMutedIn(postID))
+	if !held {
+		h.notifyFollowers(userID, postID)
+	}
+
 	// Respond with success
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(PostResponse{Success: true, Message: "Post created successfully"})
+	json.NewEncoder(w).Encode(PostResponse{Success: true, Message: message, PostID: postID})
 }
 
 type LogoutResponse struct {
@@ -423,54 +574,47 @@ func (h *Handler) GetPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check for category filter in query params
-	categoryIDStr := r.URL.Query().Get("category")
-	var posts []database.Post
-	var err error
-
-	if categoryIDStr != "" {
-		// If category filter is provided, get posts for that category
-		categoryID, err := strconv.Atoi(categoryIDStr)
+	// Check for category, tag and sort filters in query params
+	filter, err := parsePostFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Listing posts needs no account, but signed in readers see unread counts
+	if userID, err := h.authenticate(r); err == nil {
+		filter.ReaderID = userID
+	}
+	switch r.URL.Query().Get("feed") {
+	case "":
+	case "following":
+		if filter.ReaderID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		filter.FollowerID = filter.ReaderID
+	default:
+		http.Error(w, "Invalid feed", http.StatusBadRequest)
+		return
+	}
+	// Both feeds list everything unless a page is asked for
+	if query := r.URL.Query(); query.Has("page") || query.Has("limit") {
+		_, limit, offset, err := parsePage(r)
 		if err != nil {
-			http.Error(w, "Invalid category ID", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		posts, err = h.DB.GetPostsByCategory(categoryID)
-	} else {
-		// Otherwise get all posts
-		posts, err = h.DB.GetAllPosts()
+		filter.Limit, filter.Offset = limit, offset
 	}
 
+	posts, err := h.DB.ListPosts(filter)
 	if err != nil {
 		log.Printf("Error retrieving posts: %v", err)
 		http.Error(w, "Failed to retrieve posts", http.StatusInternalServerError)
 		return
 	}
 
-	// Format response
-	type PostResponse struct {
-		ID        int    `json:"id"`
-		Title     string `json:"title"`
-		Content   string `json:"content"`
-		Category  string `json:"category"`
-		Author    string `json:"author"`
-		CreatedAt string `json:"createdAt"`
-	}
-
-	var responsePosts []PostResponse
-	for _, post := range posts {
-		responsePosts = append(responsePosts, PostResponse{
-			ID:        post.ID,
-			Title:     post.Title,
-			Content:   post.Content,
-			Category:  post.Category,
-			Author:    post.Author,
-			CreatedAt: post.CreatedAt.Format(time.RFC3339),
-		})
-	}
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(responsePosts)
+	json.NewEncoder(w).Encode(newPostItems(posts))
 }
 
 // GetCategories returns all available categories
@@ -489,12 +633,7 @@ func (h *Handler) GetCategories(w http.ResponseWriter, r *http.Request) {
 
 	var response []CategoryResponse
 	for _, cat := range categories {
-		response = append(response, CategoryResponse{
-			ID:          cat.ID,
-			Name:        cat.Name,
-			Description: cat.Description,
-			PostCount:   cat.PostCount,
-		})
+		response = append(response, newCategoryResponse(cat))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -502,13 +641,14 @@ func (h *Handler) GetCategories(w http.ResponseWriter, r *http.Request) {
 }
 
 type UserStatus struct {
-	ID       int       `json:"id"`
-	Nickname string    `json:"nickname"`
-	Online   bool      `json:"online"`
-	LastSeen time.Time `json:"lastSeen"`
+	ID       int        `json:"id"`
+	Nickname string     `json:"nickname"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
 }
 
-// GetOnlineUsers returns all users with their online status
+// GetOnlineUsers returns all users with their online status, as far as their
+// privacy settings let the viewer see it
 func (h *Handler) GetOnlineUsers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -521,16 +661,43 @@ func (h *Handler) GetOnlineUsers(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
 		return
 	}
+	viewerID, _ := h.authenticate(r)
+	privacy, err := h.DB.PrivacyView(viewerID, nil)
+	if err != nil {
+		log.Printf("Error retrieving privacy settings: %v", err)
+		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+		return
+	}
+	blocked, err := h.DB.BlockedWith(viewerID)
+	if err != nil {
+		log.Printf("Error retrieving blocks: %v", err)
+		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+		return
+	}
 
 	var response []UserStatus
 	for _, user := range users {
-		response = append(response, UserStatus{
+		if blocked[user.User.ID] {
+			continue
+		}
+		status := UserStatus{
 			ID:       user.User.ID,
 			Nickname: user.User.Nickname,
-			Online:   user.Online,
-			LastSeen: user.LastSeen,
-		})
+			Online:   user.Online && privacy.Online(user.User.ID),
+		}
+		if privacy.LastSeen(user.User.ID) {
+			status.LastSeen = &user.LastSeen
+		}
+		response = append(response, status)
 	}
+	// The database lists online users first; sort again so that users who
+	// appear offline are not given away by their position
+	sort.Slice(response, func(i, j int) bool {
+		if response[i].Online != response[j].Online {
+			return response[i].Online
+		}
+		return response[i].Nickname < response[j].Nickname
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -598,6 +765,9 @@ func (h *Handler) AddComment(w http.ResponseWriter, r *http.Request, postIDStr s
 		return
 	}
 	userID := int(claims.UserID)
+	if h.rejectMuted(w, userID) {
+		return
+	}
 
 	var newComment NewCommentRequest
 	if err := json.NewDecoder(r.Body).Decode(&newComment); err != nil || newComment.Content == "" {
@@ -605,30 +775,77 @@ func (h *Handler) AddComment(w http.ResponseWriter, r *http.Request, postIDStr s
 		return
 	}
 
-	if exists, err := h.DB.PostExists(postID); err != nil || !exists {
+	post, err := h.DB.GetPostByID(postID)
+	if err != nil || post.Hidden {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	var parent *database.Comment
+	if newComment.ParentID != 0 {
+		parent, err = h.DB.GetCommentByID(newComment.ParentID)
+		if err != nil || parent.PostID != postID {
+			http.Error(w, "Invalid parent comment", http.StatusBadRequest)
+			return
+		}
+	}
+	attachmentIDs, ok := h.checkAttachments(w, userID, newComment.Attachments)
+	if !ok {
+		return
+	}
+	if !h.rateLimit(w, r, ratelimit.ActionComment, userID) {
+		return
+	}
 
-	commentID, err := h.DB.CreateComment(userID, postID, newComment.Content)
+	verdict, ok := h.applyAutomod(w, database.AutomodInput{
+		TargetType: database.TargetComment,
+		AuthorID:   userID,
+		Content:    newComment.Content,
+	})
+	if !ok {
+		return
+	}
+
+	commentID, err := h.DB.CreateComment(userID, postID, newComment.ParentID, verdict.Content, verdict.HoldRule())
 	if err != nil {
+		log.Printf("Error creating comment: %v", err)
 		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
 		return
 	}
 
-	user, _ := h.DB.GetUserByID(userID)
-	comment := Comment{
-		ID:        commentID,
-		Content:   newComment.Content,
-		PostID:    postID,
-		AuthorID:  userID,
-		Author:    user.Nickname,
-		CreatedAt: time.Now(),
+	h.linkAttachments(userID, attachmentIDs, database.TargetComment, commentID)
+	message := "Comment added successfully"
+	held := verdict.HoldRule() != nil
+	if held {
+		message = heldMessage
+	} else {
+		h.notifyReply(post, parent, userID)
+	}
+	h.recordMentions(database.TargetComment, commentID, userID, verdict.Content, held, h.notMutedIn(postID))
+	mentions, err := h.DB.GetMentions(database.TargetComment, commentID)
+	if err != nil {
+		log.Printf("Error loading mentions: %v", err)
+	}
+	attached, err := h.DB.GetAttachments(database.TargetComment, commentID)
+	if err != nil {
+		log.Printf("Error loading attachments: %v", err)
 	}
 
+	user, _ := h.DB.GetUserByID(userID)
+	comment := newCommentItem(database.Comment{
+		ID:          commentID,
+		Content:     verdict.Content,
+		PostID:      postID,
+		ParentID:    newComment.ParentID,
+		UserID:      userID,
+		Author:      user.Nickname,
+		CreatedAt:   time.Now(),
+		Mentions:    mentions,
+		Attachments: attached,
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(CommentResponse{Success: true, Message: "Comment added successfully", Comment: comment})
+	json.NewEncoder(w).Encode(CommentResponse{Success: true, Message: message, Comment: comment})
 }
 
 // GetPostByID handles retrieving a single post by its ID
@@ -649,23 +866,24 @@ func (h *Handler) GetPostByID(w http.ResponseWriter, r *http.Request, postIDStr
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	// Hidden posts stay visible to the moderators reviewing them
+	if post.Hidden && !h.canReview(r, post.CategoryID) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
 
-	type PostResponse struct {
-		ID        int    `json:"id"`
-		Title     string `json:"title"`
-		Content   string `json:"content"`
-		Category  string `json:"category"`
-		Author    string `json:"author"`
-		CreatedAt string `json:"createdAt"`
+	viewer := "ip:" + h.clientIP(r)
+	if userID, err := h.authenticate(r); err == nil {
+		viewer = "user:" + strconv.Itoa(userID)
+	}
+	if err := h.DB.RecordPostView(postID, viewer); err != nil {
+		log.Printf("Error recording post view: %v", err)
 	}
 
-	response := PostResponse{
-		ID:        post.ID,
-		Title:     post.Title,
-		Content:   post.Content,
-		Category:  post.Category,
-		Author:    post.Author,
-		CreatedAt: post.CreatedAt.Format(time.RFC3339),
+	response := newPostItem(*post)
+	response.Breadcrumbs, err = h.DB.GetCategoryBreadcrumbs(post.CategoryID)
+	if err != nil {
+		log.Printf("Error retrieving breadcrumbs: %v", err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -686,19 +904,19 @@ func (h *Handler) GetComments(w http.ResponseWriter, r *http.Request, postIDStr
 		return
 	}
 	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	if _, err := h.VerifyJWTToken(tokenString); err != nil {
+	claims, err := h.VerifyJWTToken(tokenString)
+	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// Check if the post exists
-	postExists, err := h.DB.PostExists(postID)
+	post, err := h.DB.GetPostByID(postID)
 	if err != nil {
-		log.Printf("Error checking post existence: %v", err)
-		http.Error(w, "Failed to validate post", http.StatusInternalServerError)
+		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
-	if !postExists {
+	// Comments of hidden posts stay visible to the moderators reviewing them
+	if post.Hidden && !h.canReview(r, post.CategoryID) {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
@@ -711,9 +929,40 @@ func (h *Handler) GetComments(w http.ResponseWriter, r *http.Request, postIDStr
 		return
 	}
 
+	lastCommentID := 0
+	for _, c := range comments {
+		lastCommentID = max(lastCommentID, c.ID)
+	}
+	// Comments by users the reader blocked or muted are left out
+	ignored, err := h.DB.IgnoredUsers(int(claims.UserID))
+	if err != nil {
+		log.Printf("Error retrieving blocks: %v", err)
+		http.Error(w, "Failed to retrieve comments", http.StatusInternalServerError)
+		return
+	}
+	comments = slices.DeleteFunc(comments, func(c database.Comment) bool { return ignored[c.UserID] })
+
+	// ?from=unread skips to the first comment by someone else not read yet
+	if r.URL.Query().Get("from") == "unread" {
+		lastRead, err := h.DB.LastReadComment(int(claims.UserID), postID)
+		if err != nil {
+			log.Printf("Error retrieving read marker: %v", err)
+			http.Error(w, "Failed to retrieve comments", http.StatusInternalServerError)
+			return
+		}
+		comments = fromFirstUnread(comments, int(claims.UserID), lastRead)
+	}
+
 	// Format response to match the client-side expectations
 	// Note: The frontend code seems to expect a direct array of comments
 	// without a wrapper object like we use for other responses
+	response := make([]Comment, 0, len(comments))
+	for _, c := range comments {
+		response = append(response, newCommentItem(c))
+	}
+	if err := h.DB.MarkPostRead(int(claims.UserID), postID, lastCommentID); err != nil {
+		log.Printf("Error marking post read: %v", err)
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments)
+	json.NewEncoder(w).Encode(response)
 }
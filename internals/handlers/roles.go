@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real-time-forum/internals/database"
+)
+
+// roleAuditLimit caps the number of audit entries returned at once
+const roleAuditLimit = 100
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type CategoryModeratorRequest struct {
+	CategoryID int `json:"categoryId"`
+}
+
+type RoleResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+func sendRoleResponse(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RoleResponse{Success: true, Message: message})
+}
+
+// roleError maps database errors from role changes to HTTP responses
+func roleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrCategoryNotFound):
+		http.Error(w, "Category not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrNotModerator):
+		http.Error(w, "User does not moderate this category", http.StatusNotFound)
+	case errors.Is(err, database.ErrUnknownRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error changing roles: %v", err)
+		http.Error(w, "Failed to change role", http.StatusInternalServerError)
+	}
+}
+
+// SetUserRole changes the global role of a user (requires role.manage)
+func (h *Handler) SetUserRole(w http.ResponseWriter, r *http.Request, userIDStr string) {
+	actorID, ok := h.requirePermission(w, r, database.PermRoleManage, 0)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if userID == actorID {
+		http.Error(w, "You cannot change your own role", http.StatusBadRequest)
+		return
+	}
+
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Role == "" {
+		http.Error(w, "Role is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.SetUserRole(actorID, userID, req.Role); err != nil {
+		roleError(w, err)
+		return
+	}
+	sendRoleResponse(w, "Role updated")
+}
+
+// GrantCategoryModerator makes a user moderator of a category (requires role.manage)
+func (h *Handler) GrantCategoryModerator(w http.ResponseWriter, r *http.Request, userIDStr string) {
+	actorID, ok := h.requirePermission(w, r, database.PermRoleManage, 0)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CategoryModeratorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CategoryID == 0 {
+		http.Error(w, "Category is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.GrantCategoryModerator(actorID, userID, req.CategoryID); err != nil {
+		roleError(w, err)
+		return
+	}
+	sendRoleResponse(w, "Category moderator granted")
+}
+
+// RevokeCategoryModerator removes a user's moderator assignment (requires role.manage)
+func (h *Handler) RevokeCategoryModerator(w http.ResponseWriter, r *http.Request, userIDStr, categoryIDStr string) {
+	actorID, ok := h.requirePermission(w, r, database.PermRoleManage, 0)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	categoryID, err := strconv.Atoi(categoryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.RevokeCategoryModerator(actorID, userID, categoryID); err != nil {
+		roleError(w, err)
+		return
+	}
+	sendRoleResponse(w, "Category moderator revoked")
+}
+
+// GetRoleAudit lists recent role changes (requires role.manage)
+func (h *Handler) GetRoleAudit(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requirePermission(w, r, database.PermRoleManage, 0); !ok {
+		return
+	}
+
+	entries, err := h.DB.GetRoleAudit(roleAuditLimit)
+	if err != nil {
+		log.Printf("Error retrieving role audit: %v", err)
+		http.Error(w, "Failed to retrieve audit trail", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
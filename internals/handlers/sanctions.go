@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/realtime"
+)
+
+type SanctionRequest struct {
+	Kind          string `json:"kind"` // suspension or mute
+	Reason        string `json:"reason"`
+	DurationHours int    `json:"durationHours"` // 0 is permanent
+}
+
+type SanctionResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	SanctionID int    `json:"sanctionId,omitempty"`
+}
+
+// rejectMuted writes a 403 with the mute's terms when the user is muted and
+// reports whether the request was rejected
+func (h *Handler) rejectMuted(w http.ResponseWriter, userID int) bool {
+	err := h.DB.CheckNotMuted(userID)
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, database.ErrUserMuted) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+	} else {
+		log.Printf("Error checking mute: %v", err)
+		http.Error(w, "Failed to check account status", http.StatusInternalServerError)
+	}
+	return true
+}
+
+// kickUser closes the live connections of a user who was just suspended
+func (h *Handler) kickUser(userID int, reason string) {
+	h.Hub.Disconnect(userID, realtime.Event{
+		Type: EventDisconnected,
+		Data: map[string]string{"reason": reason},
+	})
+}
+
+// sanctionError maps database errors from sanctions to HTTP responses
+func sanctionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrSanctionNotFound):
+		http.Error(w, "Sanction not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrUnknownSanction):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrOutranked):
+		http.Error(w, "You cannot sanction a user of equal or higher role", http.StatusForbidden)
+	default:
+		log.Printf("Error handling sanction: %v", err)
+		http.Error(w, "Failed to update sanctions", http.StatusInternalServerError)
+	}
+}
+
+// SanctionUser suspends or mutes a user (requires user.ban)
+func (h *Handler) SanctionUser(w http.ResponseWriter, r *http.Request, userIDStr string) {
+	actorID, ok := h.requirePermission(w, r, database.PermUserBan, 0)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if userID == actorID {
+		http.Error(w, "You cannot sanction yourself", http.StatusBadRequest)
+		return
+	}
+
+	var req SanctionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DurationHours < 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	duration := time.Duration(req.DurationHours) * time.Hour
+	sanctionID, err := h.DB.SanctionUser(actorID, userID, req.Kind, req.Reason, duration)
+	if err != nil {
+		sanctionError(w, err)
+		return
+	}
+	action := database.ModMute
+	if req.Kind == database.SanctionSuspension {
+		action = database.ModSuspend
+		h.kickUser(userID, req.Reason)
+	}
+	h.Notifier.Moderation(userID, action, database.TargetUser, userID, req.Reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(SanctionResponse{Success: true, Message: "Sanction applied", SanctionID: sanctionID})
+}
+
+// LiftSanction ends a suspension or mute early (requires user.ban)
+func (h *Handler) LiftSanction(w http.ResponseWriter, r *http.Request, userIDStr, sanctionIDStr string) {
+	actorID, ok := h.requirePermission(w, r, database.PermUserBan, 0)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	sanctionID, err := strconv.Atoi(sanctionIDStr)
+	if err != nil {
+		http.Error(w, "Invalid sanction ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.LiftSanction(actorID, userID, sanctionID); err != nil {
+		sanctionError(w, err)
+		return
+	}
+	h.Notifier.Moderation(userID, database.ModLift, database.TargetUser, userID, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SanctionResponse{Success: true, Message: "Sanction lifted"})
+}
+
+// GetUserSanctions lists every sanction of a user (requires user.ban)
+func (h *Handler) GetUserSanctions(w http.ResponseWriter, r *http.Request, userIDStr string) {
+	if _, ok := h.requirePermission(w, r, database.PermUserBan, 0); !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	h.writeSanctions(w, userID)
+}
+
+// GetMySanctions lets users see their own sanctions and the reasons given
+func (h *Handler) GetMySanctions(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.writeSanctions(w, userID)
+}
+
+func (h *Handler) writeSanctions(w http.ResponseWriter, userID int) {
+	sanctions, err := h.DB.GetUserSanctions(userID)
+	if err != nil {
+		log.Printf("Error retrieving sanctions: %v", err)
+		http.Error(w, "Failed to retrieve sanctions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sanctions)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"real-time-forum/internals/database"
+)
+
+// userSettingError maps database errors from user settings to HTTP responses
+func userSettingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrUnknownUserSetting), errors.Is(err, database.ErrInvalidUserSetting):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error managing user settings: %v", err)
+		http.Error(w, "Failed to update settings", http.StatusInternalServerError)
+	}
+}
+
+// GetMySettings returns the preferences of the current user
+func (h *Handler) GetMySettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := h.DB.GetUserSettings(userID)
+	if err != nil {
+		userSettingError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// UpdateMySettings changes preferences of the current user; an empty value
+// restores a setting's default
+func (h *Handler) UpdateMySettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var settings map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	for key, value := range settings {
+		if err := h.DB.SetUserSetting(userID, key, value); err != nil {
+			userSettingError(w, err)
+			return
+		}
+	}
+	h.GetMySettings(w, r)
+}
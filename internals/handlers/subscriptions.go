@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real-time-forum/internals/database"
+)
+
+// watchedThreadsLimit caps the number of watched threads listed at once
+const watchedThreadsLimit = 50
+
+type SubscriptionRequest struct {
+	Level string `json:"level"` // "watch" or "mute"
+}
+
+type SubscriptionResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// subscriptionError maps database errors from subscriptions to HTTP responses
+func subscriptionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrInvalidSubscription):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrPostNotFound):
+		http.Error(w, "Post not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrCategoryNotFound):
+		http.Error(w, "Category not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrSubscriptionNotFound):
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+	default:
+		log.Printf("Error managing subscriptions: %v", err)
+		http.Error(w, "Failed to update subscription", http.StatusInternalServerError)
+	}
+}
+
+// notifyReply notifies the author of the post and of the comment replied to,
+// then everyone watching the thread, each at most once. Users who muted the
+// thread are left out.
+func (h *Handler) notifyReply(post *database.Post, parent *database.Comment, authorID int) {
+	levels, err := h.DB.ThreadSubscriptions(post.ID)
+	if err != nil {
+		log.Printf("Error loading subscriptions of post %d: %v", post.ID, err)
+	}
+
+	notified := map[int]bool{authorID: true}
+	send := func(userID int, kind, targetType string, targetID int) {
+		if notified[userID] || levels[userID] == database.SubscriptionMute {
+			return
+		}
+		notified[userID] = true
+		h.Notifier.Send(database.NotificationEvent{
+			UserID:     userID,
+			Kind:       kind,
+			ActorID:    authorID,
+			TargetType: targetType,
+			TargetID:   targetID,
+		})
+	}
+
+	if parent != nil {
+		send(parent.UserID, database.NotifyCommentReply, database.TargetComment, parent.ID)
+	}
+	send(post.UserID, database.NotifyPostReply, database.TargetPost, post.ID)
+	for userID, level := range levels {
+		if level == database.SubscriptionWatch {
+			send(userID, database.NotifyThreadReply, database.TargetPost, post.ID)
+		}
+	}
+}
+
+// threadMuted reports whether a user muted a post or its category
+func (h *Handler) threadMuted(userID, postID int) bool {
+	level, err := h.DB.ThreadSubscription(userID, postID)
+	if err != nil {
+		log.Printf("Error loading subscription of post %d: %v", postID, err)
+		return false
+	}
+	return level == database.SubscriptionMute
+}
+
+// notMutedIn filters out mentions of users who muted the thread of a post
+// and chose to silence mentions there too
+func (h *Handler) notMutedIn(postID int) func(database.Mention) bool {
+	return func(m database.Mention) bool {
+		if !h.threadMuted(m.UserID, postID) {
+			return true
+		}
+		allowed, err := h.DB.GetUserSetting(m.UserID, database.UserSettingMutedThreadMentions)
+		if err != nil {
+			log.Printf("Error checking mention settings: %v", err)
+			return true
+		}
+		return allowed == "true"
+	}
+}
+
+// SetSubscription watches or mutes a post or category for the current user
+func (h *Handler) SetSubscription(w http.ResponseWriter, r *http.Request, targetType, targetIDStr string) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	targetID, err := strconv.Atoi(targetIDStr)
+	if err != nil {
+		http.Error(w, "Invalid "+targetType+" ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.SetSubscription(userID, targetType, targetID, req.Level); err != nil {
+		subscriptionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SubscriptionResponse{Success: true, Message: "Subscription updated"})
+}
+
+// DeleteSubscription stops watching or muting a post or category
+func (h *Handler) DeleteSubscription(w http.ResponseWriter, r *http.Request, targetType, targetIDStr string) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	targetID, err := strconv.Atoi(targetIDStr)
+	if err != nil {
+		http.Error(w, "Invalid "+targetType+" ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.DeleteSubscription(userID, targetType, targetID); err != nil {
+		subscriptionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SubscriptionResponse{Success: true, Message: "Subscription removed"})
+}
+
+// GetSubscriptions lists the posts and categories the current user watches or mutes
+func (h *Handler) GetSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	subscriptions, err := h.DB.GetSubscriptions(userID)
+	if err != nil {
+		log.Printf("Error retrieving subscriptions: %v", err)
+		http.Error(w, "Failed to retrieve subscriptions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscriptions)
+}
+
+// GetWatchedThreads lists the posts the current user watches with their unread comment counts
+func (h *Handler) GetWatchedThreads(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	threads, err := h.DB.GetWatchedThreads(userID, watchedThreadsLimit)
+	if err != nil {
+		log.Printf("Error retrieving watched threads: %v", err)
+		http.Error(w, "Failed to retrieve watched threads", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(threads)
+}
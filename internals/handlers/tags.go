@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+
+	"real-time-forum/internals/database"
+)
+
+// tagAutocompleteLimit caps the number of suggestions returned by SearchTags
+const tagAutocompleteLimit = 10
+
+type TagPageResponse struct {
+	Tag   database.Tag `json:"tag"`
+	Posts []PostItem   `json:"posts"`
+}
+
+// SearchTags returns tags for autocomplete, or the most used tags when no
+// prefix is given
+func (h *Handler) SearchTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tags, err := h.DB.SearchTags(r.URL.Query().Get("q"), tagAutocompleteLimit)
+	if err != nil {
+		log.Printf("Error searching tags: %v", err)
+		http.Error(w, "Failed to retrieve tags", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// GetTagPage returns a tag with its post count and the posts carrying it.
+// The category and sort query parameters narrow the listing further.
+func (h *Handler) GetTagPage(w http.ResponseWriter, r *http.Request, rawName string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, err := database.NormalizeTag(rawName)
+	if err != nil {
+		http.Error(w, "Invalid tag", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := h.DB.GetTagByName(name)
+	if err != nil {
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+
+	filter, err := parsePostFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(filter.Tags, tag.Name) {
+		filter.Tags = append(filter.Tags, tag.Name)
+	}
+	if userID, err := h.authenticate(r); err == nil {
+		filter.ReaderID = userID
+	}
+
+	posts, err := h.DB.ListPosts(filter)
+	if err != nil {
+		log.Printf("Error retrieving tag posts: %v", err)
+		http.Error(w, "Failed to retrieve posts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TagPageResponse{Tag: *tag, Posts: newPostItems(posts)})
+}
@@ -0,0 +1,54 @@
+// Package imaging validates uploaded pictures and scales them with pure Go
+// code, for avatars and attachment thumbnails.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+var (
+	ErrTooLarge    = errors.New("image too large")
+	ErrUnsupported = errors.New("unsupported image")
+)
+
+// Formats maps the sniffed content types of decodable pictures to the names
+// image.Decode gives their formats
+var Formats = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpeg",
+	"image/gif":  "gif",
+}
+
+// Decode decodes a picture without trusting its name or declared type: the
+// content has to sniff as, and parse as, the same accepted format. The
+// dimensions are checked before the pixels are decoded. Animated GIFs keep
+// their first frame.
+func Decode(data []byte, maxDimension int) (image.Image, string, error) {
+	format, ok := Formats[http.DetectContentType(data)]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: only PNG, JPEG and GIF images are accepted", ErrUnsupported)
+	}
+	config, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decoded != format {
+		return nil, "", fmt.Errorf("%w: the file is not a valid %s image", ErrUnsupported, format)
+	}
+	if config.Width < 1 || config.Height < 1 {
+		return nil, "", fmt.Errorf("%w: the image is empty", ErrUnsupported)
+	}
+	if config.Width > maxDimension || config.Height > maxDimension {
+		return nil, "", fmt.Errorf("%w: images are limited to %dx%d pixels", ErrTooLarge, maxDimension, maxDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: the file is not a valid %s image", ErrUnsupported, format)
+	}
+	return img, format, nil
+}
@@ -0,0 +1,112 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// SquareCrop copies the centered square of an image into an RGBA image,
+// which the resizing works on
+func SquareCrop(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, origin, draw.Src)
+	return square
+}
+
+// Fit scales an image down, keeping its proportions, so that neither side
+// is longer than maxSide. Smaller images are only copied.
+func Fit(img image.Image, maxSide int) *image.RGBA {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	if b.Dx() <= maxSide && b.Dy() <= maxSide {
+		return src
+	}
+	width, height := maxSide, max(1, b.Dy()*maxSide/b.Dx())
+	if b.Dy() > b.Dx() {
+		width, height = max(1, b.Dx()*maxSide/b.Dy()), maxSide
+	}
+	return Resize(src, width, height)
+}
+
+// contribution is the weighted span of source pixels averaged into one
+// destination pixel
+type contribution struct {
+	first   int
+	weights []float64
+}
+
+// boxWeights spreads srcLen pixels over dstLen, weighting every source pixel
+// by how much of it a destination pixel covers. Shrinking averages, growing
+// repeats pixels.
+func boxWeights(srcLen, dstLen int) []contribution {
+	scale := float64(srcLen) / float64(dstLen)
+	contribs := make([]contribution, dstLen)
+	for i := range contribs {
+		lo, hi := float64(i)*scale, float64(i+1)*scale
+		first, last := int(lo), min(int(math.Ceil(hi)), srcLen)
+		weights := make([]float64, 0, last-first)
+		var sum float64
+		for j := first; j < last; j++ {
+			w := math.Min(hi, float64(j+1)) - math.Max(lo, float64(j))
+			weights = append(weights, w)
+			sum += w
+		}
+		for k := range weights {
+			weights[k] /= sum
+		}
+		contribs[i] = contribution{first, weights}
+	}
+	return contribs
+}
+
+// Resize scales an RGBA image with its origin at 0,0 to width×height with a
+// box filter, first across then down. RGBA is premultiplied, so transparent
+// pixels do not bleed their color into the average.
+func Resize(src *image.RGBA, width, height int) *image.RGBA {
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	if srcWidth == width && srcHeight == height {
+		return src
+	}
+	across, down := boxWeights(srcWidth, width), boxWeights(srcHeight, height)
+
+	// Horizontal pass: srcHeight rows of width pixels
+	tmp := make([]float64, srcHeight*width*4)
+	for y := 0; y < srcHeight; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, c := range across {
+			out := tmp[(y*width+x)*4:]
+			for k, w := range c.weights {
+				p := row[(c.first+k)*4:]
+				out[0] += w * float64(p[0])
+				out[1] += w * float64(p[1])
+				out[2] += w * float64(p[2])
+				out[3] += w * float64(p[3])
+			}
+		}
+	}
+
+	// Vertical pass
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, c := range down {
+		for x := 0; x < width; x++ {
+			var px [4]float64
+			for k, w := range c.weights {
+				in := tmp[((c.first+k)*width+x)*4:]
+				px[0] += w * in[0]
+				px[1] += w * in[1]
+				px[2] += w * in[2]
+				px[3] += w * in[3]
+			}
+			out := dst.Pix[y*dst.Stride+x*4:]
+			for i := range px {
+				out[i] = uint8(math.Min(255, math.Round(px[i])))
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,112 @@
+// Package mail sends multipart text and HTML email through a pluggable
+// backend: SMTP in production or a directory of .eml files for tests.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"sort"
+	"time"
+)
+
+// Message is an email with plain text and HTML alternatives
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string // extra headers, such as List-Unsubscribe
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromEnv picks a mailer from FORUM_MAIL_BACKEND: "smtp" uses FORUM_SMTP_ADDR,
+// FORUM_SMTP_USER and FORUM_SMTP_PASSWORD, "file" drops messages into
+// FORUM_MAIL_DIR (./mail by default). Either sends from FORUM_MAIL_FROM.
+// It returns nil when no backend is configured, which disables email.
+func FromEnv() Mailer {
+	from := os.Getenv("FORUM_MAIL_FROM")
+	if from == "" {
+		from = "forum@localhost"
+	}
+
+	switch backend := os.Getenv("FORUM_MAIL_BACKEND"); backend {
+	case "":
+		return nil
+	case "smtp":
+		addr := os.Getenv("FORUM_SMTP_ADDR")
+		if addr == "" {
+			log.Printf("FORUM_MAIL_BACKEND=smtp needs FORUM_SMTP_ADDR, email is disabled")
+			return nil
+		}
+		return NewSMTP(addr, os.Getenv("FORUM_SMTP_USER"), os.Getenv("FORUM_SMTP_PASSWORD"), from)
+	case "file":
+		dir := os.Getenv("FORUM_MAIL_DIR")
+		if dir == "" {
+			dir = "./mail"
+		}
+		return NewFileDrop(dir, from)
+	default:
+		log.Printf("ignoring unknown FORUM_MAIL_BACKEND=%q, email is disabled", backend)
+		return nil
+	}
+}
+
+// build encodes a message as multipart/alternative MIME, text part first
+func build(from string, msg Message) ([]byte, error) {
+	var boundary [12]byte
+	if _, err := rand.Read(boundary[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate MIME boundary: %w", err)
+	}
+	b := hex.EncodeToString(boundary[:])
+
+	headers := map[string]string{
+		"From":         from,
+		"To":           msg.To,
+		"Subject":      mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"MIME-Version": "1.0",
+		"Content-Type": `multipart/alternative; boundary="` + b + `"`,
+	}
+	for k, v := range msg.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, headers[k])
+	}
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		fmt.Fprintf(&buf, "--%s\r\n", b)
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=utf-8\r\n", part.contentType)
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(&buf)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, fmt.Errorf("failed to encode %s part: %w", part.contentType, err)
+		}
+		qp.Close()
+		buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", b)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,62 @@
+package markdown
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+// cacheSize is the number of rendered documents kept in memory
+const cacheSize = 4096
+
+// cache keeps rendered HTML keyed by the hash of the source, dropping the
+// least recently used entries once full
+type cache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List // most recently used first
+}
+
+type cacheEntry struct {
+	key  [sha256.Size]byte
+	html string
+}
+
+var rendered = &cache{entries: make(map[[sha256.Size]byte]*list.Element), order: list.New()}
+
+// Render converts Markdown to sanitized HTML. Output is cached by content
+// hash, so the same text is only rendered once.
+func Render(src string) string {
+	key := sha256.Sum256([]byte(src))
+	if html, ok := rendered.get(key); ok {
+		return html
+	}
+	html := render(src)
+	rendered.put(key, html)
+	return html
+}
+
+func (c *cache) get(key [sha256.Size]byte) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).html, true
+}
+
+func (c *cache) put(key [sha256.Size]byte, html string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, html: html})
+	for c.order.Len() > cacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
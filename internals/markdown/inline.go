@@ -0,0 +1,257 @@
+package markdown
+
+import (
+	"html"
+	"strings"
+)
+
+// renderInline writes code spans, links, emphasis and escaped text. Links are
+// not nested, so inLink disables them inside link text.
+func renderInline(b *strings.Builder, s string, inLink bool) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '\n':
+			b.WriteString("<br>\n")
+			i++
+			continue
+		case c == '`':
+			if end, ok := renderCodeSpan(b, s, i); ok {
+				i = end
+				continue
+			}
+			n := runLength(s, i)
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+		case c == '[' && !inLink:
+			if end, ok := renderLink(b, s, i); ok {
+				i = end
+				continue
+			}
+		case c == '<' && !inLink:
+			if end, ok := renderAutolink(b, s, i); ok {
+				i = end
+				continue
+			}
+		case c == 'h' && !inLink && (i == 0 || !isWordByte(s[i-1])):
+			if end, ok := renderBareURL(b, s, i); ok {
+				i = end
+				continue
+			}
+		case c == '*' || c == '_' || c == '~':
+			if end, ok := renderEmphasis(b, s, i, inLink); ok {
+				i = end
+				continue
+			}
+			n := runLength(s, i)
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+		}
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+// isWordByte treats bytes of multi-byte characters as letters
+func isWordByte(c byte) bool {
+	return c >= 0x80 || c == '_' || (c >= '0' && c <= '9') || (c|0x20 >= 'a' && c|0x20 <= 'z')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// runLength counts the repeats of the byte at i
+func runLength(s string, i int) int {
+	n := 1
+	for i+n < len(s) && s[i+n] == s[i] {
+		n++
+	}
+	return n
+}
+
+// renderCodeSpan writes a code span opened by a run of backticks at i, closed
+// by a run of the same length
+func renderCodeSpan(b *strings.Builder, s string, i int) (int, bool) {
+	n := runLength(s, i)
+	for j := i + n; j < len(s); {
+		if s[j] != '`' {
+			j++
+			continue
+		}
+		m := runLength(s, j)
+		if m != n {
+			j += m
+			continue
+		}
+		code := strings.ReplaceAll(s[i+n:j], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+			code = code[1 : len(code)-1]
+		}
+		b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+		return j + m, true
+	}
+	return 0, false
+}
+
+// renderLink writes [text](destination "title") starting at i
+func renderLink(b *strings.Builder, s string, i int) (int, bool) {
+	depth := 0
+	textEnd := -1
+	for j := i; j < len(s) && textEnd < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				textEnd = j
+			}
+		}
+	}
+	if textEnd < 0 || textEnd+1 >= len(s) || s[textEnd+1] != '(' {
+		return 0, false
+	}
+
+	// Parentheses inside the destination must be balanced
+	end := -1
+	depth = 0
+	for j := textEnd + 2; j < len(s) && end < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				end = j
+			}
+			depth--
+		}
+	}
+	if end < 0 {
+		return 0, false
+	}
+	dest, title, _ := strings.Cut(strings.TrimSpace(s[textEnd+2:end]), " ")
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	title = strings.TrimSpace(title)
+	if len(title) >= 2 && (title[0] == '"' || title[0] == '\'') && title[len(title)-1] == title[0] {
+		title = title[1 : len(title)-1]
+	} else {
+		title = ""
+	}
+
+	b.WriteString(`<a href="` + html.EscapeString(dest) + `"`)
+	if title != "" {
+		b.WriteString(` title="` + html.EscapeString(title) + `"`)
+	}
+	b.WriteString(">")
+	renderInline(b, s[i+1:textEnd], true)
+	b.WriteString("</a>")
+	return end + 1, true
+}
+
+// renderAutolink writes <scheme:address> links
+func renderAutolink(b *strings.Builder, s string, i int) (int, bool) {
+	end := strings.IndexAny(s[i+1:], "<> \t\n")
+	if end < 0 || s[i+1+end] != '>' {
+		return 0, false
+	}
+	url := s[i+1 : i+1+end]
+	if !strings.Contains(url, ":") {
+		return 0, false
+	}
+	writeLink(b, url)
+	return i + end + 2, true
+}
+
+// renderBareURL links http and https addresses written in plain text
+func renderBareURL(b *strings.Builder, s string, i int) (int, bool) {
+	if !strings.HasPrefix(s[i:], "http://") && !strings.HasPrefix(s[i:], "https://") {
+		return 0, false
+	}
+	end := i
+	for end < len(s) && !isSpace(s[end]) && s[end] != '<' {
+		end++
+	}
+	// Trailing punctuation usually ends the sentence rather than the address,
+	// and a closing parenthesis only belongs to it when one was opened
+	for end > i && strings.IndexByte(".,:;!?'\"*_~)", s[end-1]) >= 0 {
+		if s[end-1] == ')' && strings.Count(s[i:end], "(") >= strings.Count(s[i:end], ")") {
+			break
+		}
+		end--
+	}
+	if url := s[i:end]; !strings.HasSuffix(url, "://") {
+		writeLink(b, url)
+		return end, true
+	}
+	return 0, false
+}
+
+func writeLink(b *strings.Builder, url string) {
+	b.WriteString(`<a href="` + html.EscapeString(url) + `">` + html.EscapeString(url) + "</a>")
+}
+
+var emphasisTags = map[string]string{"*": "em", "_": "em", "**": "strong", "__": "strong", "~~": "del"}
+
+// renderEmphasis writes *em*, _em_, **strong**, __strong__ and ~~del~~.
+// Underscores inside words, as in snake_case, are left alone.
+func renderEmphasis(b *strings.Builder, s string, i int, inLink bool) (int, bool) {
+	c := s[i]
+	n := min(runLength(s, i), 2)
+	if c == '~' && n != 2 {
+		return 0, false
+	}
+	delim := s[i : i+n]
+	open := i + n
+	if open >= len(s) || isSpace(s[open]) || (c == '_' && i > 0 && isWordByte(s[i-1])) {
+		return 0, false
+	}
+
+	for j := open; j < len(s); j++ {
+		switch {
+		case s[j] == '\\':
+			j++
+			continue
+		case s[j] == '`':
+			// Delimiters inside code spans do not count
+			if end, ok := renderCodeSpan(&strings.Builder{}, s, j); ok {
+				j = end - 1
+			}
+			continue
+		case s[j] != c:
+			continue
+		}
+
+		m := runLength(s, j)
+		if n == 1 && m == 2 {
+			// Skip over the strong delimiters nested inside emphasis
+			j++
+			continue
+		}
+		if m < n || isSpace(s[j-1]) || (c == '_' && j+n < len(s) && isWordByte(s[j+n])) {
+			j += m - 1
+			continue
+		}
+
+		tag := emphasisTags[delim]
+		b.WriteString("<" + tag + ">")
+		renderInline(b, s[open:j], inLink)
+		b.WriteString("</" + tag + ">")
+		return j + n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,356 @@
+// Package markdown renders the Markdown of posts and comments to HTML that is
+// safe to insert into the page. Raw HTML in the source is always escaped, and
+// the rendered output goes through an allowlist sanitizer.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// languagePattern limits the info string of fenced code blocks used as a class
+var languagePattern = regexp.MustCompile(`^[A-Za-z0-9_+#.-]+$`)
+
+// render converts Markdown to sanitized HTML without caching
+func render(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"), false)
+	return Sanitize(b.String())
+}
+
+// renderBlocks writes the block structure of lines. In tight lists paragraphs
+// are written without <p> tags.
+func renderBlocks(b *strings.Builder, lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+		case isFence(line):
+			i = renderFence(b, lines, i)
+		case headingLevel(line) > 0:
+			renderHeading(b, line)
+			i++
+		case isRule(line):
+			b.WriteString("<hr>\n")
+			i++
+		case isQuote(line):
+			i = renderQuote(b, lines, i)
+		case isListItem(line):
+			i = renderList(b, lines, i)
+		default:
+			i = renderParagraph(b, lines, i, tight)
+		}
+	}
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// indentation counts leading spaces, with tabs as four
+func indentation(line string) int {
+	n := 0
+	for _, c := range line {
+		switch c {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// blockText strips up to three spaces of indentation and reports false when
+// the line is indented further, which keeps it out of block detection
+func blockText(line string) (string, bool) {
+	if indentation(line) > 3 {
+		return "", false
+	}
+	return strings.TrimLeft(line, " "), true
+}
+
+// startsBlock reports whether a line interrupts a paragraph
+func startsBlock(line string) bool {
+	return isFence(line) || headingLevel(line) > 0 || isRule(line) || isQuote(line) || isListItem(line)
+}
+
+// openFence returns the fence marker and info string of an opening fence line
+func openFence(line string) (string, string, bool) {
+	t, ok := blockText(line)
+	if !ok || len(t) < 3 || (t[0] != '`' && t[0] != '~') {
+		return "", "", false
+	}
+	n := 0
+	for n < len(t) && t[n] == t[0] {
+		n++
+	}
+	info := strings.TrimSpace(t[n:])
+	if n < 3 || (t[0] == '`' && strings.Contains(info, "`")) {
+		return "", "", false
+	}
+	return t[:n], info, true
+}
+
+func isFence(line string) bool {
+	_, _, ok := openFence(line)
+	return ok
+}
+
+// closesFence reports whether line closes a code block opened with marker
+func closesFence(line, marker string) bool {
+	t, ok := blockText(line)
+	if !ok {
+		return false
+	}
+	n := 0
+	for n < len(t) && t[n] == marker[0] {
+		n++
+	}
+	return n >= len(marker) && isBlank(t[n:])
+}
+
+func renderFence(b *strings.Builder, lines []string, i int) int {
+	marker, info, _ := openFence(lines[i])
+	var code []string
+	for i++; i < len(lines); i++ {
+		if closesFence(lines[i], marker) {
+			i++
+			break
+		}
+		code = append(code, lines[i])
+	}
+
+	b.WriteString("<pre><code")
+	if lang, _, _ := strings.Cut(info, " "); languagePattern.MatchString(lang) {
+		b.WriteString(` class="language-` + lang + `"`)
+	}
+	b.WriteString(">")
+	for _, line := range code {
+		b.WriteString(html.EscapeString(line))
+		b.WriteByte('\n')
+	}
+	b.WriteString("</code></pre>\n")
+	return i
+}
+
+// headingLevel returns 1 to 6 for ATX headings and 0 otherwise
+func headingLevel(line string) int {
+	t, ok := blockText(line)
+	if !ok {
+		return 0
+	}
+	n := 0
+	for n < len(t) && t[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || (n < len(t) && t[n] != ' ' && t[n] != '\t') {
+		return 0
+	}
+	return n
+}
+
+func renderHeading(b *strings.Builder, line string) {
+	level := headingLevel(line)
+	t, _ := blockText(line)
+	text := strings.TrimSpace(t[level:])
+	// An optional closing sequence of #s is not part of the heading
+	if trimmed := strings.TrimRight(text, "#"); trimmed == "" || strings.HasSuffix(trimmed, " ") {
+		text = strings.TrimSpace(trimmed)
+	}
+	tag := "h" + strconv.Itoa(level)
+	b.WriteString("<" + tag + ">")
+	renderInline(b, text, false)
+	b.WriteString("</" + tag + ">\n")
+}
+
+// isRule matches three or more -, * or _ characters, optionally spaced out
+func isRule(line string) bool {
+	t, ok := blockText(line)
+	if !ok || t == "" || !strings.ContainsRune("-*_", rune(t[0])) {
+		return false
+	}
+	count := 0
+	for _, c := range t {
+		switch {
+		case c == rune(t[0]):
+			count++
+		case c != ' ' && c != '\t':
+			return false
+		}
+	}
+	return count >= 3
+}
+
+func isQuote(line string) bool {
+	t, ok := blockText(line)
+	return ok && strings.HasPrefix(t, ">")
+}
+
+// renderQuote collects quoted lines, including unquoted lines continuing a
+// paragraph, and renders their content as blocks
+func renderQuote(b *strings.Builder, lines []string, i int) int {
+	var inner []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if isQuote(line) {
+			t, _ := blockText(line)
+			t = strings.TrimPrefix(t[1:], " ")
+			inner = append(inner, t)
+			continue
+		}
+		last := len(inner) - 1
+		if isBlank(line) || startsBlock(line) || isBlank(inner[last]) {
+			break
+		}
+		inner = append(inner, line)
+	}
+
+	b.WriteString("<blockquote>\n")
+	renderBlocks(b, inner, false)
+	b.WriteString("</blockquote>\n")
+	return i
+}
+
+// listMarker describes the marker of a list item line
+type listMarker struct {
+	ordered bool
+	start   int
+	width   int // indentation of the item content
+}
+
+func parseListMarker(line string) (listMarker, bool) {
+	t, ok := blockText(line)
+	if !ok || t == "" {
+		return listMarker{}, false
+	}
+	indent := len(line) - len(t)
+
+	var m listMarker
+	n := 0
+	if strings.ContainsRune("-*+", rune(t[0])) {
+		n = 1
+	} else {
+		for n < len(t) && n < 9 && t[n] >= '0' && t[n] <= '9' {
+			n++
+		}
+		if n == 0 || n >= len(t) || (t[n] != '.' && t[n] != ')') {
+			return listMarker{}, false
+		}
+		m.ordered = true
+		m.start, _ = strconv.Atoi(t[:n])
+		n++
+	}
+	if n < len(t) && t[n] != ' ' && t[n] != '\t' {
+		return listMarker{}, false
+	}
+	m.width = indent + n + 1
+	return m, true
+}
+
+func isListItem(line string) bool {
+	_, ok := parseListMarker(line)
+	return ok
+}
+
+// renderList collects the items of a list. Lines indented to the item's
+// content belong to it, so nested lists and quotes are rendered recursively.
+// A list is tight, without paragraphs in its items, unless blank lines
+// separate its content.
+func renderList(b *strings.Builder, lines []string, i int) int {
+	first, _ := parseListMarker(lines[i])
+	var items [][]string
+	width := 0
+	loose := false
+	blankBefore := false
+
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) {
+			blankBefore = true
+			if len(items) > 0 {
+				items[len(items)-1] = append(items[len(items)-1], "")
+			}
+			continue
+		}
+
+		if m, ok := parseListMarker(line); ok && (len(items) == 0 || indentation(line) < width) {
+			if m.ordered != first.ordered {
+				break
+			}
+			if blankBefore && len(items) > 0 {
+				loose = true
+			}
+			width = m.width
+			content := ""
+			if len(line) > width {
+				content = line[width:]
+			}
+			items = append(items, []string{content})
+			blankBefore = false
+			continue
+		}
+
+		current := &items[len(items)-1]
+		switch {
+		case indentation(line) >= width:
+			if blankBefore {
+				loose = true
+			}
+			*current = append(*current, strings.Repeat(" ", indentation(line)-width)+strings.TrimLeft(line, " \t"))
+		case !blankBefore && !startsBlock(line):
+			*current = append(*current, line)
+		default:
+			return finishList(b, first, items, loose, i)
+		}
+		blankBefore = false
+	}
+	return finishList(b, first, items, loose, i)
+}
+
+func finishList(b *strings.Builder, first listMarker, items [][]string, loose bool, i int) int {
+	tag := "ul"
+	if first.ordered {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag)
+	if first.ordered && first.start != 1 {
+		b.WriteString(` start="` + strconv.Itoa(first.start) + `"`)
+	}
+	b.WriteString(">\n")
+	for _, item := range items {
+		b.WriteString("<li>")
+		renderBlocks(b, item, !loose)
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// renderParagraph joins lines up to the next blank line or block. Line breaks
+// inside a paragraph are kept, as they are in chat.
+func renderParagraph(b *strings.Builder, lines []string, i int, tight bool) int {
+	var text []string
+	for ; i < len(lines); i++ {
+		if isBlank(lines[i]) || (len(text) > 0 && startsBlock(lines[i])) {
+			break
+		}
+		text = append(text, strings.TrimSpace(lines[i]))
+	}
+
+	if !tight {
+		b.WriteString("<p>")
+	}
+	renderInline(b, strings.Join(text, "\n"), false)
+	if !tight {
+		b.WriteString("</p>")
+	}
+	b.WriteByte('\n')
+	return i
+}
@@ -0,0 +1,126 @@
+package markdown
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const rel = ` rel="nofollow noopener noreferrer"`
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		// Unsafe links lose their tag but keep their text
+		{"javascript link", "[x](javascript:alert(1))", "<p>x</p>\n"},
+		{"mixed case scheme", "[x](JaVaScRiPt:alert(1))", "<p>x</p>\n"},
+		{"leading space", "[x]( javascript:alert(1))", "<p>x</p>\n"},
+		{"data link", "[x](data:text/html;base64,PHNjcmlwdD4=)", "<p>x</p>\n"},
+		{"javascript autolink", "<javascript:alert(1)>", "<p>javascript:alert(1)</p>\n"},
+		{"link", "[a](/x) [b](http://y)",
+			`<p><a href="/x"` + rel + `>a</a> <a href="http://y"` + rel + ">b</a></p>\n"},
+		{"autolink", "<https://example.com>",
+			`<p><a href="https://example.com"` + rel + ">https://example.com</a></p>\n"},
+		{"bare URL", "see https://example.com/a_(b).",
+			`<p>see <a href="https://example.com/a_(b)"` + rel + ">https://example.com/a_(b)</a>.</p>\n"},
+		{"brackets in link text", "[a [b] c](/x)", `<p><a href="/x"` + rel + ">a [b] c</a></p>\n"},
+
+		// Raw HTML is escaped, never interpreted
+		{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"event handler", "<b onclick=x>hi</b>", "<p>&lt;b onclick=x&gt;hi&lt;/b&gt;</p>\n"},
+		{"HTML in code block", "```js\n<b>\n```", "<pre><code class=\"language-js\">&lt;b&gt;\n</code></pre>\n"},
+
+		// Quotes cannot break out of attributes
+		{"quote in title", `[x](/a "a" onclick="b")`,
+			`<p><a href="/a" title="a&#34; onclick=&#34;b"` + rel + ">x</a></p>\n"},
+		{"unterminated title", `[x](/a "t\" onmouseover=\"alert(1))`, `<p><a href="/a"` + rel + ">x</a></p>\n"},
+		{"quote in fence info", "```js\" onclick=\"x\ncode\n```", "<pre><code>code\n</code></pre>\n"},
+
+		// Emphasis and code spans
+		{"strong in em", "*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{"em in strong", "**a *b* c**", "<p><strong>a <em>b</em> c</strong></p>\n"},
+		{"strikethrough", "~~gone~~", "<p><del>gone</del></p>\n"},
+		{"emphasis in code span", "`*not em*` and *em*", "<p><code>*not em*</code> and <em>em</em></p>\n"},
+		{"backtick in code span", "``a ` b``", "<p><code>a ` b</code></p>\n"},
+		{"intraword underscores", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"escaped delimiter", `\*a\*`, "<p>*a*</p>\n"},
+
+		// Blocks
+		{"line break", "a\nb", "<p>a<br>\nb</p>\n"},
+		{"blocks", "# T\n\n- a\n- b\n\n1. x\n2. y\n\n> q",
+			"<h1>T</h1>\n<ul>\n<li>a\n</li>\n<li>b\n</li>\n</ul>\n<ol>\n<li>x\n</li>\n<li>y\n</li>\n</ol>\n" +
+				"<blockquote>\n<p>q</p>\n</blockquote>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.src); got != tt.want {
+				t.Errorf("Render(%q) =\n%q\nwant\n%q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"javascript href", `<a href="javascript:x">t</a>`, "t"},
+		{"entity encoded scheme", `<a href="&#106;avascript:x">t</a>`, "t"},
+		{"link without href", `<a title="x">t</a>`, "t"},
+		{"rel is replaced", `<a href="/x" rel="opener" target="_blank">t</a>`, `<a href="/x"` + rel + ">t</a>"},
+		{"quote in attribute", `<a href="/x" title='a"b'>t</a>`, `<a href="/x" title="a&#34;b"` + rel + ">t</a>"},
+		{"script and its content", `<p onclick="x">a<script>b</script>c</p>`, "<p>ac</p>"},
+		{"uppercase script", `<SCRIPT>x</SCRIPT>after`, "after"},
+		{"image", `<img src=x onerror=alert(1)>`, ""},
+		{"comment", `<!-- c --><p>x</p>`, "<p>x</p>"},
+		{"bad class", `<code class="language-go x">y</code>`, "<code>y</code>"},
+		{"allowed attribute only", `<ol start="3" type="a"><li>x</li></ol>`, `<ol start="3"><li>x</li></ol>`},
+		{"text is escaped", `1 < 2 & 3 > 2`, "1 &lt; 2 &amp; 3 &gt; 2"},
+		{"open tags are closed", `<em>open`, "<em>open</em>"},
+		{"misnested tags", `<strong><em>x</strong>`, "<strong><em>x</em></strong>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sanitize(tt.in); got != tt.want {
+				t.Errorf("Sanitize(%q) =\n%q\nwant\n%q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// Every link the renderer writes, whatever the source, carries rel
+func TestRenderedLinksHaveRel(t *testing.T) {
+	src := "[a](/a) <https://b.example> https://c.example [d](mailto:d@example.com \"t\")\n\n" +
+		"> [e](/e)\n\n- [f](/f)"
+	html := Render(src)
+	tags := regexp.MustCompile(`<a [^>]*>`).FindAllString(html, -1)
+	if len(tags) != 6 {
+		t.Fatalf("rendered %d links, want 6: %s", len(tags), html)
+	}
+	for _, tag := range tags {
+		if !strings.HasSuffix(tag, rel+">") {
+			t.Errorf("link without rel: %s", tag)
+		}
+	}
+}
+
+func TestMentions(t *testing.T) {
+	src := "@bob `@code` [@link](/x) bob@example.com @Bob @alice.\n\n```\n@fence\n```\n\n*@carol*"
+	want := []string{"bob", "alice", "carol"}
+	if got := Mentions(src); !slices.Equal(got, want) {
+		t.Errorf("Mentions = %q, want %q", got, want)
+	}
+
+	html := RenderMentions("hi @bob and @nobody, `@bob`", func(handle string) (int, string, bool) {
+		return 7, "Bob", strings.EqualFold(handle, "bob")
+	})
+	if want := `<p>hi <a href="/users/7" class="mention">@Bob</a> and @nobody, <code>@bob</code></p>` + "\n"; html != want {
+		t.Errorf("RenderMentions =\n%q\nwant\n%q", html, want)
+	}
+}
@@ -0,0 +1,101 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// mentionPattern matches @handles; the character before the @ is checked
+// separately so addresses like bob@example.com are not mentions
+var mentionPattern = regexp.MustCompile(`@([\p{L}\p{N}_][\p{L}\p{N}_.-]*)`)
+
+// MentionResolver returns the user an @handle refers to
+type MentionResolver func(handle string) (userID int, nickname string, ok bool)
+
+// Mentions returns the distinct @handles written in src, ignoring code and
+// link text, in the order they first appear
+func Mentions(src string) []string {
+	var handles []string
+	seen := make(map[string]bool)
+	eachText(Render(src), func(text string) string {
+		findMentions(text, func(_, _ int, handle string) {
+			if key := strings.ToLower(handle); !seen[key] {
+				seen[key] = true
+				handles = append(handles, handle)
+			}
+		})
+		return text
+	})
+	return handles
+}
+
+// RenderMentions renders src like Render and links every @handle that resolve
+// knows to the user's profile, shown with the user's current nickname
+func RenderMentions(src string, resolve MentionResolver) string {
+	return eachText(Render(src), func(text string) string {
+		var b strings.Builder
+		last := 0
+		findMentions(text, func(start, end int, handle string) {
+			userID, nickname, ok := resolve(handle)
+			if !ok {
+				return
+			}
+			b.WriteString(text[last:start])
+			b.WriteString(`<a href="/users/` + strconv.Itoa(userID) + `" class="mention">@` + html.EscapeString(nickname) + "</a>")
+			last = end
+		})
+		b.WriteString(text[last:])
+		return b.String()
+	})
+}
+
+// findMentions calls fn with the position of each @handle in text
+func findMentions(text string, fn func(start, end int, handle string)) {
+	for _, m := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		if m[0] > 0 && (isWordByte(text[m[0]-1]) || text[m[0]-1] == '@') {
+			continue
+		}
+		// Trailing dots and dashes end the sentence, not the handle
+		handle := strings.TrimRight(text[m[2]:m[3]], ".-")
+		fn(m[0], m[2]+len(handle), handle)
+	}
+}
+
+// eachText replaces the text of rendered HTML outside code blocks and links
+// with the result of fn. It relies on the sanitizer escaping > in attributes.
+func eachText(s string, fn func(string) string) string {
+	var b strings.Builder
+	skip := 0 // depth of code, pre and a elements
+	for len(s) > 0 {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			lt = len(s)
+		}
+		if skip == 0 {
+			b.WriteString(fn(s[:lt]))
+		} else {
+			b.WriteString(s[:lt])
+		}
+		s = s[lt:]
+
+		gt := strings.IndexByte(s, '>')
+		if gt < 0 {
+			b.WriteString(s)
+			break
+		}
+		tag := s[:gt+1]
+		name, _, _ := strings.Cut(strings.Trim(tag, "</>"), " ")
+		if name == "code" || name == "pre" || name == "a" {
+			if strings.HasPrefix(tag, "</") {
+				skip--
+			} else {
+				skip++
+			}
+		}
+		b.WriteString(tag)
+		s = s[gt+1:]
+	}
+	return b.String()
+}
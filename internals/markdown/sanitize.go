@@ -0,0 +1,240 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// linkRel is forced on every link so user content passes no ranking or referrer
+const linkRel = "nofollow noopener noreferrer"
+
+// allowedTags lists the tags kept by Sanitize with the attributes each may carry
+var allowedTags = map[string][]string{
+	"p": nil, "br": nil, "hr": nil,
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"strong": nil, "em": nil, "del": nil,
+	"blockquote": nil, "pre": nil, "code": {"class"},
+	"ul": nil, "ol": {"start"}, "li": nil,
+	"a": {"href", "title"},
+}
+
+// voidTags have no content and no closing tag
+var voidTags = map[string]bool{"br": true, "hr": true}
+
+// droppedTags are removed together with everything inside them
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"template": true, "textarea": true, "title": true, "noscript": true, "svg": true, "math": true,
+}
+
+// attributeValidators check attribute values; attributes without one accept any value
+var attributeValidators = map[string]func(string) bool{
+	"href":  safeURL,
+	"class": regexp.MustCompile(`^language-[A-Za-z0-9_+#.-]+$`).MatchString,
+	"start": regexp.MustCompile(`^[0-9]{1,9}$`).MatchString,
+}
+
+// safeURL accepts http, https and mailto links and relative links
+func safeURL(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+type attribute struct {
+	name, value string
+}
+
+type tag struct {
+	name    string
+	closing bool
+	attrs   []attribute
+}
+
+// Sanitize keeps only allowlisted tags and attributes of an HTML fragment.
+// Text is re-escaped, unsafe links lose their tag, every link gets rel=nofollow
+// and tags left open are closed at the end.
+func Sanitize(s string) string {
+	var b strings.Builder
+	var open []string
+	dropping := "" // the dropped tag whose content is being skipped
+
+	text := func(t string) {
+		if dropping == "" {
+			b.WriteString(html.EscapeString(html.UnescapeString(t)))
+		}
+	}
+
+	for len(s) > 0 {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			text(s)
+			break
+		}
+		text(s[:lt])
+		s = s[lt:]
+
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				break
+			}
+			s = s[end+3:]
+			continue
+		}
+
+		t, rest, ok := parseTag(s)
+		if !ok {
+			text("<")
+			s = s[1:]
+			continue
+		}
+		s = rest
+
+		switch {
+		case dropping != "":
+			if t.closing && t.name == dropping {
+				dropping = ""
+			}
+		case droppedTags[t.name]:
+			if !t.closing {
+				dropping = t.name
+			}
+		case t.closing:
+			// Close the innermost matching tag and anything left open inside it
+			if at := lastIndex(open, t.name); at >= 0 {
+				for len(open) > at {
+					b.WriteString("</" + open[len(open)-1] + ">")
+					open = open[:len(open)-1]
+				}
+			}
+		default:
+			if writeTag(&b, t) && !voidTags[t.name] {
+				open = append(open, t.name)
+			}
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String()
+}
+
+func lastIndex(open []string, name string) int {
+	for i := len(open) - 1; i >= 0; i-- {
+		if open[i] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// writeTag writes an allowed opening tag with its allowed attributes and
+// reports whether it was written
+func writeTag(b *strings.Builder, t tag) bool {
+	allowed, ok := allowedTags[t.name]
+	if !ok {
+		return false
+	}
+
+	var attrs []attribute
+	for _, a := range t.attrs {
+		if !slices.Contains(allowed, a.name) || slices.ContainsFunc(attrs, func(k attribute) bool { return k.name == a.name }) {
+			continue
+		}
+		if valid, ok := attributeValidators[a.name]; ok && !valid(a.value) {
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+
+	if t.name == "a" {
+		if !slices.ContainsFunc(attrs, func(a attribute) bool { return a.name == "href" }) {
+			return false
+		}
+		attrs = append(attrs, attribute{"rel", linkRel})
+	}
+
+	b.WriteString("<" + t.name)
+	for _, a := range attrs {
+		b.WriteString(" " + a.name + `="` + html.EscapeString(a.value) + `"`)
+	}
+	b.WriteString(">")
+	return true
+}
+
+// parseTag reads the tag at the start of s, returning the rest of the input
+func parseTag(s string) (tag, string, bool) {
+	var t tag
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		t.closing = true
+		i++
+	}
+	start := i
+	for i < len(s) && (isWordByte(s[i]) && s[i] < 0x80 || i > start && s[i] == '-') {
+		i++
+	}
+	if i == start || (s[start] >= '0' && s[start] <= '9') {
+		return tag{}, "", false
+	}
+	t.name = strings.ToLower(s[start:i])
+
+	for {
+		for i < len(s) && (isSpace(s[i]) || s[i] == '\r' || s[i] == '\f' || s[i] == '/') {
+			i++
+		}
+		if i >= len(s) {
+			return tag{}, "", false
+		}
+		if s[i] == '>' {
+			return t, s[i+1:], true
+		}
+
+		nameStart := i
+		for i < len(s) && !strings.ContainsRune(" \t\n\r\f/>=\"'", rune(s[i])) {
+			i++
+		}
+		if i == nameStart {
+			// A stray quote cannot start an attribute
+			return tag{}, "", false
+		}
+		a := attribute{name: strings.ToLower(s[nameStart:i])}
+
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				end := strings.IndexByte(s[i+1:], s[i])
+				if end < 0 {
+					return tag{}, "", false
+				}
+				a.value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				valueStart := i
+				for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				a.value = s[valueStart:i]
+			}
+		}
+		a.value = html.UnescapeString(a.value)
+		t.attrs = append(t.attrs, a)
+	}
+}
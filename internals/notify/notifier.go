@@ -0,0 +1,91 @@
+// Package notify is the producer side of notifications: subsystems report
+// what happened and the notifier stores it and pushes it to connected sessions.
+package notify
+
+import (
+	"log"
+
+	"real-time-forum/internals/database"
+	"real-time-forum/internals/realtime"
+)
+
+// Events pushed to connected sessions
+const (
+	EventNotification = "notification"         // a new notification with the unread count
+	EventUnread       = "notifications.unread" // the unread count after reading notifications
+)
+
+// Push is the payload of notification events
+type Push struct {
+	Notification *database.Notification `json:"notification,omitempty"`
+	Unread       int                    `json:"unread"`
+}
+
+type Notifier struct {
+	db  *database.Database
+	hub *realtime.Hub
+}
+
+func New(db *database.Database, hub *realtime.Hub) *Notifier {
+	return &Notifier{db: db, hub: hub}
+}
+
+// Send stores a notification and pushes its group to the user's sessions.
+// Nobody is notified of their own actions, nor of those of users they
+// blocked or were blocked by. Failures are only logged, so a notification
+// never fails the action that caused it.
+func (n *Notifier) Send(e database.NotificationEvent) {
+	if e.UserID == 0 || e.UserID == e.ActorID {
+		return
+	}
+	if e.ActorID != 0 {
+		blocked, err := n.db.Blocked(e.UserID, e.ActorID)
+		if err != nil {
+			log.Printf("Error checking blocks of user %d: %v", e.UserID, err)
+			return
+		}
+		if blocked {
+			return
+		}
+	}
+	id, err := n.db.Notify(e)
+	if err != nil {
+		log.Printf("Error sending %s notification to user %d: %v", e.Kind, e.UserID, err)
+		return
+	}
+	// Pushed even with no session connected, so it is logged for replay
+	notification, err := n.db.GetNotification(e.UserID, id)
+	if err != nil {
+		log.Printf("Error loading notification %d: %v", id, err)
+		return
+	}
+	n.push(e.UserID, EventNotification, notification)
+}
+
+// Moderation notifies a user of a moderator's action on them or their
+// content. Moderators stay anonymous.
+func (n *Notifier) Moderation(userID int, action, targetType string, targetID int, note string) {
+	n.Send(database.NotificationEvent{
+		UserID:     userID,
+		Kind:       database.NotifyModeration,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Action:     action,
+		Note:       note,
+	})
+}
+
+// PushUnread tells the user's other sessions, including those that catch
+// up later, that notifications were read
+func (n *Notifier) PushUnread(userID int) {
+	n.push(userID, EventUnread, nil)
+}
+
+func (n *Notifier) push(userID int, eventType string, notification *database.Notification) {
+	unread, err := n.db.CountUnreadNotifications(userID)
+	if err != nil {
+		log.Printf("Error counting notifications of user %d: %v", userID, err)
+		return
+	}
+	n.hub.Publish(userID, realtime.Event{Type: eventType, Data: Push{Notification: notification, Unread: unread}})
+}
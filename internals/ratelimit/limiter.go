@@ -0,0 +1,225 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions that are rate limited
+const (
+	ActionPost    = "post"
+	ActionComment = "comment"
+	ActionMessage = "message"
+)
+
+// sweepInterval is how often buckets that have refilled completely are dropped
+const sweepInterval = time.Minute
+
+// Limit is a token bucket: Burst requests at once, then one more every Interval
+type Limit struct {
+	Burst    int
+	Interval time.Duration
+}
+
+// Config holds the limits of every action. A user is held to both their own
+// limit and the limit of their IP address.
+type Config struct {
+	User          map[string]Limit // accounts older than NewAccountAge
+	NewUser       map[string]Limit // accounts younger than NewAccountAge
+	IP            map[string]Limit // shared by everyone behind one address
+	NewAccountAge time.Duration
+}
+
+// DefaultConfig is used unless overridden with ConfigFromEnv
+var DefaultConfig = Config{
+	User: map[string]Limit{
+		ActionPost:    {Burst: 5, Interval: time.Minute},
+		ActionComment: {Burst: 10, Interval: 10 * time.Second},
+		ActionMessage: {Burst: 30, Interval: 2 * time.Second},
+	},
+	NewUser: map[string]Limit{
+		ActionPost:    {Burst: 2, Interval: 5 * time.Minute},
+		ActionComment: {Burst: 5, Interval: 30 * time.Second},
+		ActionMessage: {Burst: 10, Interval: 5 * time.Second},
+	},
+	IP: map[string]Limit{
+		ActionPost:    {Burst: 20, Interval: 15 * time.Second},
+		ActionComment: {Burst: 40, Interval: 5 * time.Second},
+		ActionMessage: {Burst: 100, Interval: time.Second},
+	},
+	NewAccountAge: 3 * 24 * time.Hour,
+}
+
+// ConfigFromEnv reads FORUM_RATE_* variables, falling back to the defaults.
+// Limits are written as burst/interval, for example FORUM_RATE_POST=5/1m,
+// FORUM_RATE_NEW_COMMENT=5/30s or FORUM_RATE_IP_MESSAGE=100/1s.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		User:          copyLimits(DefaultConfig.User),
+		NewUser:       copyLimits(DefaultConfig.NewUser),
+		IP:            copyLimits(DefaultConfig.IP),
+		NewAccountAge: DefaultConfig.NewAccountAge,
+	}
+	for _, action := range []string{ActionPost, ActionComment, ActionMessage} {
+		suffix := strings.ToUpper(action)
+		readLimit("FORUM_RATE_"+suffix, cfg.User, action)
+		readLimit("FORUM_RATE_NEW_"+suffix, cfg.NewUser, action)
+		readLimit("FORUM_RATE_IP_"+suffix, cfg.IP, action)
+	}
+	if v := os.Getenv("FORUM_RATE_NEW_ACCOUNT_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			log.Printf("ignoring invalid FORUM_RATE_NEW_ACCOUNT_DAYS=%q", v)
+		} else {
+			cfg.NewAccountAge = time.Duration(days) * 24 * time.Hour
+		}
+	}
+	return cfg
+}
+
+func copyLimits(limits map[string]Limit) map[string]Limit {
+	c := make(map[string]Limit, len(limits))
+	for action, limit := range limits {
+		c[action] = limit
+	}
+	return c
+}
+
+func readLimit(name string, limits map[string]Limit, action string) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	limit, err := ParseLimit(v)
+	if err != nil {
+		log.Printf("ignoring invalid %s=%q: %v", name, v, err)
+		return
+	}
+	limits[action] = limit
+}
+
+// ParseLimit reads a limit written as burst/interval, such as 5/1m
+func ParseLimit(s string) (Limit, error) {
+	burstStr, intervalStr, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("expected burst/interval")
+	}
+	burst, err := strconv.Atoi(burstStr)
+	if err != nil || burst < 1 {
+		return Limit{}, fmt.Errorf("burst must be a positive integer")
+	}
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil || interval <= 0 {
+		return Limit{}, fmt.Errorf("interval must be a positive duration")
+	}
+	return Limit{Burst: burst, Interval: interval}, nil
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// refill brings the bucket up to date and returns its token count
+func (b *bucket) refill(now time.Time) float64 {
+	elapsed := now.Sub(b.updated)
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed.Seconds()/b.limit.Interval.Seconds())
+	b.updated = now
+	return b.tokens
+}
+
+// wait is how long until the bucket holds a whole token again
+func (b *bucket) wait() time.Duration {
+	return time.Duration((1 - b.tokens) * float64(b.limit.Interval))
+}
+
+// Limiter keeps the token buckets of every user and IP address in memory
+type Limiter struct {
+	mu        sync.Mutex
+	config    Config
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	// clock replaces time.Now, so tests can move it
+	clock func() time.Time
+}
+
+func New(config Config) *Limiter {
+	return &Limiter{config: config, buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+func (l *Limiter) now() time.Time {
+	if l.clock != nil {
+		return l.clock()
+	}
+	return time.Now()
+}
+
+// Allow takes a token for the action from both the user's and the IP's
+// bucket. Either both are charged or neither is; when denied it returns how
+// long to wait before retrying.
+func (l *Limiter) Allow(action string, userID int, ip string, accountAge time.Duration) (bool, time.Duration) {
+	userLimits := l.config.User
+	if accountAge < l.config.NewAccountAge {
+		userLimits = l.config.NewUser
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	var buckets []*bucket
+	if limit, ok := userLimits[action]; ok {
+		buckets = append(buckets, l.bucket(fmt.Sprintf("user:%d:%s", userID, action), limit, now))
+	}
+	if limit, ok := l.config.IP[action]; ok && ip != "" {
+		buckets = append(buckets, l.bucket("ip:"+ip+":"+action, limit, now))
+	}
+
+	var retryAfter time.Duration
+	for _, b := range buckets {
+		if b.refill(now) < 1 && b.wait() > retryAfter {
+			retryAfter = b.wait()
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// bucket returns the bucket for key, starting full. A changed limit, such as
+// an account no longer counting as new, takes effect on the existing bucket.
+func (l *Limiter) bucket(key string, limit Limit, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		l.buckets[key] = b
+	}
+	b.limit = limit
+	return b
+}
+
+// sweep drops buckets that have refilled, since they behave like new ones
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.refill(now) >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter whose clock the test controls
+func newTestLimiter(config Config) (*Limiter, *time.Time) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := New(config)
+	l.clock = func() time.Time { return now }
+	l.lastSweep = now
+	return l, &now
+}
+
+var testConfig = Config{
+	User:          map[string]Limit{ActionPost: {Burst: 2, Interval: 10 * time.Second}},
+	NewUser:       map[string]Limit{ActionPost: {Burst: 1, Interval: time.Minute}},
+	IP:            map[string]Limit{ActionPost: {Burst: 3, Interval: 10 * time.Second}},
+	NewAccountAge: 24 * time.Hour,
+}
+
+const oldAccount = 48 * time.Hour
+
+func TestAllowRefillsOverTime(t *testing.T) {
+	l, now := newTestLimiter(testConfig)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow(ActionPost, 1, "192.0.2.1", oldAccount); !ok {
+			t.Fatalf("request %d within the burst was denied", i+1)
+		}
+	}
+	ok, wait := l.Allow(ActionPost, 1, "192.0.2.1", oldAccount)
+	if ok || wait != 10*time.Second {
+		t.Fatalf("Allow after the burst = %v, %v; want denied for 10s", ok, wait)
+	}
+
+	*now = now.Add(4 * time.Second)
+	if ok, wait := l.Allow(ActionPost, 1, "192.0.2.1", oldAccount); ok || wait != 6*time.Second {
+		t.Errorf("Allow after 4s = %v, %v; want denied for 6s", ok, wait)
+	}
+	*now = now.Add(6 * time.Second)
+	if ok, _ := l.Allow(ActionPost, 1, "192.0.2.1", oldAccount); !ok {
+		t.Error("Allow once a token refilled was denied")
+	}
+
+	// Buckets never hold more than their burst
+	*now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow(ActionPost, 1, "192.0.2.1", oldAccount); !ok {
+			t.Fatalf("request %d after an hour was denied", i+1)
+		}
+	}
+	if ok, _ := l.Allow(ActionPost, 1, "192.0.2.1", oldAccount); ok {
+		t.Error("bucket refilled beyond its burst")
+	}
+}
+
+func TestAllowChargesUserAndIPTogether(t *testing.T) {
+	l, _ := newTestLimiter(testConfig)
+
+	// The user bucket runs out first; the denied request must not use up
+	// the IP bucket shared with others
+	for i := 0; i < 5; i++ {
+		l.Allow(ActionPost, 1, "192.0.2.1", oldAccount)
+	}
+	if ok, _ := l.Allow(ActionPost, 2, "192.0.2.1", oldAccount); !ok {
+		t.Fatal("denied requests of one user used up the IP bucket")
+	}
+	if ok, _ := l.Allow(ActionPost, 3, "192.0.2.1", oldAccount); ok {
+		t.Fatal("IP bucket allowed more than its burst")
+	}
+
+	// The IP bucket is now empty, so user 4 is denied without being charged
+	if ok, _ := l.Allow(ActionPost, 4, "192.0.2.1", oldAccount); ok {
+		t.Fatal("IP bucket allowed more than its burst")
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow(ActionPost, 4, "198.51.100.1", oldAccount); !ok {
+			t.Fatalf("request %d from another address was denied after a denial on a busy IP", i+1)
+		}
+	}
+}
+
+func TestAllowNewAccounts(t *testing.T) {
+	l, now := newTestLimiter(testConfig)
+
+	if ok, _ := l.Allow(ActionPost, 1, "", time.Hour); !ok {
+		t.Fatal("first request of a new account was denied")
+	}
+	if ok, wait := l.Allow(ActionPost, 1, "", time.Hour); ok || wait != time.Minute {
+		t.Fatalf("second request of a new account = %v, %v; want denied for 1m", ok, wait)
+	}
+
+	// Once the account is old enough, its bucket refills at the regular
+	// rate rather than waiting out the rest of the minute
+	*now = now.Add(10 * time.Second)
+	if ok, wait := l.Allow(ActionPost, 1, "", oldAccount); !ok {
+		t.Errorf("request of an account no longer new was denied for %v", wait)
+	}
+}
+
+func TestAllowUnlimitedAction(t *testing.T) {
+	l, _ := newTestLimiter(testConfig)
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow(ActionMessage, 1, "192.0.2.1", oldAccount); !ok {
+			t.Fatal("action without limits was denied")
+		}
+	}
+}
+
+func TestSweepDropsFullBuckets(t *testing.T) {
+	l, now := newTestLimiter(testConfig)
+	l.Allow(ActionPost, 1, "192.0.2.1", oldAccount)
+	l.Allow(ActionPost, 1, "192.0.2.1", oldAccount)
+	*now = now.Add(15 * time.Second)
+	l.Allow(ActionPost, 2, "198.51.100.1", oldAccount)
+	if len(l.buckets) != 4 {
+		t.Fatalf("%d buckets before sweeping, want 4", len(l.buckets))
+	}
+
+	// After sweepInterval, only the buckets used since are still below their
+	// burst; user 1's have refilled and behave like new ones
+	*now = now.Add(sweepInterval - 15*time.Second)
+	l.Allow(ActionPost, 3, "203.0.113.1", oldAccount)
+	for key := range l.buckets {
+		switch key {
+		case "user:3:post", "ip:203.0.113.1:post":
+		default:
+			t.Errorf("bucket %s survived the sweep", key)
+		}
+	}
+	if len(l.buckets) != 2 {
+		t.Errorf("%d buckets after sweeping, want 2", len(l.buckets))
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Limit
+		wantErr bool
+	}{
+		{"5/1m", Limit{Burst: 5, Interval: time.Minute}, false},
+		{"100/500ms", Limit{Burst: 100, Interval: 500 * time.Millisecond}, false},
+		{"5", Limit{}, true},
+		{"0/1m", Limit{}, true},
+		{"-1/1m", Limit{}, true},
+		{"x/1m", Limit{}, true},
+		{"5/0s", Limit{}, true},
+		{"5/-1s", Limit{}, true},
+		{"5/soon", Limit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLimit(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// clientBuffer is how many events may queue for a connection before it is
+// considered too slow and dropped
+const clientBuffer = 32
+
+// Event is a message pushed to a user's live connections. Published events
+// are numbered per user by Seq, in the order they were published.
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+	Seq  int64       `json:"seq,omitempty"`
+
+	// Transient events, such as typing indicators, only matter live: they
+	// are neither numbered nor logged for replay
+	Transient bool `json:"-"`
+}
+
+// EventLog durably keeps the latest published events of each user, so that
+// clients can catch up on what they missed while disconnected
+type EventLog interface {
+	// AppendEvent stores an event with its JSON data and returns its sequence number
+	AppendEvent(userID int, eventType string, data []byte) (int64, error)
+	// EventsSince returns the events after a sequence number, oldest first.
+	// complete is false when some of them are no longer kept.
+	EventsSince(userID int, seq int64) (events []Event, complete bool, err error)
+	// LastEventSeq returns the sequence number of the latest event, 0 if none
+	LastEventSeq(userID int) (int64, error)
+}
+
+// Client is one live connection of a user
+type Client struct {
+	UserID int
+	Seq    int64 // the user's latest event when the client registered; later ones go to Events
+	Events chan Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Done is closed when the hub disconnects the client
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// userLock serializes publishing to one user
+type userLock struct {
+	sync.Mutex
+	refs int // goroutines holding or waiting for the lock
+}
+
+// Hub keeps track of the live connections of every user
+type Hub struct {
+	mu      sync.Mutex
+	clients map[int]map[*Client]struct{}
+	users   map[int]*userLock // only for users being published to or registering
+	log     EventLog          // nil leaves events unnumbered
+}
+
+func NewHub(eventLog EventLog) *Hub {
+	return &Hub{
+		clients: make(map[int]map[*Client]struct{}),
+		users:   make(map[int]*userLock),
+		log:     eventLog,
+	}
+}
+
+// lockUser takes the user's lock and returns the function releasing it. The
+// event log is written under it rather than under the hub's lock, so a slow
+// write only holds up events of the same user.
+func (h *Hub) lockUser(userID int) func() {
+	h.mu.Lock()
+	l := h.users[userID]
+	if l == nil {
+		l = &userLock{}
+		h.users[userID] = l
+	}
+	l.refs++
+	h.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		h.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(h.users, userID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// Register adds a connection for the user
+func (h *Hub) Register(userID int) *Client {
+	c := &Client{UserID: userID, Events: make(chan Event, clientBuffer), done: make(chan struct{})}
+	unlock := h.lockUser(userID)
+	defer unlock()
+	if h.log != nil {
+		// Under the user's lock, so no event is published in between
+		seq, err := h.log.LastEventSeq(userID)
+		if err != nil {
+			log.Printf("Error loading event log of user %d: %v", userID, err)
+		}
+		c.Seq = seq
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[userID] == nil {
+		h.clients[userID] = make(map[*Client]struct{})
+	}
+	h.clients[userID][c] = struct{}{}
+	return c
+}
+
+// Unregister removes a connection once it has ended
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(c)
+}
+
+func (h *Hub) remove(c *Client) {
+	if conns := h.clients[c.UserID]; conns != nil {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(h.clients, c.UserID)
+		}
+	}
+	c.close()
+}
+
+// Publish numbers and logs an event, then sends it to every connection of
+// the user. Connections whose buffer is full are dropped rather than blocking
+// the publisher; they catch up when they reconnect. Events of a user are
+// logged and delivered under their lock, so sequence numbers follow delivery
+// order.
+func (h *Hub) Publish(userID int, event Event) {
+	unlock := h.lockUser(userID)
+	defer unlock()
+	if h.log != nil && !event.Transient {
+		h.record(userID, &event)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients[userID] {
+		select {
+		case c.Events <- event:
+		default:
+			h.remove(c)
+		}
+	}
+}
+
+// record logs an event and numbers it. An event that cannot be logged is
+// still delivered live, unnumbered.
+func (h *Hub) record(userID int, event *Event) {
+	var data []byte
+	if event.Data != nil {
+		var err error
+		if data, err = json.Marshal(event.Data); err != nil {
+			log.Printf("Error encoding %s event: %v", event.Type, err)
+			return
+		}
+	}
+	seq, err := h.log.AppendEvent(userID, event.Type, data)
+	if err != nil {
+		log.Printf("Error logging %s event for user %d: %v", event.Type, userID, err)
+		return
+	}
+	event.Seq = seq
+}
+
+// Replay returns the logged events of a user after a sequence number, oldest
+// first. ok is false when they cannot all be replayed and the client has to
+// reload its state instead.
+func (h *Hub) Replay(userID int, seq int64) (events []Event, ok bool, err error) {
+	if h.log == nil {
+		return nil, false, nil
+	}
+	return h.log.EventsSince(userID, seq)
+}
+
+// Disconnect sends a final event to every connection of the user and closes them
+func (h *Hub) Disconnect(userID int, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients[userID] {
+		select {
+		case c.Events <- event:
+		default:
+		}
+		h.remove(c)
+	}
+}
+
+// IsConnected reports whether the user has at least one live connection
+func (h *Hub) IsConnected(userID int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients[userID]) > 0
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minPartSize is the smallest part S3 accepts in a multipart upload, but
+// for the last one
+const minPartSize = 5 << 20
+
+// S3Config points at a bucket of Amazon S3 or a compatible store
+type S3Config struct {
+	Endpoint  string // like https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool  // address the bucket as endpoint/bucket rather than bucket.endpoint
+	PartSize  int64 // files larger than this are uploaded in parts of this size
+}
+
+// S3 stores files as objects of an S3-compatible bucket, signing every
+// request with AWS Signature Version 4
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	signer   signer
+	client   *http.Client
+}
+
+func NewS3(config S3Config) (*S3, error) {
+	if config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("S3 storage needs a bucket, an access key and a secret key")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", config.Endpoint)
+	}
+	if config.PartSize < minPartSize {
+		config.PartSize = 16 << 20
+	}
+	return &S3{
+		config:   config,
+		endpoint: endpoint,
+		signer:   signer{accessKey: config.AccessKey, secretKey: config.SecretKey, region: config.Region},
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// objectURL addresses an object, with optional query parameters
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	if s.config.PathStyle {
+		u.Path += "/" + s.config.Bucket + "/" + key
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path += "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// s3Error is the error document S3 answers failed requests with
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do signs and sends a request for an object. The body is hashed for the
+// signature, so it is passed as bytes.
+func (s *S3) do(method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build S3 request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = int64(len(body))
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	s.signer.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s failed: %w", method, key, err)
+	}
+	if resp.StatusCode == http.StatusNotFound && method != http.MethodPost {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var e s3Error
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		return nil, fmt.Errorf("S3 %s %s failed: %s %s: %s", method, key, resp.Status, e.Code, e.Message)
+	}
+	return resp, nil
+}
+
+// Put uploads files of up to PartSize bytes with a single request and
+// larger ones, or ones of unknown size, in parts
+func (s *S3) Put(key string, r io.Reader, size int64, contentType string) error {
+	if size < 0 || size > s.config.PartSize {
+		return s.putMultipart(key, r, contentType)
+	}
+	body, err := io.ReadAll(io.LimitReader(r, size+1))
+	if err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	if int64(len(body)) != size {
+		return fmt.Errorf("failed to read upload: got %d bytes, expected %d", len(body), size)
+	}
+	resp, err := s.do(http.MethodPut, key, nil, body, http.Header{"Content-Type": {contentType}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart uploads a file part by part, aborting the upload on failure
+// so the bucket does not keep the parts
+func (s *S3) putMultipart(key string, r io.Reader, contentType string) error {
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil, http.Header{"Content-Type": {contentType}})
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("failed to start multipart upload of %s: %v", key, err)
+	}
+	upload := url.Values{"uploadId": {initiated.UploadID}}
+
+	var parts []completedPart
+	err = func() error {
+		buf := make([]byte, s.config.PartSize)
+		for number := 1; ; number++ {
+			n, err := io.ReadFull(r, buf)
+			if err == io.EOF && number > 1 {
+				return nil
+			}
+			if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+				return fmt.Errorf("failed to read upload: %w", err)
+			}
+			query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": upload["uploadId"]}
+			resp, partErr := s.do(http.MethodPut, key, query, buf[:n], nil)
+			if partErr != nil {
+				return partErr
+			}
+			resp.Body.Close()
+			parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+			if err != nil {
+				return nil // the last, short part
+			}
+		}
+	}()
+	if err == nil {
+		var body []byte
+		body, err = xml.Marshal(struct {
+			XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+			Parts   []completedPart `xml:"Part"`
+		}{Parts: parts})
+		if err == nil {
+			resp, err = s.do(http.MethodPost, key, upload, body, nil)
+		}
+		if err == nil {
+			// S3 may report a failed completion with a 200 and an error document
+			var result struct {
+				XMLName xml.Name
+				s3Error
+			}
+			decodeErr := xml.NewDecoder(resp.Body).Decode(&result)
+			resp.Body.Close()
+			if decodeErr == nil && result.XMLName.Local == "Error" {
+				err = fmt.Errorf("failed to complete multipart upload of %s: %s: %s", key, result.Code, result.Message)
+			}
+		}
+	}
+	if err != nil {
+		if resp, abortErr := s.do(http.MethodDelete, key, upload, nil, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *S3) Open(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Exists tells whether an object is stored under key
+func (s *S3) Exists(key string) (bool, error) {
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// PresignGet returns a URL that downloads an object without credentials
+// until it expires. The bucket answers with the given content type and
+// disposition rather than the stored ones.
+func (s *S3) PresignGet(key string, expires time.Duration, contentType, disposition string) (string, error) {
+	query := url.Values{}
+	if contentType != "" {
+		query.Set("response-content-type", contentType)
+	}
+	if disposition != "" {
+		query.Set("response-content-disposition", disposition)
+	}
+	return s.signer.presign(http.MethodGet, s.objectURL(key, query), expires, time.Now()), nil
+}
@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory stand-in for an S3-compatible store, serving one
+// bucket with path-style addressing
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+	uploads map[string]map[int][]byte // parts of open multipart uploads by upload ID
+	aborted []string
+	nextID  int
+
+	// failPart makes the upload of this part number fail when set
+	failPart int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3) {
+	t.Helper()
+	f := &fakeS3{
+		bucket:  "forum",
+		objects: map[string][]byte{},
+		types:   map[string]string{},
+		uploads: map[string]map[int][]byte{},
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	s3, err := NewS3(S3Config{
+		Endpoint:  server.URL,
+		Bucket:    f.bucket,
+		AccessKey: "AKID",
+		SecretKey: "secret",
+		PathStyle: true,
+		PartSize:  minPartSize,
+	})
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	return f, s3
+}
+
+// etag is the ETag S3 gives an uploaded part
+func etag(data []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(data))
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), sigAlgorithm+" Credential=AKID/") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		id := "upload-" + strconv.Itoa(f.nextID)
+		f.uploads[id] = map[int][]byte{}
+		f.types[key] = r.Header.Get("Content-Type")
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+
+	case r.Method == http.MethodPut && uploadID != "":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			http.Error(w, "no such upload", http.StatusNotFound)
+			return
+		}
+		if number == f.failPart {
+			http.Error(w, "<Error><Code>InternalError</Code></Error>", http.StatusInternalServerError)
+			return
+		}
+		parts[number] = body
+		w.Header().Set("ETag", etag(body))
+
+	case r.Method == http.MethodPost && uploadID != "":
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			http.Error(w, "no such upload", http.StatusNotFound)
+			return
+		}
+		var complete struct {
+			Parts []completedPart `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var object []byte
+		for i, part := range complete.Parts {
+			data, ok := parts[part.PartNumber]
+			if part.PartNumber != i+1 || !ok || part.ETag != etag(data) {
+				fmt.Fprint(w, "<Error><Code>InvalidPart</Code><Message>bad part list</Message></Error>")
+				return
+			}
+			object = append(object, data...)
+		}
+		if len(complete.Parts) != len(parts) {
+			fmt.Fprint(w, "<Error><Code>InvalidPart</Code><Message>missing parts</Message></Error>")
+			return
+		}
+		f.objects[key] = object
+		delete(f.uploads, uploadID)
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		f.aborted = append(f.aborted, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+		f.types[key] = r.Header.Get("Content-Type")
+
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", f.types[key])
+		w.Write(data)
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// testData returns n bytes that differ between parts
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i / minPartSize * 7)
+	}
+	return data
+}
+
+func TestS3PutOpenDelete(t *testing.T) {
+	f, s3 := newFakeS3(t)
+
+	data := []byte("hello, bucket")
+	if err := s3.Put("attachments/a b", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got := f.types["attachments/a b"]; got != "text/plain" {
+		t.Errorf("stored content type = %q, want text/plain", got)
+	}
+
+	r, err := s3.Open("attachments/a b")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Open read %q, %v; want %q", got, err, data)
+	}
+	if ok, err := s3.Exists("attachments/a b"); err != nil || !ok {
+		t.Errorf("Exists = %v, %v; want true", ok, err)
+	}
+
+	if err := s3.Delete("attachments/a b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s3.Open("attachments/a b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete = %v, want ErrNotFound", err)
+	}
+	if err := s3.Delete("attachments/a b"); err != nil {
+		t.Errorf("Delete of a missing key = %v, want nil", err)
+	}
+}
+
+func TestS3PutShortRead(t *testing.T) {
+	_, s3 := newFakeS3(t)
+	if err := s3.Put("short", strings.NewReader("abc"), 10, "text/plain"); err == nil {
+		t.Error("Put succeeded with fewer bytes than announced")
+	}
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	f, s3 := newFakeS3(t)
+
+	data := testData(2*minPartSize + 1234)
+	if err := s3.Put("big", bytes.NewReader(data), int64(len(data)), "application/zip"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if !bytes.Equal(f.objects["big"], data) {
+		t.Error("object assembled from the parts differs from the upload")
+	}
+	if len(f.uploads) != 0 || len(f.aborted) != 0 {
+		t.Errorf("uploads left open: %d, aborted: %v", len(f.uploads), f.aborted)
+	}
+
+	// Unknown sizes go through multipart uploads too
+	if err := s3.Put("unknown", bytes.NewReader(data[:100]), -1, "application/zip"); err != nil {
+		t.Fatalf("Put of unknown size: %v", err)
+	}
+	if !bytes.Equal(f.objects["unknown"], data[:100]) {
+		t.Error("object of unknown size differs from the upload")
+	}
+}
+
+func TestS3MultipartAbortsOnFailure(t *testing.T) {
+	f, s3 := newFakeS3(t)
+	f.failPart = 2
+
+	data := testData(2*minPartSize + 1)
+	if err := s3.Put("big", bytes.NewReader(data), int64(len(data)), "application/zip"); err == nil {
+		t.Fatal("Put succeeded although a part failed")
+	}
+	if _, ok := f.objects["big"]; ok {
+		t.Error("object stored although a part failed")
+	}
+	if len(f.aborted) != 1 || len(f.uploads) != 0 {
+		t.Errorf("aborted uploads = %v, open uploads = %d; want the upload aborted", f.aborted, len(f.uploads))
+	}
+}
+
+func TestS3PresignGet(t *testing.T) {
+	_, s3 := newFakeS3(t)
+
+	link, err := s3.PresignGet("attachments/x", 10*time.Minute, "image/png", `inline; filename="x.png"`)
+	if err != nil {
+		t.Fatalf("PresignGet: %v", err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("invalid presigned URL %q: %v", link, err)
+	}
+	if u.Path != "/forum/attachments/x" {
+		t.Errorf("path = %q, want /forum/attachments/x", u.Path)
+	}
+	query := u.Query()
+	for name, want := range map[string]string{
+		"X-Amz-Algorithm":              sigAlgorithm,
+		"X-Amz-Expires":                "600",
+		"X-Amz-SignedHeaders":          "host",
+		"response-content-type":        "image/png",
+		"response-content-disposition": `inline; filename="x.png"`,
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if !strings.HasPrefix(query.Get("X-Amz-Credential"), "AKID/") || len(query.Get("X-Amz-Signature")) != 64 {
+		t.Errorf("missing credential or signature in %q", link)
+	}
+}
@@ -0,0 +1,82 @@
+// Package storage keeps uploaded files, such as avatars and attachments,
+// behind a small interface so they can live somewhere else than the disk of
+// the app server.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+var ErrNotFound = errors.New("stored file not found")
+
+// Backend stores files under slash separated keys like "avatars/ab/abc.png"
+type Backend interface {
+	// Put stores size bytes read from r under key, replacing what was there
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Open returns the content stored under key, or ErrNotFound
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes what is stored under key; missing keys are not an error
+	Delete(key string) error
+}
+
+// Presigner is implemented by backends that can hand out expiring links to
+// download a file straight from them
+type Presigner interface {
+	PresignGet(key string, expires time.Duration, contentType, disposition string) (string, error)
+}
+
+// DefaultDir is where files are kept on disk unless FORUM_UPLOAD_DIR says otherwise
+const DefaultDir = "uploads"
+
+// LocalFromEnv returns the disk backend under FORUM_UPLOAD_DIR
+func LocalFromEnv() *Local {
+	dir := os.Getenv("FORUM_UPLOAD_DIR")
+	if dir == "" {
+		dir = DefaultDir
+	}
+	return NewLocal(dir)
+}
+
+// S3FromEnv returns the S3 backend configured with FORUM_S3_ENDPOINT,
+// FORUM_S3_REGION, FORUM_S3_BUCKET, FORUM_S3_ACCESS_KEY, FORUM_S3_SECRET_KEY,
+// FORUM_S3_PATH_STYLE and FORUM_S3_PART_SIZE. Path style addressing is the
+// default with a custom endpoint, as self-hosted stores expect it.
+func S3FromEnv() (*S3, error) {
+	config := S3Config{
+		Endpoint:  os.Getenv("FORUM_S3_ENDPOINT"),
+		Region:    os.Getenv("FORUM_S3_REGION"),
+		Bucket:    os.Getenv("FORUM_S3_BUCKET"),
+		AccessKey: os.Getenv("FORUM_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("FORUM_S3_SECRET_KEY"),
+	}
+	config.PathStyle = config.Endpoint != ""
+	if v := os.Getenv("FORUM_S3_PATH_STYLE"); v != "" {
+		config.PathStyle = v == "true"
+	}
+	if v := os.Getenv("FORUM_S3_PART_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < minPartSize {
+			return nil, fmt.Errorf("invalid FORUM_S3_PART_SIZE=%q, parts are at least %d bytes", v, minPartSize)
+		}
+		config.PartSize = n
+	}
+	return NewS3(config)
+}
+
+// FromEnv returns the backend chosen with FORUM_STORAGE: "local", the
+// default, or "s3"
+func FromEnv() (Backend, error) {
+	switch backend := os.Getenv("FORUM_STORAGE"); backend {
+	case "", "local":
+		return LocalFromEnv(), nil
+	case "s3":
+		return S3FromEnv()
+	default:
+		return nil, fmt.Errorf("unknown FORUM_STORAGE=%q, expected local or s3", backend)
+	}
+}
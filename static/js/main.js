@@ -573,7 +573,11 @@ function formatDate(dateString) {
 
 // Load online users
 function loadOnlineUsers() {
-    fetch('/api/online-users')
+    // Signed in, we may see more of the users who follow us
+    const token = localStorage.getItem('forum_token');
+    fetch('/api/online-users', {
+        headers: token ? { 'Authorization': `Bearer ${token}` } : {}
+    })
         .then(response => {
             if (!response.ok) {
                 throw new Error(`Failed to load online users: ${response.status}`);
@@ -632,7 +636,7 @@ function createUserElement(user) {
             <div class="user-info">
                 <div class="user-name">${user.nickname}</div>
                 <div class="user-status">
-                    ${user.online ? 'Online' : `Last seen ${lastSeen}`}
+                    ${user.online ? 'Online' : (lastSeen ? `Last seen ${lastSeen}` : 'Offline')}
                 </div>
             </div>
             ${!isCurrentUser ? `<button class="chat-user-icon" data-id="${user.id}" data-username="${user.nickname}">